@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
 	"strings"
+	"syscall"
 
 	"github.com/charlesthegreat77/goiso9660/iso9660"
 )
@@ -13,14 +19,58 @@ var (
 	inputDirectory string
 	outputISO      string
 	hiddenFiles    string
+	bootImage      string
+	efiBootImage   string
+	efiBootDir     string
+	bootInfoTable  bool
+	hybridMBR      string
+	hybridGPT      bool
+	appendESP      string
+	multiExtent    bool
+	isoLevel       int
+	enhanced       bool
+	nameMap        bool
+	clampPT        bool
+	readAhead      int
+	rockRidge      bool
+	relaxations    iso9660.NameRelaxations
+	serveAddr      string
+	showProgress   bool
 	help           bool
 )
 
 func main() {
 	flag.StringVar(&inputDirectory, "i", "", "specify path to directory/file")
-	flag.StringVar(&outputISO, "o", "output.iso", "specify the output file")
+	flag.StringVar(&outputISO, "o", "output.iso", "specify the output file, - for stdout")
 	flag.StringVar(&hiddenFiles, "H", "", "specify files to hide in the iso file [separated by comma]")
+	flag.StringVar(&bootImage, "b", "", "specify an El Torito boot image [path relative to the input directory]")
+	flag.StringVar(&efiBootImage, "e", "", "specify an El Torito UEFI boot image [path relative to the input directory]")
+	flag.StringVar(&efiBootDir, "efi-dir", "", "specify a directory [relative to the input directory] packed into a generated FAT image for UEFI El Torito boot")
+	flag.BoolVar(&bootInfoTable, "boot-info-table", false, "patch a boot info table into the -b boot image (isolinux)")
+	flag.StringVar(&hybridMBR, "isohybrid-mbr", "", "specify MBR boot code (e.g. isohdpfx.bin) for a USB bootable hybrid image")
+	flag.BoolVar(&hybridGPT, "isohybrid-gpt", false, "write a GPT instead of a plain MBR partition table (hybrid image)")
+	flag.StringVar(&appendESP, "append-esp", "", "specify a FAT image appended as EFI System Partition, also used for UEFI El Torito boot")
+	flag.BoolVar(&rockRidge, "R", false, "add Rock Ridge entries: POSIX names, permissions, owners, symlinks and device nodes")
+	flag.BoolVar(&multiExtent, "multi-extent", false, "allow files of 4 GiB and more, recorded as several extents (ISO 9660 Level 3)")
+	flag.IntVar(&isoLevel, "iso-level", 1, "specify the ISO 9660 interchange level [1, 2 or 3]")
+	flag.BoolVar(&relaxations.AllowLowercase, "allow-lowercase", false, "keep lowercase letters in ISO 9660 names")
+	flag.BoolVar(&relaxations.AllowMultiDot, "allow-multidot", false, "keep all dots in ISO 9660 names")
+	flag.BoolVar(&relaxations.OmitVersion, "N", false, "omit the ;1 version number of ISO 9660 file names")
+	flag.BoolVar(&relaxations.AllowLeadingDots, "allow-leading-dots", false, "keep a leading dot in ISO 9660 names")
+	flag.BoolVar(&relaxations.MaxLength37, "max-iso9660-filenames", false, "allow 37 character ISO 9660 names (implies -N)")
+	flag.BoolVar(&enhanced, "iso-level-4", false, "add an ISO 9660:1999 Enhanced Volume Descriptor tree (207 byte names, no depth limit)")
+	flag.BoolVar(&clampPT, "clamp-path-tables", false, "allow more than 65535 directories, clamping path table parent numbers")
+	flag.IntVar(&readAhead, "read-ahead", 0, "read upcoming files with N workers while writing file data")
+	flag.BoolVar(&nameMap, "name-map", false, "print the table of source paths and their recorded names after building")
+	flag.StringVar(&serveAddr, "addr", ":8080", "serve: specify the address to listen on")
+	flag.BoolVar(&showProgress, "progress", true, "show a progress bar on stderr (only when it is a terminal)")
 	flag.BoolVar(&help, "h", false, "show usage")
+
+	// "serve" exposes the directory as an ISO over HTTP, generated on the fly, instead of writing it
+	serve := len(os.Args) > 1 && os.Args[1] == "serve"
+	if serve {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
 	flag.Parse()
 
 	if help || inputDirectory == "" {
@@ -36,18 +86,55 @@ func main() {
 		}
 	}
 
-	log.Printf("Building ISO from '%s' to '%s'", inputDirectory, outputISO)
+	if !serve {
+		log.Printf("Building ISO from '%s' to '%s'", inputDirectory, outputISO)
+	}
 
 	opts := iso9660.DefaultOptions() // optional
 	opts.VolumeIdentifierISO = "MyCD_ISO"
 	opts.VolumeIdentifierJoliet = "MyCD_Joliet"
 	opts.ApplicationIdentifierISO = "MyApplication"
 	opts.PublisherIdentifierISO = "MyPublisher"
+	opts.RockRidge = rockRidge
+	opts.MultiExtent = multiExtent
+	opts.InterchangeLevel = isoLevel
+	opts.Relaxations = relaxations
+	opts.EnhancedVolumeDescriptor = enhanced
+	opts.ClampPathTables = clampPT
+	opts.ReadAheadWorkers = readAhead
+
+	if hybridMBR != "" || hybridGPT || appendESP != "" {
+		opts.Hybrid = &iso9660.HybridOptions{MBRTemplate: hybridMBR, GPT: hybridGPT, EFIImage: appendESP}
+	}
 
 	builder := iso9660.NewBuilder(inputDirectory, outputISO, opts)
+	stopProgress := func() {}
+	if showProgress && isTerminal(os.Stderr) {
+		bar := &progressBar{out: os.Stderr}
+		builder.SetProgressFunc(bar.update)
+		log.SetOutput(bar) // warnings are printed above the bar
+		stopProgress = func() {
+			bar.finish()
+			log.SetOutput(os.Stderr)
+		}
+	}
+	if bootImage != "" {
+		// the first entry is the catalog's default, 4 virtual sectors as isolinux expects
+		builder.AddBootEntry(iso9660.BootEntry{Platform: iso9660.BootPlatformX86, Path: bootImage, SectorCount: 4, BootInfoTable: bootInfoTable})
+	}
+	if efiBootImage != "" {
+		builder.AddBootEntry(iso9660.BootEntry{Platform: iso9660.BootPlatformEFI, Path: efiBootImage})
+	}
+	if efiBootDir != "" {
+		builder.AddEFIBootImage(efiBootDir)
+	}
+	if appendESP != "" { // El Torito entry sharing the appended partition's data
+		builder.AddBootEntry(iso9660.BootEntry{Platform: iso9660.BootPlatformEFI, ImageFile: appendESP})
+	}
 
 	// ScanSourceDirectory is part of the public API and should be called separately
 	if err := builder.ScanSourceDirectory(); err != nil {
+		stopProgress()
 		log.Fatalf("Error scanning directory: %v", err)
 	}
 
@@ -57,9 +144,48 @@ func main() {
 		log.Printf("Warning during MarkFileNamesAsHidden: %v", err)
 	}
 
-	if err := builder.Build(); err != nil {
-		log.Fatalf("Error building ISO: %v", err)
+	if serve {
+		handler, err := builder.Handler()
+		stopProgress()
+		if err != nil {
+			log.Fatalf("Error laying out ISO: %v", err)
+		}
+		urlPath := "/" + path.Base(outputISO)
+		http.Handle(urlPath, handler)
+		log.Printf("Serving '%s' as http://%s%s (%d bytes)", inputDirectory, serveAddr, urlPath, handler.Image().Size())
+		log.Fatal(http.ListenAndServe(serveAddr, nil))
+	}
+
+	report := os.Stdout
+	if outputISO == "-" { // the image goes to stdout, messages don't
+		report = os.Stderr
+		_, err := builder.WriteTo(os.Stdout)
+		stopProgress()
+		if err != nil {
+			log.Fatalf("Error building ISO: %v", err)
+		}
+	} else {
+		// Ctrl-C stops the build, leaving no partial image behind
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		err := builder.BuildContext(ctx)
+		stop()
+		stopProgress()
+		if err != nil {
+			log.Fatalf("Error building ISO: %v", err)
+		}
+	}
+	if nameMap {
+		for _, m := range builder.NameMappings() {
+			line := m.Path + "\t" + m.ISO9660 + "\t" + m.Joliet
+			if m.Enhanced != "" {
+				line += "\t" + m.Enhanced
+			}
+			if m.Renamed {
+				line += "\t(renamed)"
+			}
+			fmt.Fprintln(report, line)
+		}
 	}
 
-	fmt.Println("ISO created successfully:", outputISO)
+	fmt.Fprintln(report, "ISO created successfully:", outputISO)
 }
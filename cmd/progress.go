@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/charlesthegreat77/goiso9660/iso9660"
+)
+
+const (
+	progressBarWidth    = 30
+	progressFileWidth   = 40                     // longer paths keep their end
+	progressRedrawEvery = 100 * time.Millisecond // redraws are throttled, reports may come per file
+)
+
+// progressBar draws build progress on one terminal line: phase, bar, bytes, throughput, ETA and current file.
+// : it is also the log output while the build runs, messages are printed above the bar.
+type progressBar struct {
+	out        io.Writer
+	last       iso9660.Progress
+	lastDraw   time.Time
+	writeStart time.Time // first report with bytes written, for the throughput
+	drawn      bool      // the bar is on the current line
+}
+
+// isTerminal reports whether f is a terminal (a character device), the bar would garble a file or a pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// update is the iso9660.ISOBuilder progress function.
+func (p *progressBar) update(progress iso9660.Progress) {
+	if p.writeStart.IsZero() && progress.BytesWritten > 0 {
+		p.writeStart = time.Now()
+	}
+	phaseChanged := progress.Phase != p.last.Phase
+	p.last = progress
+	if !phaseChanged && time.Since(p.lastDraw) < progressRedrawEvery {
+		return
+	}
+	p.draw()
+}
+
+// draw replaces the bar's line with the last reported progress.
+func (p *progressBar) draw() {
+	progress := p.last
+	p.lastDraw = time.Now()
+
+	var line string
+	switch {
+	case progress.TotalBytes == 0: // scan and layout, the image size isn't known yet
+		line = fmt.Sprintf("%-11s %d entries", progress.Phase, progress.EntriesScanned)
+	default:
+		fraction := float64(progress.BytesWritten) / float64(progress.TotalBytes)
+		filled := int(fraction * progressBarWidth)
+		line = fmt.Sprintf("%-11s [%s%s] %5.1f%% %s/%s", progress.Phase,
+			strings.Repeat("=", filled), strings.Repeat(" ", progressBarWidth-filled),
+			100*fraction, formatBytes(progress.BytesWritten), formatBytes(progress.TotalBytes))
+		if elapsed := time.Since(p.writeStart).Seconds(); !p.writeStart.IsZero() && elapsed >= 1 {
+			rate := float64(progress.BytesWritten) / elapsed
+			eta := time.Duration(float64(progress.TotalBytes-progress.BytesWritten) / rate * float64(time.Second))
+			line += fmt.Sprintf(" %s/s ETA %s", formatBytes(int64(rate)), eta.Round(time.Second))
+		}
+		if file := progress.CurrentFile; file != "" {
+			if len(file) > progressFileWidth {
+				file = "..." + file[len(file)-progressFileWidth+3:]
+			}
+			line += " " + file
+		}
+	}
+	fmt.Fprint(p.out, "\r\033[K"+line)
+	p.drawn = true
+}
+
+// Write implements io.Writer for the log package: the message replaces the bar, which is drawn again below it.
+func (p *progressBar) Write(msg []byte) (int, error) {
+	if p.drawn {
+		fmt.Fprint(p.out, "\r\033[K")
+		p.drawn = false
+	}
+	n, err := p.out.Write(msg)
+	if err == nil && !p.lastDraw.IsZero() {
+		p.draw()
+	}
+	return n, err
+}
+
+// finish draws the final state and moves past the bar's line.
+func (p *progressBar) finish() {
+	if p.lastDraw.IsZero() {
+		return
+	}
+	p.draw()
+	fmt.Fprintln(p.out)
+	p.drawn = false
+}
+
+// formatBytes returns n in binary units (e.g., "1.5 GiB").
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -1,10 +1,16 @@
 package iso9660
 
 import (
+	"context"
+	"crypto/sha256"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 )
 
 // ISOBuilder orchestrates the creation of an ISO 9660 / Joliet image.
@@ -16,28 +22,57 @@ type ISOBuilder struct {
 
 	totalSectors uint32 // number of sectors in the final ISO image.
 
-	// LBA locations for the Path Tables (Primary and Supplementary, L-Type and M-Type, first and second copies).
-	lbaPvdPathTableL, lbaPvdPathTableM, lbaSvdPathTableL, lbaSvdPathTableM     uint32 // doesn't look good..
-	lbaPvdPathTableL2, lbaPvdPathTableM2, lbaSvdPathTableL2, lbaSvdPathTableM2 uint32
+	buildTime time.Time // Options.Timestamp or the time of the build, UTC: every timestamp of one image agrees
 
-	// pre-gen byte data for the Path Tables.
-	pvdPathTableLData, pvdPathTableMData, svdPathTableLData, svdPathTableMData []byte
+	// directory trees recorded in the image, in volume descriptor order (ISO9660 first).
+	trees []*directoryTree
 
-	// root directory extent sizes (byte length of the root directory's listing for PVD and SVD).
-	// : stored in the Root Directory Record within the PVD/SVD.
-	pvdRootDirExtentSize, svdRootDirExtentSize uint32
+	rrMovedIndex int // index of the RR_MOVED directory in fileEntries, 0 if nothing was relocated
+
+	// Rock Ridge continuation areas (CE), packed after the ISO9660 directory extents.
+	rrContinuations          map[rrRecordKey][]susLocation
+	lbaRockRidgeContinuation uint32
+	rrContinuationSectors    uint32
+
+	// El Torito boot catalog, and the boot images it points to (when Options.BootEntries is set).
+	lbaBootCatalog uint32
+	bootImages     []bootImage
+
+	// hybrid system area (when Options.Hybrid is set): MBR boot code and the appended EFI System Partition.
+	mbrTemplate  []byte
+	lbaESP       uint32
+	espSize      uint32
+	layoutDigest [sha256.Size]byte // seeds the disk and partition GUIDs, see digestLayout
+
+	// progress reporting (see SetProgressFunc)
+	progressFunc func(Progress)
+	progress     Progress
+}
+
+// directoryTree is a directory hierarchy together with the path tables of its volume descriptor.
+type directoryTree struct {
+	kind treeKind
+
+	// LBA locations of the L-Type and M-Type path tables, first and second copies.
+	lbaPathTableL, lbaPathTableM, lbaPathTableL2, lbaPathTableM2 uint32
+
+	// pre-gen byte data for the path tables.
+	pathTableL, pathTableM []byte
 }
 
 // NewBuilder returns a new ISOBuilder instance with the given source directory, output file path, and options.
 // : if opts is nil, DefaultOptions() will be used.
+// : opts is copied, so builders sharing one Options don't see each other's boot entries (SetBootImage, AddBootEntry).
 func NewBuilder(sourceDir, outputFilename string, opts *Options) *ISOBuilder {
 	if opts == nil {
 		opts = DefaultOptions()
 	}
+	options := *opts
+	options.BootEntries = slices.Clone(opts.BootEntries)
 	return &ISOBuilder{
 		sourceDir:      sourceDir,
 		outputFilename: outputFilename,
-		options:        opts,
+		options:        &options,
 	}
 }
 
@@ -98,20 +133,89 @@ func (b *ISOBuilder) MarkFileNamesAsHidden(fileNamesToHide ...string) error {
 
 // Build constructs the ISO image and writes it to the output file.
 // : handles scanning, layout calculation, and writing of all ISO components.
-func (b *ISOBuilder) Build() (err error) {
-	// if ScanSourceDirectory wasn't called explicitly, call it now.
-	if len(b.fileEntries) == 0 || b.fileEntries[0].isoPath != "/" {
-		if err = b.ScanSourceDirectory(); err != nil {
-			return fmt.Errorf("scanning source directory: %w", err)
+func (b *ISOBuilder) Build() error {
+	return b.BuildContext(context.Background())
+}
+
+// BuildContext is Build, stopping with ctx.Err() when ctx is canceled (checked between files and inside large copies).
+// : the image is written to a temporary file next to the output file, synced and renamed into place
+// : only once complete, so the output file is never left truncated. The partial file is removed on errors.
+// : an existing output that isn't a regular file (a block device, a named pipe, ...) is written in place.
+// : a symlinked output keeps its link, the file it points to is replaced.
+func (b *ISOBuilder) BuildContext(ctx context.Context) (err error) {
+	output, err := resolveOutputPath(b.outputFilename)
+	if err != nil {
+		return err
+	}
+	if info, statErr := os.Stat(output); statErr == nil && !info.Mode().IsRegular() {
+		return b.buildInPlace(ctx)
+	}
+	// next to the output file ("." for a bare name), a rename can't cross filesystems
+	dir := filepath.Dir(output)
+	tmpFile, err := os.CreateTemp(dir, "."+filepath.Base(output)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("creating temporary output file in '%s': %w", dir, err)
+	}
+	defer func() {
+		if err != nil {
+			tmpFile.Close() // may already be closed
+			os.Remove(tmpFile.Name())
 		}
+	}()
+	if _, err = b.writeTo(ctx, tmpFile); err != nil {
+		return err
 	}
-	if err = b.calculateLayout(); err != nil {
-		return fmt.Errorf("calculating ISO layout: %w", err)
+	// CreateTemp makes the file private, give it the mode of the file it replaces (or 0644)
+	mode := os.FileMode(0o644)
+	if info, statErr := os.Stat(output); statErr == nil {
+		mode = info.Mode().Perm()
+	}
+	if err = tmpFile.Chmod(mode); err != nil {
+		return fmt.Errorf("setting output file mode: %w", err)
 	}
+	if err = tmpFile.Sync(); err != nil {
+		return fmt.Errorf("syncing output file: %w", err)
+	}
+	if err = tmpFile.Close(); err != nil {
+		return fmt.Errorf("closing output file: %w", err)
+	}
+	if err = os.Rename(tmpFile.Name(), output); err != nil {
+		return fmt.Errorf("renaming output file to '%s': %w", output, err)
+	}
+	syncDirectory(dir) // persist the rename as well
+	return nil
+}
+
+// resolveOutputPath follows the symlinks of an output path to the file a build replaces, which may not exist yet
+// (a dangling link is built through, as opening it for writing would).
+// : a relative target is joined to the link's directory without cleaning it, the kernel resolves its ".." elements.
+func resolveOutputPath(output string) (string, error) {
+	name := output
+	for range maxOutputSymlinks {
+		info, err := os.Lstat(name)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			return name, nil // created, or reported, when the temporary file is
+		}
+		target, err := os.Readlink(name)
+		if err != nil {
+			return "", fmt.Errorf("reading output symlink '%s': %w", name, err)
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Dir(name) + string(filepath.Separator) + target
+		}
+		name = target
+	}
+	return "", fmt.Errorf("output '%s': too many levels of symbolic links", output)
+}
+
+// maxOutputSymlinks is the number of symlinks resolveOutputPath follows, as many as Linux does before ELOOP.
+const maxOutputSymlinks = 40
 
-	isoFile, err := os.Create(b.outputFilename)
+// buildInPlace writes the image directly to an existing output that can't be replaced by a rename.
+func (b *ISOBuilder) buildInPlace(ctx context.Context) (err error) {
+	isoFile, err := os.OpenFile(b.outputFilename, os.O_WRONLY, 0)
 	if err != nil {
-		return fmt.Errorf("creating output file '%s': %w", b.outputFilename, err)
+		return fmt.Errorf("opening output file '%s': %w", b.outputFilename, err)
 	}
 	defer func() {
 		closeErr := isoFile.Close()
@@ -119,24 +223,110 @@ func (b *ISOBuilder) Build() (err error) {
 			err = fmt.Errorf("closing output file: %w", closeErr)
 		}
 	}()
+	_, err = b.writeTo(ctx, isoFile)
+	return err
+}
+
+// syncDirectory flushes a directory entry change (best effort, not every platform can sync a directory).
+func syncDirectory(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// BuildTo constructs the ISO image and writes it to w from offset 0 (a preallocated file, a block device, ...).
+// : the image is written sequentially, sectors between the parts of the layout are written as zeros.
+func (b *ISOBuilder) BuildTo(w io.WriterAt) error {
+	_, err := b.WriteTo(io.NewOffsetWriter(w, 0))
+	return err
+}
+
+// WriteTo constructs the ISO image and writes it to w strictly sequentially, without seeking,
+// so it can go to stdout, a gzip.Writer, an HTTP response or a socket.
+// : the output file name given to NewBuilder is not used. Returns the number of bytes written.
+func (b *ISOBuilder) WriteTo(w io.Writer) (n int64, err error) {
+	return b.writeTo(context.Background(), w)
+}
+
+// writeTo lays out the image and writes it to w, until ctx is canceled.
+func (b *ISOBuilder) writeTo(ctx context.Context, w io.Writer) (n int64, err error) {
+	if err = b.prepareLayout(); err != nil {
+		return 0, err
+	}
+	iw := &imageWriter{w: w, ctx: ctx}
+	err = b.writeImage(iw)
+	return iw.offset, err
+}
+
+// prepareLayout scans the source directory (unless ScanSourceDirectory was called) and lays out the image.
+func (b *ISOBuilder) prepareLayout() error {
+	// if ScanSourceDirectory wasn't called explicitly, call it now.
+	if len(b.fileEntries) == 0 || b.fileEntries[0].isoPath != "/" {
+		if err := b.ScanSourceDirectory(); err != nil {
+			return fmt.Errorf("scanning source directory: %w", err)
+		}
+	}
+	b.reportPhase(PhaseLayout, 0)
+	b.buildTime = b.options.Timestamp.UTC()
+	if b.options.Timestamp.IsZero() {
+		b.buildTime = time.Now().UTC()
+	}
+	if err := b.calculateLayout(); err != nil {
+		return fmt.Errorf("calculating ISO layout: %w", err)
+	}
+	if b.isHybrid() {
+		b.layoutDigest = b.digestLayout()
+	}
+	b.progress.TotalBytes = int64(b.totalSectors) * SectorSize
+	return nil
+}
 
-	if err = b.writeSystemArea(isoFile); err != nil {
+// writeImage writes all the parts of the laid out image, in LBA order (see assignContentLBAs).
+func (b *ISOBuilder) writeImage(iw *imageWriter) (err error) {
+	iw.onProgress = b.reportBytesWritten
+	b.reportPhase(PhaseDescriptors, iw.offset)
+	if err = b.writeSystemArea(iw); err != nil {
 		return fmt.Errorf("writing system area: %w", err)
 	}
-	if err = b.writeVolumeDescriptors(isoFile); err != nil {
+	if err = b.writeVolumeDescriptors(iw); err != nil {
 		return fmt.Errorf("writing volume descriptors: %w", err)
 	}
-	if err = b.writeAllPathTables(isoFile); err != nil {
+	b.reportPhase(PhasePathTables, iw.offset)
+	if err = b.writeAllPathTables(iw); err != nil {
 		return fmt.Errorf("writing path tables: %w", err)
 	}
-	if err = b.writeAllDirectoryContents(isoFile); err != nil {
+	b.reportPhase(PhaseDirectories, iw.offset)
+	if err = b.writeDirectoryContents(iw, true); err != nil {
 		return fmt.Errorf("writing directory contents: %w", err)
 	}
-	if err = b.writeAllFileData(isoFile); err != nil {
+	if err = b.writeRockRidgeContinuationAreas(iw); err != nil {
+		return fmt.Errorf("writing Rock Ridge continuation areas: %w", err)
+	}
+	b.reportPhase(PhaseFileData, iw.offset)
+	if err = b.writeBootCatalog(iw); err != nil {
+		return fmt.Errorf("writing El Torito boot catalog: %w", err)
+	}
+	if err = b.writeAllFileData(iw); err != nil {
 		return fmt.Errorf("writing file data: %w", err)
 	}
-	if err = b.finalizeImageSize(isoFile); err != nil {
-		return fmt.Errorf("finalizing image size: %w", err)
+	b.reportFile("", iw.offset) // the listings of the other trees follow the file data, within its phase
+	if err = b.writeDirectoryContents(iw, false); err != nil {
+		return fmt.Errorf("writing directory contents: %w", err)
+	}
+	b.reportPhase(PhaseFinalize, iw.offset)
+	if err = b.writeEFISystemPartition(iw); err != nil {
+		return fmt.Errorf("writing EFI System Partition: %w", err)
 	}
-	return err
+	if err = b.writeTrailingPadding(iw); err != nil {
+		return fmt.Errorf("writing trailing padding: %w", err)
+	}
+	if err = b.writeGPTBackup(iw); err != nil {
+		return fmt.Errorf("writing backup GPT: %w", err)
+	}
+	if iw.offset != int64(b.totalSectors)*SectorSize {
+		log.Panicf("InternalError: wrote %d bytes, the layout has %d sectors", iw.offset, b.totalSectors)
+	}
+	b.reportBytesWritten(iw.offset)
+	return nil
 }
@@ -0,0 +1,216 @@
+package iso9660
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard) // renaming and truncation warnings
+	os.Exit(m.Run())
+}
+
+// writeSourceTree creates the files of tree (slash separated path -> content) below a new temporary directory.
+// : a path ending in "/" creates an empty directory. Entries are modified at sourceTreeTime.
+func writeSourceTree(t *testing.T, tree map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range tree {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if name[len(name)-1] == '/' {
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Rock Ridge records access times: set them ahead of the change time Chtimes sets, so reading the
+	// tree doesn't update them (relatime) and images of the tree stay byte-identical
+	accessTime := time.Now().Add(time.Hour)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Chtimes(path, accessTime, sourceTreeTime)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+// sourceTreeTime is the modification time of the files and directories written by writeSourceTree.
+var sourceTreeTime = time.Date(2024, 4, 1, 8, 0, 0, 0, time.UTC)
+
+// buildImage builds the image of sourceDir with WriteTo and returns its bytes.
+func buildImage(t *testing.T, sourceDir string, opts *Options) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	n, err := NewBuilder(sourceDir, "", opts).WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("building image of '%s': %v", sourceDir, err)
+	}
+	if n != int64(buf.Len()) || n%SectorSize != 0 {
+		t.Fatalf("WriteTo returned %d, wrote %d bytes", n, buf.Len())
+	}
+	return buf.Bytes()
+}
+
+// openImage parses an image built by buildImage.
+func openImage(t *testing.T, image []byte) *Image {
+	t.Helper()
+	img, err := Open(bytes.NewReader(image))
+	if err != nil {
+		t.Fatalf("opening image: %v", err)
+	}
+	return img
+}
+
+// TestBuildContext checks the output is renamed into place from a temporary file of its directory,
+// and that a canceled build leaves neither the output nor the temporary file behind.
+func TestBuildContext(t *testing.T) {
+	source := writeSourceTree(t, map[string]string{"a.txt": "a", "dir/b.txt": "b"})
+	outDir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(outDir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	b := NewBuilder(source, "out.iso", nil)
+	var tmpNames []string // output directory while the data is written
+	b.SetProgressFunc(func(p Progress) {
+		if p.Phase == PhaseFinalize && tmpNames == nil {
+			tmpNames = dirNames(t, outDir)
+		}
+	})
+	if err := b.BuildContext(context.Background()); err != nil {
+		t.Fatalf("BuildContext: %v", err)
+	}
+	if len(tmpNames) != 1 || !strings.HasPrefix(tmpNames[0], ".out.iso.") {
+		t.Errorf("output directory held %v during the build, want the temporary file", tmpNames)
+	}
+	if names := dirNames(t, outDir); len(names) != 1 || names[0] != "out.iso" {
+		t.Errorf("output directory holds %v, want [out.iso]", names)
+	}
+	openImage(t, readFile(t, filepath.Join(outDir, "out.iso")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = NewBuilder(source, "canceled.iso", nil).BuildContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled BuildContext returned %v, want context.Canceled", err)
+	}
+	if names := dirNames(t, outDir); len(names) != 1 {
+		t.Errorf("canceled build left %v behind", names)
+	}
+}
+
+// TestBuildThroughSymlink checks a symlinked output (a chain of links, a dangling link) keeps its links
+// and the file they lead to is replaced, with its mode.
+func TestBuildThroughSymlink(t *testing.T) {
+	source := writeSourceTree(t, map[string]string{"a.txt": "a"})
+	outDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(outDir, "images"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(outDir, "images", "real.iso")
+	if err := os.WriteFile(target, []byte("old image"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{ // link -> its content
+		"out.iso":      "images/real.iso",
+		"latest.iso":   "out.iso",
+		"dangling.iso": "images/../images/new.iso",
+	}
+	for link, content := range links {
+		if err := os.Symlink(content, filepath.Join(outDir, link)); err != nil {
+			t.Skipf("creating symlinks: %v", err)
+		}
+	}
+
+	for _, tt := range []struct{ output, want string }{
+		{"latest.iso", "images/real.iso"},
+		{"dangling.iso", "images/new.iso"},
+	} {
+		if err := NewBuilder(source, filepath.Join(outDir, tt.output), nil).Build(); err != nil {
+			t.Fatalf("building through '%s': %v", tt.output, err)
+		}
+		openImage(t, readFile(t, filepath.Join(outDir, tt.want)))
+	}
+	for link, content := range links {
+		if got, err := os.Readlink(filepath.Join(outDir, link)); err != nil || got != content {
+			t.Errorf("link '%s' reads %q (%v) after the builds, want %q", link, got, err, content)
+		}
+	}
+	if info, err := os.Stat(target); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("target of the links: %v, %v, want a file of mode 0600", info, err)
+	}
+	if names := dirNames(t, filepath.Join(outDir, "images")); !slices.Equal(names, []string{"new.iso", "real.iso"}) {
+		t.Errorf("images directory holds %v, want [new.iso real.iso]", names)
+	}
+
+	if err := os.Symlink("loop.iso", filepath.Join(outDir, "loop.iso")); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewBuilder(source, filepath.Join(outDir, "loop.iso"), nil).Build(); err == nil || !strings.Contains(err.Error(), "symbolic links") {
+		t.Errorf("building through a symlink loop: %v, want an error", err)
+	}
+}
+
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func readFile(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// TestReproducibleBuild checks two builds of a tree with Options.Timestamp set are byte-identical.
+func TestReproducibleBuild(t *testing.T) {
+	source := writeSourceTree(t, map[string]string{"a.txt": "a", "dir/b.txt": "b", "a/b/c/d/e/f/g/h/i/deep.txt": "deep"})
+	opts := rockRidgeOptions()
+	opts.EnhancedVolumeDescriptor = true
+	opts.Timestamp = time.Date(2024, 5, 1, 12, 30, 45, 0, time.UTC)
+	first := buildImage(t, source, opts)
+	time.Sleep(20 * time.Millisecond) // volume timestamps have hundredths of a second
+	if second := buildImage(t, source, opts); !bytes.Equal(first, second) {
+		t.Error("two builds of the same tree differ")
+	}
+	if got := openImage(t, first).Primary().CreationTime; !got.Equal(opts.Timestamp) {
+		t.Errorf("volume creation time %v, want %v", got, opts.Timestamp)
+	}
+}
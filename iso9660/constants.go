@@ -3,12 +3,15 @@ package iso9660
 const (
 	SectorSize             = 2048
 	JolietMaxFilenameChars = 64
+	EnhancedMaxNameBytes   = 207
 	SystemAreaNumSectors   = 16 // # of blank sectors at the beginning of the ISO
 
 	// vdTypePrimary identifies a Primary Volume Descriptor
 	vdTypePrimary byte = 1
-	// vdTypeSupplementary identifies a Supplementary Volume Descriptor (used for Joliet)
+	// vdTypeSupplementary identifies a Supplementary Volume Descriptor (used for Joliet and the ISO 9660:1999 Enhanced Volume Descriptor)
 	vdTypeSupplementary byte = 2
+	// vdTypeBootRecord identifies a Boot Record (used for El Torito)
+	vdTypeBootRecord byte = 0
 	// vdTypeTerminator identifies a Volume Descriptor Set Terminator
 	vdTypeTerminator byte = 255
 
@@ -19,4 +22,74 @@ const (
 	// (LenDI (1), ExtAttrLen (1), LocExtent (4), ParentDirNum (2))
 	// (ECMA-119 Section 9.4)
 	ptRecFixedPartSize = 8
+	// maxPathTableDirNum is the largest directory number a Path Table Record can refer to as parent (16 bits)
+	maxPathTableDirNum = 0xFFFF
+	// maxISO9660DirLevel is the deepest directory level of the ISO9660 tree (root is 0),
+	// ECMA-119 6.8.2.1 allows 8 levels. Deeper directories are relocated to rrMovedName.
+	maxISO9660DirLevel = 7
+	rrMovedName        = "rr_moved"
+	// drMaxSize is the largest possible Directory Record, its length is a single byte
+	drMaxSize = 255
+	// maxExtentSize is the largest sector-aligned Data Length, the extent size of all but the last
+	// Directory Record of a multi-extent file
+	maxExtentSize = 0xFFFFF800
+)
+
+// System Use Sharing Protocol / Rock Ridge Interchange Protocol (SUSP 1.10, RRIP 1.10)
+const (
+	susHeaderSize = 4   // signature (2), length (1), version (1)
+	susSPSize     = 7   // SP: SUSP indicator, only in the root's "." record
+	susCESize     = 28  // CE: continuation area block, offset and length (both-endian)
+	susMaxEntry   = 255 // an entry's length is a single byte
+	rrPXSize      = 36  // PX: mode, links, uid, gid (both-endian)
+	rrNMMaxName   = susMaxEntry - susHeaderSize - 1
+
+	rrExtensionID         = "RRIP_1991A"
+	rrExtensionDescriptor = "THE ROCK RIDGE INTERCHANGE PROTOCOL PROVIDES SUPPORT FOR POSIX FILE SYSTEM SEMANTICS"
+	rrExtensionSource     = "PLEASE CONTACT DISC PUBLISHER FOR SPECIFICATION SOURCE.  SEE PUBLISHER IDENTIFIER IN PRIMARY VOLUME DESCRIPTOR FOR CONTACT INFORMATION."
+)
+
+// El Torito Bootable CD-ROM Format Specification 1.0
+const (
+	elToritoSystemID           = "EL TORITO SPECIFICATION"
+	bootCatalogEntrySize       = 32     // validation, default, section header and section entries
+	elToritoDefaultLoadSegment = 0x07C0 // traditional BIOS load segment (0x7C00)
+
+	bootInfoTableOffset = 8    // boot info table (isolinux, GRUB legacy) starts after the image's first 8 bytes
+	bootInfoTableSize   = 56   // PVD LBA, image LBA, image length, checksum, 40 reserved bytes
+	grub2BootInfoOffset = 2548 // GRUB2 core image slot for its own 512-byte block address
+)
+
+// Hybrid system area: MBR (512-byte blocks) and GPT (UEFI 2.x Section 5)
+const (
+	mbrSectorSize           = 512
+	mbrBootCodeSize         = 432 // isohdpfx-style boot code, followed by the boot image address and disk signature
+	mbrPartitionTableOffset = 446 // 4 partition entries of 16 bytes, then the 0x55AA signature
+	gptHeaderSize           = 92
+	gptEntrySize            = 128
+	gptEntryCount           = 128
+	gptEntryArraySectors    = gptEntrySize * gptEntryCount / mbrSectorSize // 32 blocks
+)
+
+// FAT12/16 images (Microsoft FAT32 File System Specification 1.03, which also covers FAT12/16)
+const (
+	fatSectorSize    = 512
+	fatDirEntrySize  = 32
+	fat12MaxClusters = 4085  // volumes with fewer clusters are FAT12
+	fat16MaxClusters = 65525 // volumes with fewer clusters (and at least fat12MaxClusters) are FAT16
+
+	fatAttrVolumeID  = 0x08
+	fatAttrDirectory = 0x10
+	fatAttrArchive   = 0x20
+	fatAttrLongName  = 0x0F // read-only | hidden | system | volume ID
+)
+
+// Directory Record File Flags bits (ECMA-119 Section 9.1.6)
+const (
+	FileFlagHidden      byte = 0x01 // existence bit, entry is hidden from the user
+	FileFlagDirectory   byte = 0x02 // entry is a directory
+	FileFlagAssociated  byte = 0x04 // entry is an associated file
+	FileFlagRecord      byte = 0x08 // record format is specified in the extended attribute record
+	FileFlagProtection  byte = 0x10 // owner/group permissions are specified in the extended attribute record
+	FileFlagMultiExtent byte = 0x80 // this is not the final directory record for the file
 )
@@ -16,8 +16,36 @@ func (h *volumeDescriptorHeader) marshalBinary() []byte {
 	return buf
 }
 
+// createVolumeDescriptor generates the volume descriptor sector of a directory tree.
+func (b *ISOBuilder) createVolumeDescriptor(tree *directoryTree) []byte {
+	switch tree.kind {
+	case treeISO9660:
+		return b.createPrimaryVolumeDescriptor(tree)
+	case treeJoliet:
+		return b.createJolietVolumeDescriptor(tree)
+	case treeEnhanced:
+		return b.createEnhancedVolumeDescriptor(tree)
+	}
+	log.Panicf("InternalError: no volume descriptor for %s tree", tree.kind)
+	return nil
+}
+
+// createRootDirectoryRecord generates the 34-byte root Directory Record of a volume descriptor for the given tree.
+func (b *ISOBuilder) createRootDirectoryRecord(kind treeKind) []byte {
+	rootEntry := b.fileEntries[0] // root is always the first entry
+	root := rootEntry.nodes[kind]
+	rootDRBytes, err := b.createDirectoryRecordBytes(root.sector, root.size, root.name, &rootEntry, kind, nil)
+	if err != nil {
+		log.Panicf("%s VD: Failed to create root directory record: %v", kind, err)
+	}
+	if len(rootDRBytes) != 34 { // PVD/SVD Root DR is always 34 bytes.
+		log.Panicf("%s VD: Marshalled Root DR length is %d, expected 34. Identifier was: '%x'", kind, len(rootDRBytes), getDRIdentifierBytes(root.name, kind, true))
+	}
+	return rootDRBytes
+}
+
 // createPrimaryVolumeDescriptor generates the PVD sector.
-func (b *ISOBuilder) createPrimaryVolumeDescriptor() []byte {
+func (b *ISOBuilder) createPrimaryVolumeDescriptor(tree *directoryTree) []byte {
 	header := volumeDescriptorHeader{Type: vdTypePrimary, StandardIdentifier: [5]byte{'C', 'D', '0', '0', '1'}, Version: 1}
 	headerBytes := header.marshalBinary()
 
@@ -28,23 +56,14 @@ func (b *ISOBuilder) createPrimaryVolumeDescriptor() []byte {
 	pvdFields.VolumeSetSize = 1
 	pvdFields.VolumeSequenceNumber = 1
 	pvdFields.LogicalBlockSize = SectorSize
-	pvdFields.PathTableSizeBytes = uint32(len(b.pvdPathTableLData)) // size of Type L Path Table
-	pvdFields.LPathTableLocation = b.lbaPvdPathTableL
-	pvdFields.OptionalLPathTableLocation = b.lbaPvdPathTableL2
-	pvdFields.MPathTableLocation = b.lbaPvdPathTableM
-	pvdFields.OptionalMPathTableLocation = b.lbaPvdPathTableM2
+	pvdFields.PathTableSizeBytes = uint32(len(tree.pathTableL)) // size of Type L Path Table
+	pvdFields.LPathTableLocation = tree.lbaPathTableL
+	pvdFields.OptionalLPathTableLocation = tree.lbaPathTableL2
+	pvdFields.MPathTableLocation = tree.lbaPathTableM
+	pvdFields.OptionalMPathTableLocation = tree.lbaPathTableM2
 
-	rootEntry := b.fileEntries[0] // root is always the first entry
 	// Root DR in PVD describes the root directory using ISO9660 naming.
-	// Its extent size is b.pvdRootDirExtentSize.
-	rootDRBytes, err := b.createDirectoryRecordBytes(rootEntry.iso9660Sector, b.pvdRootDirExtentSize, rootEntry.iso9660Name, &rootEntry, false)
-	if err != nil {
-		log.Panicf("PVD: Failed to create root directory record: %v", err)
-	}
-	if len(rootDRBytes) != 34 { // PVD/SVD Root DR is always 34 bytes.
-		log.Panicf("PVD: Marshalled Root DR length is %d, expected 34. Identifier was: '%x'", len(rootDRBytes), getDRIdentifierBytes(rootEntry.iso9660Name, false, true))
-	}
-	copy(pvdFields.RootDirectoryRecord[:], rootDRBytes)
+	copy(pvdFields.RootDirectoryRecord[:], b.createRootDirectoryRecord(treeISO9660))
 
 	copy(pvdFields.VolumeSetIdentifier[:], padString("", 128)) // normally blank
 	copy(pvdFields.PublisherIdentifier[:], padString(b.options.PublisherIdentifierISO, 128))
@@ -54,7 +73,7 @@ func (b *ISOBuilder) createPrimaryVolumeDescriptor() []byte {
 	copy(pvdFields.AbstractFileIdentifier[:], padString("", 37))
 	copy(pvdFields.BibliographicFileIdentifier[:], padString("", 37))
 
-	now := time.Now().UTC()
+	now := b.buildTime
 	copy(pvdFields.VolumeCreationTimestamp[:], formatTimestamp(now))
 	copy(pvdFields.VolumeModificationTimestamp[:], formatTimestamp(now))
 	copy(pvdFields.VolumeExpirationTimestamp[:], formatTimestamp(time.Time{})) // zero time for "not specified"
@@ -109,9 +128,8 @@ func (b *ISOBuilder) createPrimaryVolumeDescriptor() []byte {
 }
 
 // createJolietVolumeDescriptor generates the SVD sector for Joliet.
-func (b *ISOBuilder) createJolietVolumeDescriptor() []byte {
+func (b *ISOBuilder) createJolietVolumeDescriptor(tree *directoryTree) []byte {
 	header := volumeDescriptorHeader{Type: vdTypeSupplementary, StandardIdentifier: [5]byte{'C', 'D', '0', '0', '1'}, Version: 1}
-	headerBytes := header.marshalBinary()
 
 	var svdFields supplementaryVolumeDescriptorFields
 	copy(svdFields.SystemIdentifier[:], padString(b.options.SystemIdentifier, 32))
@@ -124,22 +142,14 @@ func (b *ISOBuilder) createJolietVolumeDescriptor() []byte {
 	svdFields.VolumeSetSize = 1
 	svdFields.VolumeSequenceNumber = 1
 	svdFields.LogicalBlockSize = SectorSize
-	svdFields.PathTableSizeBytes = uint32(len(b.svdPathTableLData)) // L-Type for Joliet
-	svdFields.LPathTableLocation = b.lbaSvdPathTableL
-	svdFields.OptionalLPathTableLocation = b.lbaSvdPathTableL2
-	svdFields.MPathTableLocation = b.lbaSvdPathTableM
-	svdFields.OptionalMPathTableLocation = b.lbaSvdPathTableM2
+	svdFields.PathTableSizeBytes = uint32(len(tree.pathTableL)) // L-Type for Joliet
+	svdFields.LPathTableLocation = tree.lbaPathTableL
+	svdFields.OptionalLPathTableLocation = tree.lbaPathTableL2
+	svdFields.MPathTableLocation = tree.lbaPathTableM
+	svdFields.OptionalMPathTableLocation = tree.lbaPathTableM2
 
-	rootEntry := b.fileEntries[0]
 	// Root DR in SVD describes the root directory using Joliet naming.
-	rootDRJolietBytes, err := b.createDirectoryRecordBytes(rootEntry.jolietSector, b.svdRootDirExtentSize, rootEntry.jolietName, &rootEntry, true)
-	if err != nil {
-		log.Panicf("SVD: Failed root DR: %v", err)
-	}
-	if len(rootDRJolietBytes) != 34 {
-		log.Panicf("SVD: Root DR len %d != 34. ID: '%x'", len(rootDRJolietBytes), getDRIdentifierBytes(rootEntry.jolietName, true, true))
-	}
-	copy(svdFields.RootDirectoryRecord[:], rootDRJolietBytes)
+	copy(svdFields.RootDirectoryRecord[:], b.createRootDirectoryRecord(treeJoliet))
 
 	copy(svdFields.VolumeSetIdentifier[:], padUTF16StringBE("", 64))
 	copy(svdFields.PublisherIdentifier[:], padUTF16StringBE(b.options.PublisherIdentifierJoliet, 64))
@@ -150,18 +160,60 @@ func (b *ISOBuilder) createJolietVolumeDescriptor() []byte {
 	copy(svdFields.AbstractFileIdentifier[:], padUTF16StringBEToFixedBytes("", 18, 37))
 	copy(svdFields.BibliographicFileIdentifier[:], padUTF16StringBEToFixedBytes("", 18, 37))
 
-	now := time.Now().UTC()
+	now := b.buildTime
 	copy(svdFields.VolumeCreationTimestamp[:], formatTimestamp(now))
 	copy(svdFields.VolumeModificationTimestamp[:], formatTimestamp(now))
 	copy(svdFields.VolumeExpirationTimestamp[:], formatTimestamp(time.Time{}))
 	copy(svdFields.VolumeEffectiveTimestamp[:], formatTimestamp(now))
 	svdFields.FileStructureVersion = 1
+	return marshalSupplementaryVolumeDescriptor(&header, &svdFields)
+}
 
+// createEnhancedVolumeDescriptor generates the ISO 9660:1999 Enhanced Volume Descriptor sector:
+// an SVD with version 2 and file structure version 2, no escape sequences and a-character identifiers.
+// -> ISO/IEC 9660:1999 Section 8.5
+func (b *ISOBuilder) createEnhancedVolumeDescriptor(tree *directoryTree) []byte {
+	header := volumeDescriptorHeader{Type: vdTypeSupplementary, StandardIdentifier: [5]byte{'C', 'D', '0', '0', '1'}, Version: 2}
+
+	var evdFields supplementaryVolumeDescriptorFields
+	copy(evdFields.SystemIdentifier[:], padString(b.options.SystemIdentifier, 32))
+	copy(evdFields.VolumeIdentifier[:], padString(b.options.VolumeIdentifierISO, 32))
+	evdFields.VolumeSpaceSize = b.totalSectors
+	evdFields.VolumeSetSize = 1
+	evdFields.VolumeSequenceNumber = 1
+	evdFields.LogicalBlockSize = SectorSize
+	evdFields.PathTableSizeBytes = uint32(len(tree.pathTableL))
+	evdFields.LPathTableLocation = tree.lbaPathTableL
+	evdFields.OptionalLPathTableLocation = tree.lbaPathTableL2
+	evdFields.MPathTableLocation = tree.lbaPathTableM
+	evdFields.OptionalMPathTableLocation = tree.lbaPathTableM2
+	copy(evdFields.RootDirectoryRecord[:], b.createRootDirectoryRecord(treeEnhanced))
+
+	copy(evdFields.VolumeSetIdentifier[:], padString("", 128))
+	copy(evdFields.PublisherIdentifier[:], padString(b.options.PublisherIdentifierISO, 128))
+	copy(evdFields.DataPreparerIdentifier[:], padString(b.options.DataPreparerIdentifierISO, 128))
+	copy(evdFields.ApplicationIdentifier[:], padString(b.options.ApplicationIdentifierISO, 128))
+	copy(evdFields.CopyrightFileIdentifier[:], padString("", 37))
+	copy(evdFields.AbstractFileIdentifier[:], padString("", 37))
+	copy(evdFields.BibliographicFileIdentifier[:], padString("", 37))
+
+	now := b.buildTime
+	copy(evdFields.VolumeCreationTimestamp[:], formatTimestamp(now))
+	copy(evdFields.VolumeModificationTimestamp[:], formatTimestamp(now))
+	copy(evdFields.VolumeExpirationTimestamp[:], formatTimestamp(time.Time{}))
+	copy(evdFields.VolumeEffectiveTimestamp[:], formatTimestamp(now))
+	evdFields.FileStructureVersion = 2 // ISO 9660:1999
+	return marshalSupplementaryVolumeDescriptor(&header, &evdFields)
+}
+
+// marshalSupplementaryVolumeDescriptor converts an SVD header and fields into a sector.
+func marshalSupplementaryVolumeDescriptor(header *volumeDescriptorHeader, svdFields *supplementaryVolumeDescriptorFields) []byte {
+	headerBytes := header.marshalBinary()
 	svdSectorBytes := make([]byte, SectorSize)
 	copy(svdSectorBytes[0:7], headerBytes) // 7-byte common header
 
 	fieldBuf := new(bytes.Buffer)
-	fieldBuf.WriteByte(0) // byte 7: Volume Flags (0 for basic Joliet)
+	fieldBuf.WriteByte(0) // byte 7: Volume Flags (0: escape sequences are registered per ISO 2375)
 	fieldBuf.Write(svdFields.SystemIdentifier[:])
 	fieldBuf.Write(svdFields.VolumeIdentifier[:])
 	fieldBuf.Write(make([]byte, 8)) // bytes 72-79: unused
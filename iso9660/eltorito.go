@@ -0,0 +1,363 @@
+package iso9660
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+)
+
+// BootPlatform is the El Torito platform ID of a boot entry.
+type BootPlatform byte
+
+const (
+	BootPlatformX86     BootPlatform = 0x00 // 80x86 BIOS
+	BootPlatformPowerPC BootPlatform = 0x01
+	BootPlatformMac     BootPlatform = 0x02
+	BootPlatformEFI     BootPlatform = 0xEF // UEFI, the image is usually a FAT filesystem holding EFI/BOOT/BOOT*.EFI
+)
+
+// BootEmulation is the boot media type the BIOS emulates for a boot entry.
+type BootEmulation byte
+
+const (
+	NoEmulation        BootEmulation = 0 // image is loaded and run as is
+	Floppy12Emulation  BootEmulation = 1 // image is a 1.2 MB floppy disk
+	Floppy144Emulation BootEmulation = 2 // image is a 1.44 MB floppy disk
+	Floppy288Emulation BootEmulation = 3 // image is a 2.88 MB floppy disk
+	HardDiskEmulation  BootEmulation = 4 // image is a hard disk with an MBR holding a single partition
+)
+
+// floppyImageSizes maps floppy emulation modes to the exact image size they require.
+var floppyImageSizes = map[BootEmulation]int64{
+	Floppy12Emulation:  1200 * 1024,
+	Floppy144Emulation: 1440 * 1024,
+	Floppy288Emulation: 2880 * 1024,
+}
+
+// BootEntry describes an El Torito boot image.
+// : exactly one of Path, ImageFile and FAT is set.
+type BootEntry struct {
+	Platform    BootPlatform  // platform the entry boots on (BootPlatformX86 by default)
+	Emulation   BootEmulation // media type (NoEmulation by default)
+	Path        string        // path of the boot image relative to the source directory (e.g., "isolinux/isolinux.bin")
+	ImageFile   string        // path of a boot image on disk, outside the source tree, not listed in the directory trees
+	FAT         *FATImage     // FAT image generated from files (UEFI), not listed in the directory trees
+	LoadSegment uint16        // real-mode segment the BIOS loads the image to, 0 for the default (0x07C0)
+	SectorCount uint16        // no emulation: number of 512-byte virtual sectors to load, 0 for the whole image
+
+	// patched into the image data as it is written, the source file is left untouched.
+	BootInfoTable bool // 56-byte boot info table at offset 8 (isolinux, GRUB eltorito.img), like mkisofs -boot-info-table
+	GRUB2BootInfo bool // image's 512-byte block address + 5 at offset 2548, like xorriso -grub2-boot-info
+}
+
+// bootImage is a resolved boot entry, with the image's place in the layout.
+type bootImage struct {
+	entry      BootEntry
+	fileIndex  int    // index in fileEntries for images of the source tree, -1 for external images
+	diskPath   string // where the image data is read from
+	data       []byte // generated images (FAT), held in memory instead of diskPath
+	size       uint32 // image size in bytes
+	sector     uint32 // LBA of the image data
+	systemType byte   // hard disk emulation: partition type of the image's partition
+}
+
+// SetBootImage makes the image bootable on x86 BIOS through El Torito (no emulation).
+// : path is relative to the source directory and must name a regular file, it is checked by Build.
+// : loadSegment and sectorCount may be 0 for their defaults (see BootEntry).
+// : the entry becomes the catalog's default entry, replacing any previous default.
+func (b *ISOBuilder) SetBootImage(path string, loadSegment, sectorCount uint16) {
+	entry := BootEntry{Platform: BootPlatformX86, Path: path, LoadSegment: loadSegment, SectorCount: sectorCount}
+	if len(b.options.BootEntries) > 0 {
+		b.options.BootEntries[0] = entry
+		return
+	}
+	b.options.BootEntries = []BootEntry{entry}
+}
+
+// AddBootEntry appends an El Torito boot entry.
+// : the first entry is the catalog's default entry, the others are grouped into sections by platform.
+func (b *ISOBuilder) AddBootEntry(entry BootEntry) {
+	b.options.BootEntries = append(b.options.BootEntries, entry)
+}
+
+// isBootable reports whether an El Torito boot record and catalog are written.
+func (b *ISOBuilder) isBootable() bool {
+	return len(b.options.BootEntries) > 0
+}
+
+// resolveBootImages looks up every boot entry's image and checks it against the entry's emulation mode.
+func (b *ISOBuilder) resolveBootImages() error {
+	b.bootImages = nil
+	for n, entry := range b.options.BootEntries {
+		img := bootImage{entry: entry, fileIndex: -1}
+		sources := 0
+		for _, set := range []bool{entry.Path != "", entry.ImageFile != "", entry.FAT != nil} {
+			if set {
+				sources++
+			}
+		}
+		switch {
+		case sources > 1:
+			return fmt.Errorf("boot entry %d: only one of Path, ImageFile and FAT may be set", n)
+		case entry.Path != "":
+			index, err := b.findBootFile(entry.Path)
+			if err != nil {
+				return fmt.Errorf("boot entry %d: %w", n, err)
+			}
+			img.fileIndex = index
+			img.diskPath = b.fileEntries[index].diskPath
+			if b.fileEntries[index].size > math.MaxUint32 {
+				return fmt.Errorf("boot entry %d: boot image '%s' is 4 GiB or more", n, entry.Path)
+			}
+			img.size = uint32(b.fileEntries[index].size)
+		case entry.ImageFile != "":
+			info, err := os.Stat(entry.ImageFile)
+			if err != nil {
+				return fmt.Errorf("boot entry %d: %w", n, err)
+			}
+			if !info.Mode().IsRegular() {
+				return fmt.Errorf("boot entry %d: boot image '%s' is not a regular file", n, entry.ImageFile)
+			}
+			if info.Size() > math.MaxUint32 {
+				return fmt.Errorf("boot entry %d: boot image '%s' is 4 GiB or more", n, entry.ImageFile)
+			}
+			img.diskPath = entry.ImageFile
+			img.size = uint32(info.Size())
+		case entry.FAT != nil:
+			if entry.Emulation != NoEmulation {
+				return fmt.Errorf("boot entry %d: generated FAT images boot without emulation", n)
+			}
+			data, err := b.createEFIImage(entry.FAT)
+			if err != nil {
+				return fmt.Errorf("boot entry %d: generating FAT image: %w", n, err)
+			}
+			img.diskPath = "(generated FAT image)"
+			img.data = data
+			img.size = uint32(len(data))
+		default:
+			return fmt.Errorf("boot entry %d: none of Path, ImageFile and FAT is set", n)
+		}
+		if img.size == 0 {
+			return fmt.Errorf("boot entry %d: boot image '%s' is empty", n, img.diskPath)
+		}
+
+		switch entry.Emulation {
+		case NoEmulation:
+		case Floppy12Emulation, Floppy144Emulation, Floppy288Emulation:
+			if want := floppyImageSizes[entry.Emulation]; int64(img.size) != want {
+				return fmt.Errorf("boot entry %d: floppy image '%s' is %d bytes, emulation mode %d needs %d", n, img.diskPath, img.size, entry.Emulation, want)
+			}
+		case HardDiskEmulation:
+			systemType, err := readHardDiskSystemType(img.diskPath)
+			if err != nil {
+				return fmt.Errorf("boot entry %d: %w", n, err)
+			}
+			img.systemType = systemType
+		default:
+			return fmt.Errorf("boot entry %d: unknown emulation mode %d", n, entry.Emulation)
+		}
+		if entry.BootInfoTable && img.size < bootInfoTableOffset+bootInfoTableSize {
+			return fmt.Errorf("boot entry %d: boot image '%s' is too small for a boot info table", n, img.diskPath)
+		}
+		if entry.GRUB2BootInfo && img.size < grub2BootInfoOffset+8 {
+			return fmt.Errorf("boot entry %d: boot image '%s' is too small for GRUB2 boot info", n, img.diskPath)
+		}
+		b.bootImages = append(b.bootImages, img)
+	}
+	return nil
+}
+
+// findBootFile returns the index of the regular file at path (relative to the source directory).
+func (b *ISOBuilder) findBootFile(bootPath string) (int, error) {
+	isoPath := path.Join("/", bootPath)
+	for i := range b.fileEntries {
+		f := &b.fileEntries[i]
+		if f.isoPath != isoPath || f.relocatedDirIndex != 0 {
+			continue
+		}
+		if !f.hasDataExtent() {
+			return 0, fmt.Errorf("boot image '%s' is not a regular file", bootPath)
+		}
+		return i, nil
+	}
+	return 0, fmt.Errorf("boot image '%s' not found in source directory", bootPath)
+}
+
+// readHardDiskSystemType returns the partition type of the single partition in a hard disk image's MBR.
+// -> El Torito 1.0 Section 2.2, System Type
+func readHardDiskSystemType(diskPath string) (byte, error) {
+	f, err := os.Open(diskPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	mbr := make([]byte, 512)
+	if _, err := io.ReadFull(f, mbr); err != nil {
+		return 0, fmt.Errorf("reading MBR of hard disk image '%s': %w", diskPath, err)
+	}
+	if mbr[510] != 0x55 || mbr[511] != 0xAA {
+		return 0, fmt.Errorf("hard disk image '%s' has no MBR signature", diskPath)
+	}
+	var systemType byte
+	for i := 0; i < 4; i++ {
+		partitionType := mbr[446+i*16+4]
+		if partitionType == 0 {
+			continue
+		}
+		if systemType != 0 {
+			return 0, fmt.Errorf("hard disk image '%s' has more than one partition", diskPath)
+		}
+		systemType = partitionType
+	}
+	if systemType == 0 {
+		return 0, fmt.Errorf("hard disk image '%s' has no partition", diskPath)
+	}
+	return systemType, nil
+}
+
+// bootCatalogSize returns the byte length of the boot catalog.
+// : validation + default entry, then a section header per run of same-platform entries.
+func (b *ISOBuilder) bootCatalogSize() int {
+	entries := 2
+	for _, section := range b.bootSections() {
+		entries += 1 + len(section)
+	}
+	return entries * bootCatalogEntrySize
+}
+
+// bootSections groups the non-default boot images into sections of consecutive entries sharing a platform.
+func (b *ISOBuilder) bootSections() [][]bootImage {
+	var sections [][]bootImage
+	for i := 1; i < len(b.bootImages); i++ {
+		last := len(sections) - 1
+		if last >= 0 && sections[last][0].entry.Platform == b.bootImages[i].entry.Platform {
+			sections[last] = append(sections[last], b.bootImages[i])
+			continue
+		}
+		sections = append(sections, []bootImage{b.bootImages[i]})
+	}
+	return sections
+}
+
+// createBootRecordVolumeDescriptor generates the El Torito Boot Record sector.
+// -> El Torito 1.0 Section 2.0, the catalog pointer sits at byte 0x47.
+func (b *ISOBuilder) createBootRecordVolumeDescriptor() []byte {
+	brSectorBytes := make([]byte, SectorSize)
+	header := volumeDescriptorHeader{Type: vdTypeBootRecord, StandardIdentifier: [5]byte{'C', 'D', '0', '0', '1'}, Version: 1}
+	copy(brSectorBytes[0:7], header.marshalBinary())
+	copy(brSectorBytes[7:39], elToritoSystemID) // Boot System Identifier, zero padded
+	// bytes 39-70: Boot Identifier, unused (zeros)
+	binary.LittleEndian.PutUint32(brSectorBytes[0x47:0x4B], b.lbaBootCatalog)
+	return brSectorBytes
+}
+
+// createBootCatalog generates the boot catalog: validation entry, default entry, then the sections.
+// -> El Torito 1.0 Section 2.1 - 2.5
+func (b *ISOBuilder) createBootCatalog() []byte {
+	catalog := make([]byte, b.bootCatalogSize())
+
+	// Validation Entry, its platform is the default entry's
+	validation := catalog[0:bootCatalogEntrySize]
+	validation[0] = 0x01 // Header ID
+	validation[1] = byte(b.bootImages[0].entry.Platform)
+	// bytes 4-27: ID string (manufacturer/developer), left blank
+	validation[30] = 0x55 // key bytes
+	validation[31] = 0xAA
+	// checksum: the 16-bit words of the entry must sum to zero
+	var sum uint16
+	for i := 0; i < bootCatalogEntrySize; i += 2 {
+		sum += binary.LittleEndian.Uint16(validation[i:])
+	}
+	binary.LittleEndian.PutUint16(validation[28:30], -sum)
+
+	// Initial/Default Entry
+	offset := bootCatalogEntrySize
+	putBootCatalogEntry(catalog[offset:offset+bootCatalogEntrySize], b.bootImages[0])
+	offset += bootCatalogEntrySize
+
+	sections := b.bootSections()
+	for n, section := range sections {
+		// Section Header Entry
+		header := catalog[offset : offset+bootCatalogEntrySize]
+		header[0] = 0x90 // more headers follow
+		if n == len(sections)-1 {
+			header[0] = 0x91 // final header
+		}
+		header[1] = byte(section[0].entry.Platform)
+		binary.LittleEndian.PutUint16(header[2:4], uint16(len(section)))
+		// bytes 4-31: ID string, left blank
+		offset += bootCatalogEntrySize
+
+		// Section Entries, same layout as the default entry plus selection criteria (none)
+		for _, img := range section {
+			putBootCatalogEntry(catalog[offset:offset+bootCatalogEntrySize], img)
+			offset += bootCatalogEntrySize
+		}
+	}
+	return catalog
+}
+
+// putBootCatalogEntry fills a default or section entry.
+func putBootCatalogEntry(entryBytes []byte, img bootImage) {
+	entryBytes[0] = 0x88 // bootable
+	entryBytes[1] = byte(img.entry.Emulation)
+	loadSegment := img.entry.LoadSegment
+	if loadSegment == 0 && img.entry.Platform == BootPlatformX86 {
+		loadSegment = elToritoDefaultLoadSegment
+	}
+	binary.LittleEndian.PutUint16(entryBytes[2:4], loadSegment)
+	entryBytes[4] = img.systemType // System Type, only meaningful for hard disk emulation
+	sectorCount := img.entry.SectorCount
+	if img.entry.Emulation != NoEmulation {
+		sectorCount = 1 // emulated media: the BIOS reads the boot sector only
+	} else if sectorCount == 0 {
+		// like mkisofs, load the whole image in 512-byte virtual sectors
+		sectorCount = uint16(min((img.size+511)/512, 0xFFFF))
+	}
+	binary.LittleEndian.PutUint16(entryBytes[6:8], sectorCount)
+	binary.LittleEndian.PutUint32(entryBytes[8:12], img.sector)
+	// byte 12: selection criteria type, none
+}
+
+// isPatchedBootImage reports whether boot info is patched into the image at sector as it is written.
+func (b *ISOBuilder) isPatchedBootImage(sector uint32) bool {
+	for _, img := range b.bootImages {
+		if img.sector == sector && (img.entry.BootInfoTable || img.entry.GRUB2BootInfo) {
+			return true
+		}
+	}
+	return false
+}
+
+// patchBootImage patches the boot info requested by the entries using the image at sector into data,
+// a copy of the image read from disk.
+// -> mkisofs(8) -boot-info-table, xorriso(1) -boot_image grub grub2_boot_info=on
+func (b *ISOBuilder) patchBootImage(data []byte, sector uint32) {
+	for _, img := range b.bootImages {
+		if img.sector != sector {
+			continue
+		}
+		if img.entry.BootInfoTable {
+			// checksum: sum of the image's 32-bit little-endian words from offset 64 to the end
+			var checksum uint32
+			for i := bootInfoTableOffset + bootInfoTableSize; i < len(data); i += 4 {
+				var word [4]byte
+				copy(word[:], data[i:]) // the last word is zero padded
+				checksum += binary.LittleEndian.Uint32(word[:])
+			}
+			table := data[bootInfoTableOffset : bootInfoTableOffset+bootInfoTableSize]
+			clear(table)
+			binary.LittleEndian.PutUint32(table[0:4], SystemAreaNumSectors) // LBA of the PVD
+			binary.LittleEndian.PutUint32(table[4:8], img.sector)
+			binary.LittleEndian.PutUint32(table[8:12], img.size)
+			binary.LittleEndian.PutUint32(table[12:16], checksum)
+			// bytes 16-55: reserved (zeros)
+		}
+		if img.entry.GRUB2BootInfo {
+			binary.LittleEndian.PutUint64(data[grub2BootInfoOffset:grub2BootInfoOffset+8], uint64(img.sector)*4+5)
+		}
+	}
+}
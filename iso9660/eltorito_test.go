@@ -0,0 +1,237 @@
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readBootCatalog returns the sector holding the boot catalog the El Torito Boot Record points to.
+func readBootCatalog(t *testing.T, image []byte) []byte {
+	t.Helper()
+	for sector := SystemAreaNumSectors; (sector+1)*SectorSize <= len(image); sector++ {
+		vd := image[sector*SectorSize : (sector+1)*SectorSize]
+		if vd[0] == vdTypeTerminator {
+			break
+		}
+		if vd[0] != vdTypeBootRecord {
+			continue
+		}
+		if id := string(bytes.TrimRight(vd[7:39], "\x00")); id != elToritoSystemID {
+			t.Fatalf("boot record system identifier '%s'", id)
+		}
+		lba := int(binary.LittleEndian.Uint32(vd[0x47:0x4B]))
+		return image[lba*SectorSize : (lba+1)*SectorSize]
+	}
+	t.Fatal("no El Torito boot record")
+	return nil
+}
+
+// checkValidationEntry checks the catalog's validation entry: header, platform, key bytes and checksum.
+func checkValidationEntry(t *testing.T, catalog []byte, platform BootPlatform) {
+	t.Helper()
+	validation := catalog[:bootCatalogEntrySize]
+	if validation[0] != 0x01 || validation[1] != byte(platform) || validation[30] != 0x55 || validation[31] != 0xAA {
+		t.Errorf("validation entry %x", validation)
+	}
+	var sum uint16
+	for i := 0; i < bootCatalogEntrySize; i += 2 {
+		sum += binary.LittleEndian.Uint16(validation[i:])
+	}
+	if sum != 0 {
+		t.Errorf("validation entry words sum to %#x", sum)
+	}
+}
+
+// TestBootCatalog checks the validation entry and default entry of a BIOS bootable image.
+func TestBootCatalog(t *testing.T) {
+	source := writeSourceTree(t, map[string]string{"isolinux/isolinux.bin": strings.Repeat("\x90", 3000)})
+	b := NewBuilder(source, "", nil)
+	b.SetBootImage("isolinux/isolinux.bin", 0, 0)
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	image := buf.Bytes()
+	bootFile, err := openImage(t, image).Primary().Lookup("ISOLINUX/ISOLINUX.BIN")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	catalog := readBootCatalog(t, image)
+	checkValidationEntry(t, catalog, BootPlatformX86)
+	entry := catalog[bootCatalogEntrySize : 2*bootCatalogEntrySize]
+	if entry[0] != 0x88 || entry[1] != byte(NoEmulation) {
+		t.Errorf("default entry indicator %#x, emulation %d", entry[0], entry[1])
+	}
+	if segment := binary.LittleEndian.Uint16(entry[2:4]); segment != elToritoDefaultLoadSegment {
+		t.Errorf("default entry load segment %#x", segment)
+	}
+	if count := binary.LittleEndian.Uint16(entry[6:8]); count != 6 { // 3000 bytes in 512-byte virtual sectors
+		t.Errorf("default entry loads %d virtual sectors, want 6", count)
+	}
+	if lba := binary.LittleEndian.Uint32(entry[8:12]); lba != bootFile.LBA {
+		t.Errorf("default entry points at LBA %d, the boot image is at %d", lba, bootFile.LBA)
+	}
+	if rest := catalog[2*bootCatalogEntrySize:]; !bytes.Equal(rest, make([]byte, len(rest))) {
+		t.Error("catalog holds entries after the default entry")
+	}
+}
+
+// TestBootCatalogSections checks non-default entries are grouped into one section per run of a platform,
+// with a 0x90 header before each section but the last one's 0x91.
+func TestBootCatalogSections(t *testing.T) {
+	source := writeSourceTree(t, map[string]string{"bios.img": "bios", "efi1.img": "efi1", "efi2.img": "efi2", "ppc.img": "ppc"})
+	opts := DefaultOptions()
+	opts.BootEntries = []BootEntry{
+		{Path: "bios.img"},
+		{Platform: BootPlatformEFI, Path: "efi1.img"},
+		{Platform: BootPlatformEFI, Path: "efi2.img"},
+		{Platform: BootPlatformPowerPC, Path: "ppc.img"},
+	}
+	image := buildImage(t, source, opts)
+	vol := openImage(t, image).Primary()
+	catalog := readBootCatalog(t, image)
+	checkValidationEntry(t, catalog, BootPlatformX86)
+
+	entry := func(n int) []byte { return catalog[n*bootCatalogEntrySize : (n+1)*bootCatalogEntrySize] }
+	for _, tc := range []struct {
+		header   int // catalog entry of the section header
+		id       byte
+		platform BootPlatform
+		images   []string
+	}{
+		{2, 0x90, BootPlatformEFI, []string{"EFI1.IMG", "EFI2.IMG"}},
+		{5, 0x91, BootPlatformPowerPC, []string{"PPC.IMG"}},
+	} {
+		header := entry(tc.header)
+		if header[0] != tc.id || header[1] != byte(tc.platform) {
+			t.Errorf("section header %x, want id %#x platform %#x", header[:2], tc.id, tc.platform)
+		}
+		if count := binary.LittleEndian.Uint16(header[2:4]); int(count) != len(tc.images) {
+			t.Errorf("section of platform %#x announces %d entries, want %d", tc.platform, count, len(tc.images))
+		}
+		for i, name := range tc.images {
+			file, err := vol.Lookup(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			section := entry(tc.header + 1 + i)
+			if section[0] != 0x88 || binary.LittleEndian.Uint32(section[8:12]) != file.LBA {
+				t.Errorf("section entry of '%s': %x, image at LBA %d", name, section[:12], file.LBA)
+			}
+			if segment := binary.LittleEndian.Uint16(section[2:4]); segment != 0 {
+				t.Errorf("section entry of '%s' has load segment %#x, the BIOS default is for x86 only", name, segment)
+			}
+		}
+	}
+	if rest := catalog[7*bootCatalogEntrySize:]; !bytes.Equal(rest, make([]byte, len(rest))) {
+		t.Error("catalog holds entries after the last section")
+	}
+}
+
+// TestBootInfoPatching checks the boot info table and GRUB2 boot info patched into boot images as they are
+// written, the rest of the images and the source files left as they were.
+func TestBootInfoPatching(t *testing.T) {
+	pattern := func(n int) string {
+		data := make([]byte, n)
+		for i := range data {
+			data[i] = byte(i*7 + i/251)
+		}
+		return string(data)
+	}
+	isolinux, core := pattern(5001), pattern(3000) // 5001: the checksum's last word is zero padded
+	source := writeSourceTree(t, map[string]string{"boot/isolinux.bin": isolinux, "boot/core.img": core})
+	opts := DefaultOptions()
+	opts.BootEntries = []BootEntry{
+		{Path: "boot/isolinux.bin", BootInfoTable: true},
+		{Platform: BootPlatformEFI, Path: "boot/core.img", GRUB2BootInfo: true},
+	}
+	image := buildImage(t, source, opts)
+	vol := openImage(t, image).Primary()
+	readBootFile := func(name string) ([]byte, uint32) {
+		file, err := vol.Lookup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(file.Reader())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data, file.LBA
+	}
+
+	data, lba := readBootFile("BOOT/ISOLINUX.BIN")
+	var checksum uint32
+	padded := append([]byte(isolinux), 0, 0, 0)
+	for i := bootInfoTableOffset + bootInfoTableSize; i < len(isolinux); i += 4 {
+		checksum += binary.LittleEndian.Uint32(padded[i:])
+	}
+	table := data[bootInfoTableOffset : bootInfoTableOffset+bootInfoTableSize]
+	for i, want := range []uint32{SystemAreaNumSectors, lba, uint32(len(isolinux)), checksum} {
+		if got := binary.LittleEndian.Uint32(table[4*i:]); got != want {
+			t.Errorf("boot info table field %d: %#x, want %#x", i, got, want)
+		}
+	}
+	if !bytes.Equal(table[16:], make([]byte, 40)) {
+		t.Error("boot info table reserved bytes are not zero")
+	}
+	if string(data[:bootInfoTableOffset]) != isolinux[:bootInfoTableOffset] || string(data[64:]) != isolinux[64:] {
+		t.Error("boot info table patching changed bytes outside the table")
+	}
+
+	data, lba = readBootFile("BOOT/CORE.IMG")
+	if got := binary.LittleEndian.Uint64(data[grub2BootInfoOffset:]); got != uint64(lba)*4+5 {
+		t.Errorf("GRUB2 boot info %d, want %d", got, uint64(lba)*4+5)
+	}
+	if string(data[:grub2BootInfoOffset]) != core[:grub2BootInfoOffset] || string(data[grub2BootInfoOffset+8:]) != core[grub2BootInfoOffset+8:] {
+		t.Error("GRUB2 boot info patching changed bytes outside its slot")
+	}
+
+	for name, content := range map[string]string{"isolinux.bin": isolinux, "core.img": core} {
+		if got := readFile(t, filepath.Join(source, "boot", name)); string(got) != content {
+			t.Errorf("source boot image '%s' modified", name)
+		}
+	}
+}
+
+// TestBootEntriesPerBuilder checks boot entries added to builders sharing one Options stay with their builder.
+func TestBootEntriesPerBuilder(t *testing.T) {
+	source := writeSourceTree(t, map[string]string{"bios.img": "bios", "efi.img": "efi"})
+	opts := DefaultOptions()
+	bios := NewBuilder(source, "", opts)
+	bios.SetBootImage("bios.img", 0, 0)
+	efi := NewBuilder(source, "", opts)
+	efi.AddBootEntry(BootEntry{Platform: BootPlatformEFI, Path: "efi.img"})
+
+	if len(opts.BootEntries) != 0 {
+		t.Errorf("shared Options holds %d boot entries", len(opts.BootEntries))
+	}
+	for _, tc := range []struct {
+		b        *ISOBuilder
+		platform BootPlatform
+	}{{bios, BootPlatformX86}, {efi, BootPlatformEFI}} {
+		var buf bytes.Buffer
+		if _, err := tc.b.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		catalog := readBootCatalog(t, buf.Bytes())
+		checkValidationEntry(t, catalog, tc.platform)
+		if catalog[2*bootCatalogEntrySize] != 0 {
+			t.Errorf("platform %#x: catalog holds a section of the other builder's entry", tc.platform)
+		}
+	}
+}
+
+// TestBootRejectsLargeImageFile checks an external boot image of 4 GiB is refused, not truncated.
+func TestBootRejectsLargeImageFile(t *testing.T) {
+	b := NewBuilder(writeSourceTree(t, map[string]string{"a.txt": "a"}), "", nil)
+	b.AddBootEntry(BootEntry{Platform: BootPlatformEFI, ImageFile: writeSparseFile(t, 4<<30)})
+	_, err := b.WriteTo(io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "4 GiB or more") {
+		t.Errorf("building with a 4 GiB boot image returned %v", err)
+	}
+}
@@ -0,0 +1,510 @@
+package iso9660
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// FATImage describes an EFI System Partition image generated at build time.
+// : the FAT12/16 filesystem is sized to fit its files, nothing has to exist on disk beforehand.
+type FATImage struct {
+	Dir   string            // directory of the source tree copied into the image root (e.g., "efi" holding EFI/BOOT/BOOTX64.EFI)
+	Files map[string]string // more files: path inside the image (e.g., "EFI/BOOT/BOOTX64.EFI") -> file on disk
+}
+
+// fatFile is a file placed into a generated FAT image.
+type fatFile struct {
+	path     string // slash separated path inside the image
+	diskPath string
+	modTime  time.Time
+}
+
+// fatNode is a file or directory of the FAT image under construction.
+type fatNode struct {
+	name     string
+	isDir    bool
+	data     []byte
+	modTime  time.Time
+	children []*fatNode
+
+	shortName    [11]byte // 8.3 name, space padded
+	caseFlags    byte     // NT reserved byte: lowercase base (0x08) and extension (0x10)
+	longName     bool     // name needs VFAT long name entries
+	firstCluster uint32
+	numClusters  uint32
+}
+
+// AddEFIBootImage registers a UEFI El Torito entry whose FAT image is generated from dir,
+// a directory of the source tree holding EFI/BOOT/BOOTX64.EFI (and whatever else the loader needs).
+func (b *ISOBuilder) AddEFIBootImage(dir string) {
+	b.AddBootEntry(BootEntry{Platform: BootPlatformEFI, FAT: &FATImage{Dir: dir}})
+}
+
+// createEFIImage collects the files of a FATImage and generates the image.
+func (b *ISOBuilder) createEFIImage(spec *FATImage) ([]byte, error) {
+	var files []fatFile
+	if spec.Dir != "" {
+		dirPath := path.Join("/", spec.Dir)
+		dirIndex := -1
+		for i := range b.fileEntries {
+			if b.fileEntries[i].isDir && b.fileEntries[i].isoPath == dirPath {
+				dirIndex = i
+				break
+			}
+		}
+		if dirIndex < 0 {
+			return nil, fmt.Errorf("EFI image directory '%s' not found in source directory", spec.Dir)
+		}
+		var walk func(index int, prefix string)
+		walk = func(index int, prefix string) {
+			for _, childIndex := range b.fileEntries[index].children {
+				child := &b.fileEntries[childIndex]
+				childPath := path.Join(prefix, child.originalName)
+				if child.isDir {
+					walk(childIndex, childPath)
+				} else if child.hasDataExtent() {
+					files = append(files, fatFile{path: childPath, diskPath: child.diskPath, modTime: child.modTime})
+				}
+			}
+		}
+		walk(dirIndex, "")
+	}
+	imagePaths := make([]string, 0, len(spec.Files))
+	for imagePath := range spec.Files {
+		imagePaths = append(imagePaths, imagePath)
+	}
+	sort.Strings(imagePaths) // deterministic layout
+	for _, imagePath := range imagePaths {
+		info, err := os.Stat(spec.Files[imagePath])
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, fatFile{path: strings.Trim(imagePath, "/"), diskPath: spec.Files[imagePath], modTime: info.ModTime()})
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("EFI image has no files")
+	}
+	return createFATImage(files, "EFISYS", b.buildTime)
+}
+
+// createFATImage generates a FAT12 or FAT16 filesystem image holding files, labeled label at time created.
+// : the cluster count decides the FAT type (Microsoft FAT specification, "FAT Type Determination"),
+// : clusters grow until the count fits FAT16.
+func createFATImage(files []fatFile, label string, created time.Time) ([]byte, error) {
+	root := &fatNode{isDir: true}
+	for _, f := range files {
+		data, err := os.ReadFile(f.diskPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading '%s': %w", f.diskPath, err)
+		}
+		if err := root.insert(strings.Split(f.path, "/"), data, f.modTime); err != nil {
+			return nil, err
+		}
+	}
+	root.assignShortNames()
+	var labelName [11]byte
+	copy(labelName[:], padString(strings.ToUpper(label), 11))
+
+	rootEntries := uint32(fatDirEntryCount(root, true)) + 1 // volume label entry first
+	sectorsPerCluster := uint32(1)
+	var clusters uint32
+	for {
+		clusters = root.countClusters(sectorsPerCluster * fatSectorSize)
+		if clusters < fat16MaxClusters {
+			break
+		}
+		if sectorsPerCluster == 64 {
+			return nil, fmt.Errorf("EFI image files do not fit a FAT16 filesystem")
+		}
+		sectorsPerCluster *= 2
+	}
+	isFAT12 := clusters < fat12MaxClusters
+	if isFAT12 {
+		rootEntries = max(rootEntries, 224)
+	} else {
+		rootEntries = max(rootEntries, 512)
+	}
+	rootEntries = (rootEntries + 15) &^ 15 // whole sectors
+
+	var fatSectors uint32
+	if isFAT12 {
+		fatSectors = ((clusters+2)*3/2 + 1 + fatSectorSize - 1) / fatSectorSize
+	} else {
+		fatSectors = ((clusters+2)*2 + fatSectorSize - 1) / fatSectorSize
+	}
+	rootSectors := rootEntries * fatDirEntrySize / fatSectorSize
+	dataStart := 1 + 2*fatSectors + rootSectors // reserved sector, 2 FATs, root directory
+	totalSectors := dataStart + clusters*sectorsPerCluster
+	image := make([]byte, totalSectors*fatSectorSize)
+
+	// allocate clusters in tree order, then fill the FAT chains
+	next := uint32(2)
+	root.allocate(&next)
+	fat := image[fatSectorSize : fatSectorSize+fatSectors*fatSectorSize]
+	eoc := uint32(0xFFFF)
+	if isFAT12 {
+		eoc = 0xFFF
+	}
+	setFATEntry(fat, isFAT12, 0, eoc&^0x7|0x8) // media descriptor 0xF8 in the low byte
+	setFATEntry(fat, isFAT12, 1, eoc)
+	root.walk(func(n *fatNode) {
+		for c := uint32(0); c < n.numClusters; c++ {
+			value := n.firstCluster + c + 1
+			if c == n.numClusters-1 {
+				value = eoc
+			}
+			setFATEntry(fat, isFAT12, n.firstCluster+c, value)
+		}
+	})
+	copy(image[fatSectorSize+fatSectors*fatSectorSize:], fat) // second FAT
+
+	// directories and file data
+	clusterBytes := sectorsPerCluster * fatSectorSize
+	clusterOffset := func(cluster uint32) uint32 {
+		return dataStart*fatSectorSize + (cluster-2)*clusterBytes
+	}
+	rootOffset := (1 + 2*fatSectors) * fatSectorSize
+	rootDir := append(fatDirEntry(labelName, 0, fatAttrVolumeID, 0, 0, created), root.directoryEntries(nil)...)
+	copy(image[rootOffset:rootOffset+rootSectors*fatSectorSize], rootDir)
+	var fill func(dir *fatNode)
+	fill = func(dir *fatNode) {
+		for _, child := range dir.children {
+			if child.numClusters == 0 {
+				continue
+			}
+			if child.isDir {
+				copy(image[clusterOffset(child.firstCluster):], child.directoryEntries(dir))
+				fill(child)
+			} else {
+				copy(image[clusterOffset(child.firstCluster):], child.data)
+			}
+		}
+	}
+	fill(root)
+
+	// boot sector with the BIOS Parameter Block, no boot code: ESPs are read by firmware, never booted
+	bs := image[0:fatSectorSize]
+	copy(bs[0:3], []byte{0xEB, 0x3C, 0x90})
+	copy(bs[3:11], "MSWIN4.1") // OEM name, the most compatible value
+	binary.LittleEndian.PutUint16(bs[11:13], fatSectorSize)
+	bs[13] = byte(sectorsPerCluster)
+	binary.LittleEndian.PutUint16(bs[14:16], 1) // reserved sectors
+	bs[16] = 2                                  // number of FATs
+	binary.LittleEndian.PutUint16(bs[17:19], uint16(rootEntries))
+	if totalSectors < 0x10000 {
+		binary.LittleEndian.PutUint16(bs[19:21], uint16(totalSectors))
+	} else {
+		binary.LittleEndian.PutUint32(bs[32:36], totalSectors)
+	}
+	bs[21] = 0xF8 // media: fixed disk
+	binary.LittleEndian.PutUint16(bs[22:24], uint16(fatSectors))
+	binary.LittleEndian.PutUint16(bs[24:26], 32)                                        // sectors per track
+	binary.LittleEndian.PutUint16(bs[26:28], 64)                                        // heads
+	bs[36] = 0x80                                                                       // drive number
+	bs[38] = 0x29                                                                       // extended boot signature, the next three fields are present
+	binary.LittleEndian.PutUint32(bs[39:43], crc32.ChecksumIEEE(image[fatSectorSize:])) // volume serial, derived from the content
+	copy(bs[43:54], labelName[:])
+	if isFAT12 {
+		copy(bs[54:62], "FAT12   ")
+	} else {
+		copy(bs[54:62], "FAT16   ")
+	}
+	bs[510], bs[511] = 0x55, 0xAA
+	return image, nil
+}
+
+// insert adds a file below n, creating intermediate directories.
+func (n *fatNode) insert(components []string, data []byte, modTime time.Time) error {
+	name := components[0]
+	if name == "" || name == "." || name == ".." {
+		return fmt.Errorf("invalid path component '%s' in EFI image", name)
+	}
+	var child *fatNode
+	for _, c := range n.children {
+		if strings.EqualFold(c.name, name) { // FAT names are case-insensitive
+			child = c
+		}
+	}
+	if len(components) == 1 {
+		if child != nil {
+			return fmt.Errorf("duplicate path '%s' in EFI image", name)
+		}
+		n.children = append(n.children, &fatNode{name: name, data: data, modTime: modTime})
+		return nil
+	}
+	if child == nil {
+		child = &fatNode{name: name, isDir: true, modTime: modTime}
+		n.children = append(n.children, child)
+	} else if !child.isDir {
+		return fmt.Errorf("'%s' is both a file and a directory in EFI image", name)
+	}
+	return child.insert(components[1:], data, modTime)
+}
+
+// assignShortNames picks 8.3 names for the children of n (recursively), with "~N" tails where needed.
+func (n *fatNode) assignShortNames() {
+	used := make(map[[11]byte]bool)
+	for _, child := range n.children {
+		if short, caseFlags, ok := fatShortName(child.name); ok && !used[short] {
+			child.shortName, child.caseFlags = short, caseFlags
+			used[short] = true
+		}
+	}
+	for _, child := range n.children {
+		if child.shortName[0] != 0 {
+			continue
+		}
+		child.longName = true
+		base, ext := fatBasisName(child.name)
+		for i := 1; ; i++ {
+			tail := fmt.Sprintf("~%d", i)
+			var short [11]byte
+			copy(short[:], padString(base[:min(len(base), 8-len(tail))]+tail, 8))
+			copy(short[8:], padString(ext, 3))
+			if !used[short] {
+				child.shortName = short
+				used[short] = true
+				break
+			}
+		}
+	}
+	for _, child := range n.children {
+		if child.isDir {
+			child.assignShortNames()
+		}
+	}
+}
+
+// countClusters returns the clusters needed by everything below n (the root's own entries live in the root region).
+func (n *fatNode) countClusters(clusterBytes uint32) uint32 {
+	var total uint32
+	for _, child := range n.children {
+		if child.isDir {
+			child.numClusters = max(1, (uint32(fatDirEntryCount(child, false))*fatDirEntrySize+clusterBytes-1)/clusterBytes)
+			total += child.numClusters + child.countClusters(clusterBytes)
+		} else {
+			child.numClusters = (uint32(len(child.data)) + clusterBytes - 1) / clusterBytes // empty files own no cluster
+			total += child.numClusters
+		}
+	}
+	return total
+}
+
+// allocate assigns first clusters, depth-first.
+func (n *fatNode) allocate(next *uint32) {
+	for _, child := range n.children {
+		if child.numClusters > 0 {
+			child.firstCluster = *next
+			*next += child.numClusters
+		}
+		if child.isDir {
+			child.allocate(next)
+		}
+	}
+}
+
+// walk calls fn for every node below n.
+func (n *fatNode) walk(fn func(*fatNode)) {
+	for _, child := range n.children {
+		fn(child)
+		if child.isDir {
+			child.walk(fn)
+		}
+	}
+}
+
+// directoryEntries returns the directory's 32-byte entries: "." and ".." (unless root), then the children.
+func (n *fatNode) directoryEntries(parent *fatNode) []byte {
+	var buf []byte
+	if parent != nil {
+		var dot, dotDot [11]byte
+		copy(dot[:], padString(".", 11))
+		copy(dotDot[:], padString("..", 11))
+		buf = append(buf, fatDirEntry(dot, 0, fatAttrDirectory, n.firstCluster, 0, n.modTime)...)
+		buf = append(buf, fatDirEntry(dotDot, 0, fatAttrDirectory, parent.firstCluster, 0, n.modTime)...) // 0 for the root
+	}
+	for _, child := range n.children {
+		if child.longName {
+			buf = append(buf, fatLongNameEntries(child.name, child.shortName)...)
+		}
+		attr := byte(fatAttrArchive)
+		if child.isDir {
+			attr = fatAttrDirectory
+		}
+		buf = append(buf, fatDirEntry(child.shortName, child.caseFlags, attr, child.firstCluster, uint32(len(child.data)), child.modTime)...)
+	}
+	return buf
+}
+
+// fatDirEntryCount returns the number of 32-byte entries of directory n.
+func fatDirEntryCount(n *fatNode, isRoot bool) int {
+	count := 0
+	if !isRoot {
+		count = 2 // "." and ".."
+	}
+	for _, child := range n.children {
+		count++
+		if child.longName {
+			count += (len(utf16.Encode([]rune(child.name))) + 12) / 13
+		}
+	}
+	return count
+}
+
+// fatDirEntry builds a short directory entry.
+func fatDirEntry(shortName [11]byte, caseFlags, attr byte, cluster, size uint32, modTime time.Time) []byte {
+	entry := make([]byte, fatDirEntrySize)
+	copy(entry[0:11], shortName[:])
+	entry[11] = attr
+	entry[12] = caseFlags
+	date, clock := fatTimestamp(modTime)
+	binary.LittleEndian.PutUint16(entry[14:16], clock) // creation
+	binary.LittleEndian.PutUint16(entry[16:18], date)
+	binary.LittleEndian.PutUint16(entry[18:20], date) // last access
+	binary.LittleEndian.PutUint16(entry[20:22], uint16(cluster>>16))
+	binary.LittleEndian.PutUint16(entry[22:24], clock) // last write
+	binary.LittleEndian.PutUint16(entry[24:26], date)
+	binary.LittleEndian.PutUint16(entry[26:28], uint16(cluster))
+	binary.LittleEndian.PutUint32(entry[28:32], size)
+	return entry
+}
+
+// fatLongNameEntries builds the VFAT long name entries preceding a short entry, last part first.
+func fatLongNameEntries(name string, shortName [11]byte) []byte {
+	var checksum byte
+	for _, c := range shortName {
+		checksum = (checksum>>1 | checksum<<7) + c
+	}
+	units := utf16.Encode([]rune(name))
+	count := (len(units) + 12) / 13
+	if len(units)%13 != 0 {
+		units = append(units, 0x0000) // terminator, when the last entry has room
+	}
+	for len(units) < count*13 {
+		units = append(units, 0xFFFF) // padding
+	}
+	buf := make([]byte, 0, count*fatDirEntrySize)
+	for seq := count; seq >= 1; seq-- {
+		entry := make([]byte, fatDirEntrySize)
+		entry[0] = byte(seq)
+		if seq == count {
+			entry[0] |= 0x40 // last long entry
+		}
+		entry[11] = fatAttrLongName
+		entry[13] = checksum
+		part := units[(seq-1)*13 : seq*13]
+		for i, u := range part {
+			var offset int
+			switch {
+			case i < 5:
+				offset = 1 + 2*i
+			case i < 11:
+				offset = 14 + 2*(i-5)
+			default:
+				offset = 28 + 2*(i-11)
+			}
+			binary.LittleEndian.PutUint16(entry[offset:], u)
+		}
+		buf = append(buf, entry...)
+	}
+	return buf
+}
+
+// fatShortName returns the 8.3 form of name if it is a valid short name, possibly all-lowercase parts
+// (recorded through the NT case flags rather than long name entries).
+func fatShortName(name string) (short [11]byte, caseFlags byte, ok bool) {
+	base, ext := name, ""
+	if dot := strings.LastIndex(name, "."); dot >= 0 {
+		base, ext = name[:dot], name[dot+1:]
+	}
+	if len(base) == 0 || len(base) > 8 || len(ext) > 3 || strings.Contains(base, ".") {
+		return short, 0, false
+	}
+	partCase := func(part string, lowerFlag byte) (byte, bool) {
+		upper, lower := strings.ToUpper(part), strings.ToLower(part)
+		for _, r := range upper {
+			if !isFATShortNameChar(r) {
+				return 0, false
+			}
+		}
+		switch part {
+		case upper:
+			return 0, true
+		case lower:
+			return lowerFlag, true
+		}
+		return 0, false // mixed case needs a long name
+	}
+	baseFlag, ok1 := partCase(base, 0x08)
+	extFlag, ok2 := partCase(ext, 0x10)
+	if !ok1 || !ok2 {
+		return short, 0, false
+	}
+	copy(short[:8], padString(strings.ToUpper(base), 8))
+	copy(short[8:], padString(strings.ToUpper(ext), 3))
+	return short, baseFlag | extFlag, true
+}
+
+// fatBasisName returns the uppercase, filtered base and extension a numeric-tail short name is built from.
+func fatBasisName(name string) (base, ext string) {
+	filter := func(s string, limit int) string {
+		var sb strings.Builder
+		for _, r := range strings.ToUpper(s) {
+			if r == ' ' || r == '.' {
+				continue
+			}
+			if !isFATShortNameChar(r) {
+				r = '_'
+			}
+			sb.WriteRune(r)
+			if sb.Len() == limit {
+				break
+			}
+		}
+		return sb.String()
+	}
+	name = strings.TrimLeft(name, ".")
+	if dot := strings.LastIndex(name, "."); dot >= 0 {
+		return filter(name[:dot], 6), filter(name[dot+1:], 3)
+	}
+	return filter(name, 6), ""
+}
+
+// isFATShortNameChar reports whether r may appear in a short name.
+func isFATShortNameChar(r rune) bool {
+	return (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || strings.ContainsRune("!#$%&'()-@^_`{}~", r)
+}
+
+// fatTimestamp converts t to DOS date and time (2 second resolution, 1980-2107), times before 1980 clamp to 1980-01-01.
+func fatTimestamp(t time.Time) (date, clock uint16) {
+	t = t.UTC()
+	if t.Year() < 1980 {
+		return 1<<5 | 1, 0
+	}
+	date = uint16((min(t.Year(), 2107)-1980)<<9 | int(t.Month())<<5 | t.Day())
+	clock = uint16(t.Hour()<<11 | t.Minute()<<5 | t.Second()/2)
+	return date, clock
+}
+
+// setFATEntry stores a 12- or 16-bit FAT entry.
+func setFATEntry(fat []byte, isFAT12 bool, cluster, value uint32) {
+	if !isFAT12 {
+		binary.LittleEndian.PutUint16(fat[cluster*2:], uint16(value))
+		return
+	}
+	offset := cluster * 3 / 2
+	if cluster%2 == 0 {
+		fat[offset] = byte(value)
+		fat[offset+1] = fat[offset+1]&0xF0 | byte(value>>8)&0x0F
+	} else {
+		fat[offset] = fat[offset]&0x0F | byte(value<<4)
+		fat[offset+1] = byte(value >> 4)
+	}
+}
@@ -0,0 +1,344 @@
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// fatVolume is a FAT12/16 image parsed back from its boot sector.
+type fatVolume struct {
+	image        []byte
+	isFAT12      bool
+	clusterBytes int
+	fat          []byte // first FAT
+	rootDir      []byte // fixed root directory region
+	dataStart    int    // byte offset of cluster 2
+}
+
+// fatTestEntry is a short directory entry with the long name of the entries preceding it.
+type fatTestEntry struct {
+	longName  string // "" without long name entries
+	shortName string // 11 bytes, space padded
+	attr      byte
+	caseFlags byte
+	cluster   uint32
+	size      uint32
+	date      uint16
+}
+
+// openFATVolume checks the boot sector of image and the two FAT copies.
+func openFATVolume(t *testing.T, image []byte, label, fsType string) *fatVolume {
+	t.Helper()
+	bs := image[:fatSectorSize]
+	if !bytes.Equal(bs[0:3], []byte{0xEB, 0x3C, 0x90}) || bs[510] != 0x55 || bs[511] != 0xAA {
+		t.Fatalf("boot sector jump %x, signature %x", bs[0:3], bs[510:512])
+	}
+	bytesPerSector := int(binary.LittleEndian.Uint16(bs[11:13]))
+	sectorsPerCluster := int(bs[13])
+	reserved := int(binary.LittleEndian.Uint16(bs[14:16]))
+	rootEntries := int(binary.LittleEndian.Uint16(bs[17:19]))
+	totalSectors := int(binary.LittleEndian.Uint16(bs[19:21]))
+	if totalSectors == 0 {
+		totalSectors = int(binary.LittleEndian.Uint32(bs[32:36]))
+	}
+	fatSectors := int(binary.LittleEndian.Uint16(bs[22:24]))
+	if bytesPerSector != fatSectorSize || reserved != 1 || bs[16] != 2 || bs[21] != 0xF8 || bs[38] != 0x29 {
+		t.Errorf("BPB: %d bytes per sector, %d reserved, %d FATs, media %#x, signature %#x", bytesPerSector, reserved, bs[16], bs[21], bs[38])
+	}
+	if sectorsPerCluster == 0 || sectorsPerCluster&(sectorsPerCluster-1) != 0 || rootEntries%16 != 0 {
+		t.Errorf("BPB: %d sectors per cluster, %d root entries", sectorsPerCluster, rootEntries)
+	}
+	if totalSectors*fatSectorSize != len(image) {
+		t.Errorf("BPB: %d sectors, the image holds %d bytes", totalSectors, len(image))
+	}
+	if got := string(bs[43:54]); got != label {
+		t.Errorf("boot sector label %q, want %q", got, label)
+	}
+	if got := string(bs[54:62]); got != fsType {
+		t.Errorf("file system type %q, want %q", got, fsType)
+	}
+
+	fatStart := reserved * fatSectorSize
+	fatBytes := fatSectors * fatSectorSize
+	v := &fatVolume{
+		image:        image,
+		isFAT12:      fsType == "FAT12   ",
+		clusterBytes: sectorsPerCluster * fatSectorSize,
+		fat:          image[fatStart : fatStart+fatBytes],
+	}
+	if !bytes.Equal(v.fat, image[fatStart+fatBytes:fatStart+2*fatBytes]) {
+		t.Error("the two FAT copies differ")
+	}
+	rootStart := fatStart + 2*fatBytes
+	v.rootDir = image[rootStart : rootStart+rootEntries*fatDirEntrySize]
+	v.dataStart = rootStart + len(v.rootDir)
+	clusters := (len(image) - v.dataStart) / v.clusterBytes
+	if isFAT12 := clusters < fat12MaxClusters; isFAT12 != v.isFAT12 {
+		t.Errorf("%d clusters recorded as %q", clusters, fsType)
+	}
+	if media := v.entry(0); media&0xFF != 0xF8 {
+		t.Errorf("FAT entry 0 is %#x, want the media descriptor", media)
+	}
+	return v
+}
+
+// entry returns the FAT entry of cluster.
+func (v *fatVolume) entry(cluster uint32) uint32 {
+	if !v.isFAT12 {
+		return uint32(binary.LittleEndian.Uint16(v.fat[cluster*2:]))
+	}
+	pair := uint32(binary.LittleEndian.Uint16(v.fat[cluster*3/2:]))
+	if cluster%2 == 1 {
+		return pair >> 4
+	}
+	return pair & 0xFFF
+}
+
+// read follows the cluster chain starting at first and returns its data, checking the chain ends after
+// exactly the clusters size needs (at least one for directories, size 0).
+func (v *fatVolume) read(t *testing.T, first, size uint32, isDir bool) []byte {
+	t.Helper()
+	eoc := uint32(0xFFF8)
+	if v.isFAT12 {
+		eoc = 0xFF8
+	}
+	var data []byte
+	for cluster := first; ; cluster = v.entry(cluster) {
+		if cluster < 2 || len(data) > len(v.image) {
+			t.Fatalf("cluster chain from %d reaches %d", first, cluster)
+		}
+		offset := v.dataStart + int(cluster-2)*v.clusterBytes
+		data = append(data, v.image[offset:offset+v.clusterBytes]...)
+		if v.entry(cluster) >= eoc {
+			break
+		}
+	}
+	if isDir {
+		return data
+	}
+	if want := (int(size) + v.clusterBytes - 1) / v.clusterBytes * v.clusterBytes; len(data) != want {
+		t.Errorf("chain from cluster %d holds %d bytes for a %d byte file", first, len(data), size)
+	}
+	return data[:size]
+}
+
+// readFATDirectory decodes the entries of a directory, pairing long name entries with the short entry
+// they precede through their sequence numbers and checksum.
+func readFATDirectory(t *testing.T, data []byte) []fatTestEntry {
+	t.Helper()
+	var entries []fatTestEntry
+	var parts []string // long name parts, last part first
+	var checksum byte
+	next := 0 // sequence number of the next long name entry, 0 once the name is complete
+	for offset := 0; offset+fatDirEntrySize <= len(data) && data[offset] != 0; offset += fatDirEntrySize {
+		raw := data[offset : offset+fatDirEntrySize]
+		if raw[11] == fatAttrLongName {
+			seq := int(raw[0] &^ 0x40)
+			if raw[0]&0x40 != 0 {
+				parts, checksum, next = nil, raw[13], seq
+			}
+			if seq == 0 || seq != next || raw[13] != checksum {
+				t.Fatalf("long name entry %#x (checksum %#x) where %d (checksum %#x) was expected", raw[0], raw[13], next, checksum)
+			}
+			next--
+			var units []uint16
+			for _, at := range []int{1, 3, 5, 7, 9, 14, 16, 18, 20, 22, 24, 28, 30} {
+				units = append(units, binary.LittleEndian.Uint16(raw[at:]))
+			}
+			if end := indexUint16(units, 0); end >= 0 {
+				units = units[:end]
+			}
+			parts = append(parts, string(utf16.Decode(units)))
+			continue
+		}
+		e := fatTestEntry{
+			shortName: string(raw[0:11]),
+			attr:      raw[11],
+			caseFlags: raw[12],
+			cluster:   uint32(binary.LittleEndian.Uint16(raw[20:22]))<<16 | uint32(binary.LittleEndian.Uint16(raw[26:28])),
+			size:      binary.LittleEndian.Uint32(raw[28:32]),
+			date:      binary.LittleEndian.Uint16(raw[24:26]),
+		}
+		if parts != nil {
+			var sum byte
+			for _, c := range raw[0:11] {
+				sum = (sum>>1 | sum<<7) + c
+			}
+			if sum != checksum || next != 0 {
+				t.Errorf("long name %q of '%s': checksum %#x, short name checksum %#x", parts, e.shortName, checksum, sum)
+			}
+			for i := len(parts) - 1; i >= 0; i-- {
+				e.longName += parts[i]
+			}
+			parts = nil
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// indexUint16 returns the index of the first v in units, or -1.
+func indexUint16(units []uint16, v uint16) int {
+	for i, u := range units {
+		if u == v {
+			return i
+		}
+	}
+	return -1
+}
+
+// fatEntryName returns the name an entry shows: its long name, else the short name with the case flags applied.
+func fatEntryName(e fatTestEntry) string {
+	if e.longName != "" {
+		return e.longName
+	}
+	base, ext := strings.TrimRight(e.shortName[:8], " "), strings.TrimRight(e.shortName[8:], " ")
+	if e.caseFlags&0x08 != 0 {
+		base = strings.ToLower(base)
+	}
+	if e.caseFlags&0x10 != 0 {
+		ext = strings.ToLower(ext)
+	}
+	if ext == "" {
+		return base
+	}
+	return base + "." + ext
+}
+
+// walkFATDirectory collects the entries below a directory by path, checking the "." and ".." entries of subdirectories.
+func walkFATDirectory(t *testing.T, v *fatVolume, entries []fatTestEntry, dir string, cluster uint32, found map[string]fatTestEntry) {
+	t.Helper()
+	for _, e := range entries {
+		if e.attr&fatAttrVolumeID != 0 {
+			continue
+		}
+		name := path.Join(dir, fatEntryName(e))
+		found[name] = e
+		if e.attr&fatAttrDirectory == 0 {
+			continue
+		}
+		sub := readFATDirectory(t, v.read(t, e.cluster, 0, true))
+		if len(sub) < 2 || sub[0].shortName != ".          " || sub[0].cluster != e.cluster ||
+			sub[1].shortName != "..         " || sub[1].cluster != cluster {
+			t.Fatalf("'%s' (cluster %d, parent %d) starts with %+v", name, e.cluster, cluster, sub[:min(len(sub), 2)])
+		}
+		walkFATDirectory(t, v, sub[2:], name, e.cluster, found)
+	}
+}
+
+func TestFATImage(t *testing.T) {
+	modTime := time.Date(2024, 6, 1, 12, 34, 56, 0, time.UTC)
+	type fatTestFile struct {
+		path      string
+		size      int
+		shortName string
+		caseFlags byte
+		longName  bool
+	}
+	small := []fatTestFile{
+		{"EFI/BOOT/BOOTX64.EFI", 3000, "BOOTX64 EFI", 0, false},
+		{"lower.txt", 10, "LOWER   TXT", 0x18, false},
+		{"README", 0, "README     ", 0, false},
+		{"Mixed.Txt", 20, "MIXED~1 TXT", 0, true},
+		{"a long file name.efi", 700, "ALONGF~1EFI", 0, true},
+		{"a long file name 2.efi", 5, "ALONGF~2EFI", 0, true},
+		{"EFI/café.txt", 1, "CAF_~1  TXT", 0, true},
+		{"EFI/thirteen_char", 2, "THIRTE~1   ", 0, true}, // long name filling its entry, no terminator
+	}
+	tests := []struct {
+		name   string
+		files  []fatTestFile
+		fsType string
+	}{
+		{"FAT12", small, "FAT12   "},
+		{"FAT16", append(slices.Clone(small), fatTestFile{"EFI/BOOT/big.img", 4100 * fatSectorSize, "BIG     IMG", 0x18, false}), "FAT16   "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sourceDir := t.TempDir()
+			var files []fatFile
+			contents := make(map[string][]byte)
+			for i, f := range tt.files {
+				data := bytes.Repeat([]byte{byte(i + 1), byte(f.size)}, (f.size+1)/2)[:f.size]
+				diskPath := filepath.Join(sourceDir, strconv.Itoa(i))
+				if err := os.WriteFile(diskPath, data, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				files = append(files, fatFile{path: f.path, diskPath: diskPath, modTime: modTime})
+				contents[f.path] = data
+			}
+			image, err := createFATImage(files, "efisys", modTime)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v := openFATVolume(t, image, "EFISYS     ", tt.fsType)
+
+			root := readFATDirectory(t, v.rootDir)
+			if len(root) == 0 || root[0].attr != fatAttrVolumeID || root[0].shortName != string(image[43:54]) || root[0].cluster != 0 {
+				t.Fatalf("root directory starts with %+v, want the volume label", root[:min(len(root), 1)])
+			}
+			found := make(map[string]fatTestEntry)
+			walkFATDirectory(t, v, root, "", 0, found)
+			wantDate, _ := fatTimestamp(modTime)
+			for _, f := range tt.files {
+				e, ok := found[f.path]
+				if !ok {
+					t.Errorf("'%s' is missing from the image", f.path)
+					continue
+				}
+				if e.shortName != f.shortName || e.caseFlags != f.caseFlags || (e.longName != "") != f.longName {
+					t.Errorf("'%s' recorded as '%s' (case flags %#x, long name %q), want '%s' (case flags %#x)",
+						f.path, e.shortName, e.caseFlags, e.longName, f.shortName, f.caseFlags)
+				}
+				if e.attr != fatAttrArchive || e.date != wantDate {
+					t.Errorf("'%s': attributes %#x, date %#x", f.path, e.attr, e.date)
+				}
+				if f.size == 0 {
+					if e.cluster != 0 || e.size != 0 {
+						t.Errorf("empty '%s' at cluster %d with size %d", f.path, e.cluster, e.size)
+					}
+					continue
+				}
+				if got := v.read(t, e.cluster, e.size, false); !bytes.Equal(got, contents[f.path]) {
+					t.Errorf("'%s': read %d bytes that differ from the %d byte source", f.path, len(got), f.size)
+				}
+			}
+			for _, dir := range []string{"EFI", "EFI/BOOT"} {
+				if e := found[dir]; e.attr != fatAttrDirectory || e.shortName != string(padString(path.Base(dir), 11)) {
+					t.Errorf("directory '%s' recorded as %+v", dir, e)
+				}
+			}
+			if want := len(tt.files) + 2; len(found) != want {
+				t.Errorf("found %d entries, want %d", len(found), want)
+			}
+		})
+	}
+}
+
+func TestFATTimestamp(t *testing.T) {
+	plusOne := time.FixedZone("+01:00", 3600)
+	tests := []struct {
+		t           time.Time
+		date, clock uint16
+	}{
+		{time.Date(2024, 6, 1, 12, 34, 57, 0, time.UTC), 44<<9 | 6<<5 | 1, 12<<11 | 34<<5 | 28}, // 2 second resolution
+		{time.Date(2024, 1, 1, 0, 30, 0, 0, plusOne), 43<<9 | 12<<5 | 31, 23<<11 | 30<<5},       // local new year is 2023 in UTC
+		{time.Date(1980, 1, 1, 0, 30, 0, 0, plusOne), 1<<5 | 1, 0},                              // 1979 in UTC clamps
+		{time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC), 1<<5 | 1, 0},
+		{time.Date(2200, 3, 4, 5, 6, 8, 0, time.UTC), 127<<9 | 3<<5 | 4, 5<<11 | 6<<5 | 4},
+	}
+	for _, tt := range tests {
+		if date, clock := fatTimestamp(tt.t); date != tt.date || clock != tt.clock {
+			t.Errorf("fatTimestamp(%v) = %#04x, %#04x, want %#04x, %#04x", tt.t, date, clock, tt.date, tt.clock)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package iso9660
+
+import (
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"time"
+)
+
+// FS exposes one directory tree of an image (ISO 9660 or Joliet) as an fs.FS.
+// : file data is served lazily from the image's io.ReaderAt, nothing is loaded up front.
+type FS struct {
+	vol *Volume
+}
+
+// compile-time interface checks
+var (
+	_ fs.FS        = (*FS)(nil)
+	_ fs.ReadDirFS = (*FS)(nil)
+	_ fs.StatFS    = (*FS)(nil)
+)
+
+// FS returns an fs.FS over the volume's directory tree.
+// : for the ISO 9660 tree, ";1" version suffixes are stripped from file names.
+func (v *Volume) FS() *FS {
+	return &FS{vol: v}
+}
+
+// FS returns an fs.FS over the Joliet tree if the image has one, else over the ISO 9660 tree.
+func (img *Image) FS() *FS {
+	if img.joliet != nil {
+		return img.joliet.FS()
+	}
+	return img.primary.FS()
+}
+
+// Open opens the named file or directory.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	e, err := fsys.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	info := fsys.newFileInfo(e, path.Base(name))
+	if e.IsDir() {
+		return &dirHandle{fsys: fsys, path: name, entry: e, info: info}, nil
+	}
+	return &fileHandle{SectionReader: e.Reader(), info: info}, nil
+}
+
+// ReadDir reads the named directory and returns its entries sorted by filename.
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	e, err := fsys.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !e.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return fsys.readDirEntries("readdir", name, e)
+}
+
+// Stat returns a FileInfo describing the named file.
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	e, err := fsys.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fsys.newFileInfo(e, path.Base(name)), nil
+}
+
+// lookup resolves an fs.FS path to its directory record.
+func (fsys *FS) lookup(op, name string) (*Entry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	current := fsys.vol.root
+	if name == "." {
+		return current, nil
+	}
+	dir, base := path.Split(name)
+	if dir != "" {
+		parent, err := fsys.lookup(op, path.Clean(dir))
+		if err != nil {
+			return nil, err
+		}
+		current = parent
+	}
+	if !current.IsDir() {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	children, err := fsys.vol.ReadDir(current)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	for _, child := range children {
+		if fsys.entryName(child) == base {
+			return child, nil
+		}
+	}
+	return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+// readDirEntries lists directory e as sorted fs.DirEntry values.
+func (fsys *FS) readDirEntries(op, name string, e *Entry) ([]fs.DirEntry, error) {
+	children, err := fsys.vol.ReadDir(e)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	list := make([]fs.DirEntry, len(children))
+	for i, child := range children {
+		list[i] = fs.FileInfoToDirEntry(fsys.newFileInfo(child, fsys.entryName(child)))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	return list, nil
+}
+
+// entryName returns the name an entry is known by in the fs.FS view.
+func (fsys *FS) entryName(e *Entry) string {
+	if fsys.vol.Joliet {
+		return e.Name
+	}
+	return stripVersion(e.Name)
+}
+
+// newFileInfo builds the fs.FileInfo for an entry under the given name.
+func (fsys *FS) newFileInfo(e *Entry, name string) *fileInfo {
+	return &fileInfo{name: name, entry: e}
+}
+
+// fileInfo implements fs.FileInfo for a directory record.
+type fileInfo struct {
+	name  string
+	entry *Entry
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.entry.Size }
+func (fi *fileInfo) ModTime() time.Time { return fi.entry.RecordingTime }
+func (fi *fileInfo) IsDir() bool        { return fi.entry.IsDir() }
+func (fi *fileInfo) Sys() any           { return fi.entry } // underlying *Entry
+
+func (fi *fileInfo) Mode() fs.FileMode {
+	if fi.entry.IsDir() {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
+
+// fileHandle is an open regular file, reads go straight to the image.
+type fileHandle struct {
+	*io.SectionReader
+	info *fileInfo
+}
+
+func (f *fileHandle) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *fileHandle) Close() error               { return nil }
+
+// dirHandle is an open directory, implementing fs.ReadDirFile.
+type dirHandle struct {
+	fsys    *FS
+	path    string
+	entry   *Entry
+	info    *fileInfo
+	entries []fs.DirEntry // loaded on the first ReadDir call
+	loaded  bool
+	offset  int
+}
+
+func (d *dirHandle) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *dirHandle) Close() error               { return nil }
+
+func (d *dirHandle) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.path, Err: fs.ErrInvalid}
+}
+
+// ReadDir follows the fs.ReadDirFile contract: n <= 0 returns everything left,
+// n > 0 returns at most n entries and io.EOF once the directory is exhausted.
+func (d *dirHandle) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.loaded {
+		entries, err := d.fsys.readDirEntries("readdir", d.path, d.entry)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.loaded = entries, true
+	}
+	remaining := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(remaining) {
+		n = len(remaining)
+	}
+	d.offset += n
+	return remaining[:n], nil
+}
@@ -0,0 +1,56 @@
+package iso9660
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// roundTripTree is a source tree exercising names, sizes and depths the trees must all record.
+var roundTripTree = map[string]string{
+	"readme.txt":                 "hello",
+	"docs/report 2023.txt":       strings.Repeat("report ", 1000), // several sectors
+	"docs/empty.txt":             "",
+	"docs/a.long.name.with.dots": "dots",
+	"unicode/café ünïcödé.txt":   "ü",
+	"a/b/c/d/e/f/g/h/i/deep.txt": "deep", // relocated with Rock Ridge
+	"emptydir/":                  "",
+}
+
+// TestFSRoundTrip builds images of roundTripTree, opens them and checks every tree with fstest.TestFS,
+// and the Joliet tree's files against the source.
+func TestFSRoundTrip(t *testing.T) {
+	source := writeSourceTree(t, roundTripTree)
+	enhanced := rockRidgeOptions()
+	enhanced.EnhancedVolumeDescriptor = true
+
+	for _, opts := range []*Options{DefaultOptions(), enhanced} {
+		img := openImage(t, buildImage(t, source, opts))
+
+		var files []string
+		for name, content := range roundTripTree {
+			if strings.HasSuffix(name, "/") {
+				continue
+			}
+			files = append(files, name)
+			data, err := fs.ReadFile(img.FS(), name)
+			if err != nil {
+				t.Errorf("reading '%s': %v", name, err)
+			} else if string(data) != content {
+				t.Errorf("'%s' holds %d bytes, want %d", name, len(data), len(content))
+			}
+		}
+		if err := fstest.TestFS(img.FS(), files...); err != nil {
+			t.Errorf("Joliet tree (RockRidge=%t): %v", opts.RockRidge, err)
+		}
+		if err := fstest.TestFS(img.Primary().FS(), "README.TXT", "DOCS/EMPTY.TXT"); err != nil {
+			t.Errorf("ISO9660 tree (RockRidge=%t): %v", opts.RockRidge, err)
+		}
+		if opts.EnhancedVolumeDescriptor {
+			if err := fstest.TestFS(img.Enhanced().FS(), files...); err != nil {
+				t.Errorf("ISO 9660:1999 tree: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package iso9660
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"unicode/utf16"
+)
+
+// HybridOptions lays out the system area so the image also boots when written raw to a USB stick (isohybrid).
+type HybridOptions struct {
+	MBRTemplate string // file holding MBR boot code (e.g., syslinux isohdpfx.bin), 432 or 446 bytes; empty for none
+	GPT         bool   // protective MBR plus GPT, instead of a plain MBR partition table
+	EFIImage    string // EFI System Partition image on disk, appended after the ISO data as its own partition
+}
+
+// GPT partition type GUIDs (UEFI 2.x Section 5.3.3)
+const (
+	gptTypeBasicData = "EBD0A0A2-B9E5-4433-87C0-68B6B72699C7" // ISO 9660 data, as xorriso labels it
+	gptTypeESP       = "C12A7328-F81F-11D2-BA4B-00A0C93EC93B" // EFI System Partition
+)
+
+// isHybrid reports whether the system area carries a partition table.
+func (b *ISOBuilder) isHybrid() bool {
+	return b.options.Hybrid != nil
+}
+
+// resolveHybrid loads the MBR template and sizes the appended EFI System Partition.
+func (b *ISOBuilder) resolveHybrid() error {
+	b.mbrTemplate, b.espSize = nil, 0
+	if !b.isHybrid() {
+		return nil
+	}
+	hybrid := b.options.Hybrid
+	if hybrid.MBRTemplate != "" {
+		template, err := os.ReadFile(hybrid.MBRTemplate)
+		if err != nil {
+			return fmt.Errorf("reading MBR template: %w", err)
+		}
+		if len(template) != mbrBootCodeSize && len(template) != mbrPartitionTableOffset {
+			return fmt.Errorf("MBR template '%s' is %d bytes, expected %d or %d", hybrid.MBRTemplate, len(template), mbrBootCodeSize, mbrPartitionTableOffset)
+		}
+		b.mbrTemplate = template
+	}
+	if hybrid.EFIImage != "" {
+		info, err := os.Stat(hybrid.EFIImage)
+		if err != nil {
+			return fmt.Errorf("EFI System Partition image: %w", err)
+		}
+		if !info.Mode().IsRegular() || info.Size() == 0 {
+			return fmt.Errorf("EFI System Partition image '%s' is not a regular, non-empty file", hybrid.EFIImage)
+		}
+		if info.Size() > math.MaxUint32 {
+			return fmt.Errorf("EFI System Partition image '%s' is 4 GiB or more", hybrid.EFIImage)
+		}
+		b.espSize = uint32(info.Size())
+	}
+	return nil
+}
+
+// isAppendedESP reports whether diskPath is the appended EFI System Partition image.
+// : an El Torito EFI entry using the same image points into the partition instead of a second copy.
+func (b *ISOBuilder) isAppendedESP(diskPath string) bool {
+	return b.isHybrid() && b.options.Hybrid.EFIImage != "" && b.options.Hybrid.EFIImage == diskPath
+}
+
+// assignHybridLBAs places the appended EFI System Partition after the ISO data.
+func (b *ISOBuilder) assignHybridLBAs(startLBA uint32) uint32 {
+	currentLBA := startLBA
+	if b.espSize > 0 {
+		b.lbaESP = currentLBA
+		currentLBA += sectorsToContainFileBytes(b.espSize)
+		for n := range b.bootImages {
+			if b.bootImages[n].fileIndex < 0 && b.isAppendedESP(b.bootImages[n].diskPath) {
+				b.bootImages[n].sector = b.lbaESP
+			}
+		}
+	}
+	return currentLBA
+}
+
+// gptBackupSectors returns the number of sectors reserved at the end of the image for the backup GPT.
+func (b *ISOBuilder) gptBackupSectors() uint32 {
+	if !b.isHybrid() || !b.options.Hybrid.GPT {
+		return 0
+	}
+	return sectorsToContainBytes((gptEntryArraySectors + 1) * mbrSectorSize)
+}
+
+// createSystemArea generates the 16 sectors of the system area: MBR and, optionally, the primary GPT.
+func (b *ISOBuilder) createSystemArea() []byte {
+	area := make([]byte, SystemAreaNumSectors*SectorSize)
+	if !b.isHybrid() {
+		return area
+	}
+	mbr := area[0:mbrSectorSize]
+	copy(mbr, b.mbrTemplate)
+	if len(b.mbrTemplate) == mbrBootCodeSize {
+		// isohdpfx-style boot code chains to the El Torito default image, it expects its 512-byte LBA at 432
+		if len(b.bootImages) > 0 && b.bootImages[0].entry.Emulation == NoEmulation {
+			binary.LittleEndian.PutUint64(mbr[432:440], uint64(b.bootImages[0].sector)*4)
+		}
+		signature := b.deriveGUID(0)
+		copy(mbr[440:444], signature[:4]) // disk signature
+	}
+
+	imageBlocks := uint64(b.totalSectors) * 4 // 512-byte blocks
+	espStart, espBlocks := uint64(b.lbaESP)*4, uint64(sectorsToContainFileBytes(b.espSize))*4
+
+	if b.options.Hybrid.GPT {
+		// protective MBR, the whole disk belongs to the GPT
+		putMBRPartition(mbr, 0, 0x00, 0xEE, 1, imageBlocks-1)
+		entries := b.createGPTEntries()
+		header := createGPTHeader(1, imageBlocks-1, 2, imageBlocks, b.deriveGUID(1), entries)
+		copy(area[mbrSectorSize:], header)
+		copy(area[2*mbrSectorSize:], entries)
+	} else {
+		// the ISO partition starts at block 0 so the filesystem is found whether the device or the partition is mounted
+		isoBlocks := imageBlocks
+		if b.espSize > 0 {
+			isoBlocks = espStart
+		}
+		putMBRPartition(mbr, 0, 0x80, 0x17, 0, isoBlocks)
+		if b.espSize > 0 {
+			putMBRPartition(mbr, 1, 0x00, 0xEF, espStart, espBlocks)
+		}
+	}
+	mbr[510], mbr[511] = 0x55, 0xAA
+	return area
+}
+
+// createGPTBackup generates the trailing sectors holding the backup partition entries and header.
+func (b *ISOBuilder) createGPTBackup() []byte {
+	tail := make([]byte, b.gptBackupSectors()*SectorSize)
+	imageBlocks := uint64(b.totalSectors) * 4
+	entries := b.createGPTEntries()
+	header := createGPTHeader(imageBlocks-1, 1, imageBlocks-1-gptEntryArraySectors, imageBlocks, b.deriveGUID(1), entries)
+	copy(tail[len(tail)-mbrSectorSize-len(entries):], entries)
+	copy(tail[len(tail)-mbrSectorSize:], header)
+	return tail
+}
+
+// createGPTEntries generates the GPT partition entry array: the ISO data, then the appended ESP (if any).
+func (b *ISOBuilder) createGPTEntries() []byte {
+	entries := make([]byte, gptEntryArraySectors*mbrSectorSize)
+	isoEnd := uint64(b.totalSectors-b.gptBackupSectors())*4 - 1
+	if b.espSize > 0 {
+		isoEnd = uint64(b.lbaESP)*4 - 1
+	}
+	// the ISO partition starts with the volume descriptors, past the primary GPT
+	putGPTEntry(entries[0:gptEntrySize], gptTypeBasicData, b.deriveGUID(2), SystemAreaNumSectors*4, isoEnd, "ISO9660")
+	if b.espSize > 0 {
+		espStart := uint64(b.lbaESP) * 4
+		espEnd := espStart + uint64(sectorsToContainFileBytes(b.espSize))*4 - 1
+		putGPTEntry(entries[gptEntrySize:2*gptEntrySize], gptTypeESP, b.deriveGUID(3), espStart, espEnd, "EFI System Partition")
+	}
+	return entries
+}
+
+// createGPTHeader generates a GPT header block.
+// -> UEFI 2.x Section 5.3.2
+func createGPTHeader(myLBA, alternateLBA, entriesLBA, diskBlocks uint64, diskGUID [16]byte, entries []byte) []byte {
+	header := make([]byte, mbrSectorSize)
+	copy(header[0:8], "EFI PART")
+	binary.LittleEndian.PutUint32(header[8:12], 0x00010000) // revision 1.0
+	binary.LittleEndian.PutUint32(header[12:16], gptHeaderSize)
+	binary.LittleEndian.PutUint64(header[24:32], myLBA)
+	binary.LittleEndian.PutUint64(header[32:40], alternateLBA)
+	binary.LittleEndian.PutUint64(header[40:48], 2+gptEntryArraySectors)            // first usable LBA
+	binary.LittleEndian.PutUint64(header[48:56], diskBlocks-2-gptEntryArraySectors) // last usable LBA
+	copy(header[56:72], diskGUID[:])
+	binary.LittleEndian.PutUint64(header[72:80], entriesLBA)
+	binary.LittleEndian.PutUint32(header[80:84], gptEntryCount)
+	binary.LittleEndian.PutUint32(header[84:88], gptEntrySize)
+	binary.LittleEndian.PutUint32(header[88:92], crc32.ChecksumIEEE(entries))
+	binary.LittleEndian.PutUint32(header[16:20], crc32.ChecksumIEEE(header[:gptHeaderSize])) // computed with the field zeroed
+	return header
+}
+
+// putGPTEntry fills a GPT partition entry, firstLBA and lastLBA are inclusive 512-byte blocks.
+func putGPTEntry(entry []byte, typeGUID string, uniqueGUID [16]byte, firstLBA, lastLBA uint64, name string) {
+	typeBytes := parseGUID(typeGUID)
+	copy(entry[0:16], typeBytes[:])
+	copy(entry[16:32], uniqueGUID[:])
+	binary.LittleEndian.PutUint64(entry[32:40], firstLBA)
+	binary.LittleEndian.PutUint64(entry[40:48], lastLBA)
+	// bytes 48-55: attributes, none
+	for i, r := range utf16.Encode([]rune(name)) {
+		if 56+2*i+2 > gptEntrySize {
+			break
+		}
+		binary.LittleEndian.PutUint16(entry[56+2*i:], r)
+	}
+}
+
+// putMBRPartition fills MBR partition table slot n, start and size are in 512-byte blocks.
+func putMBRPartition(mbr []byte, n int, status, partitionType byte, start, size uint64) {
+	entry := mbr[mbrPartitionTableOffset+16*n : mbrPartitionTableOffset+16*(n+1)]
+	start = min(start, 0xFFFFFFFF)
+	size = min(size, 0xFFFFFFFF-start) // disks beyond 2 TiB are only fully described by a GPT
+	entry[0] = status
+	copy(entry[1:4], lbaToCHS(start))
+	entry[4] = partitionType
+	copy(entry[5:8], lbaToCHS(start+size-1))
+	binary.LittleEndian.PutUint32(entry[8:12], uint32(start))
+	binary.LittleEndian.PutUint32(entry[12:16], uint32(size))
+}
+
+// lbaToCHS converts a 512-byte block address to a CHS triple (255 heads, 63 sectors per track),
+// saturating to 1023/254/63 beyond the CHS range as partitioning tools do.
+func lbaToCHS(lba uint64) []byte {
+	const heads, sectors = 255, 63
+	cylinder := lba / (heads * sectors)
+	if cylinder > 1023 {
+		return []byte{0xFE, 0xFF, 0xFF}
+	}
+	head := (lba / sectors) % heads
+	sector := lba%sectors + 1
+	return []byte{byte(head), byte(sector) | byte((cylinder>>2)&0xC0), byte(cylinder)}
+}
+
+// parseGUID converts a textual GUID to its on-disk form (first three fields little-endian).
+func parseGUID(s string) [16]byte {
+	var g [16]byte
+	raw, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil || len(raw) != len(g) {
+		log.Panicf("InternalError: malformed GUID '%s'", s)
+	}
+	copy(g[:], raw)
+	// swap the little-endian fields
+	g[0], g[1], g[2], g[3] = g[3], g[2], g[1], g[0]
+	g[4], g[5] = g[5], g[4]
+	g[6], g[7] = g[7], g[6]
+	return g
+}
+
+// digestLayout hashes what sets an image apart for deriveGUID: the volume descriptors (label, size, build time,
+// root directories), the path tables, and the path, size and modification time of every entry.
+func (b *ISOBuilder) digestLayout() [sha256.Size]byte {
+	h := sha256.New()
+	for _, tree := range b.trees {
+		h.Write(b.createVolumeDescriptor(tree))
+		h.Write(tree.pathTableL)
+	}
+	for i := range b.fileEntries {
+		f := &b.fileEntries[i]
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00", f.isoPath, f.size, f.modTime.UnixNano())
+	}
+	var digest [sha256.Size]byte
+	h.Sum(digest[:0])
+	return digest
+}
+
+// deriveGUID returns a random-looking (version 4) GUID derived from the layout digest.
+// : the same source tree with the same Options.Timestamp always gives the same disk/partition GUIDs, keeping
+// builds reproducible, images differing in label, build time or tree get different ones.
+func (b *ISOBuilder) deriveGUID(n int) [16]byte {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("goiso9660/%x/%d", b.layoutDigest, n)))
+	var g [16]byte
+	copy(g[:], sum[:16])
+	g[7] = g[7]&0x0F | 0x40 // version 4 (byte 7 holds the high byte of the little-endian third field)
+	g[8] = g[8]&0x3F | 0x80 // RFC 4122 variant
+	return g
+}
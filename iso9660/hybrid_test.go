@@ -0,0 +1,207 @@
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeSparseFile creates a file of size bytes without writing its data.
+func writeSparseFile(t *testing.T, size int64) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "large.img")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := file.Truncate(size); err != nil {
+		t.Skipf("creating a sparse file of %d bytes: %v", size, err)
+	}
+	return path
+}
+
+// TestHybridRejectsLargeESP checks an appended EFI System Partition of 4 GiB is refused, not truncated.
+func TestHybridRejectsLargeESP(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Hybrid = &HybridOptions{GPT: true, EFIImage: writeSparseFile(t, 4<<30)}
+	_, err := NewBuilder(writeSourceTree(t, map[string]string{"a.txt": "a"}), "", opts).WriteTo(io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "4 GiB or more") {
+		t.Errorf("building with a 4 GiB ESP returned %v", err)
+	}
+}
+
+// mbrPartition is a decoded MBR partition table slot.
+type mbrPartition struct {
+	status, partitionType byte
+	start, size           uint32
+}
+
+// readMBRPartitions decodes the four partition table slots of an image's MBR, checking its signature.
+func readMBRPartitions(t *testing.T, image []byte) [4]mbrPartition {
+	t.Helper()
+	if image[510] != 0x55 || image[511] != 0xAA {
+		t.Fatalf("MBR signature %x", image[510:512])
+	}
+	var partitions [4]mbrPartition
+	for n := range partitions {
+		slot := image[mbrPartitionTableOffset+16*n:]
+		partitions[n] = mbrPartition{slot[0], slot[4], binary.LittleEndian.Uint32(slot[8:12]), binary.LittleEndian.Uint32(slot[12:16])}
+	}
+	return partitions
+}
+
+// readGPTHeader returns the GPT header at 512-byte block lba and its partition entries, checking both CRCs.
+func readGPTHeader(t *testing.T, image []byte, lba uint64) ([]byte, []byte) {
+	t.Helper()
+	header := append([]byte(nil), image[lba*mbrSectorSize:(lba+1)*mbrSectorSize]...)
+	if string(header[0:8]) != "EFI PART" {
+		t.Fatalf("no GPT header at block %d", lba)
+	}
+	crc := binary.LittleEndian.Uint32(header[16:20])
+	binary.LittleEndian.PutUint32(header[16:20], 0)
+	if got := crc32.ChecksumIEEE(header[:gptHeaderSize]); got != crc {
+		t.Errorf("GPT header at block %d: CRC %#x, computed %#x", lba, crc, got)
+	}
+	entriesLBA := binary.LittleEndian.Uint64(header[72:80])
+	entries := image[entriesLBA*mbrSectorSize : entriesLBA*mbrSectorSize+gptEntryCount*gptEntrySize]
+	if got := crc32.ChecksumIEEE(entries); got != binary.LittleEndian.Uint32(header[88:92]) {
+		t.Errorf("GPT header at block %d: entries CRC %#x, computed %#x", lba, binary.LittleEndian.Uint32(header[88:92]), got)
+	}
+	return header, entries
+}
+
+// writeESPImage writes a stand-in EFI System Partition image (its content is copied, never parsed).
+func writeESPImage(t *testing.T, size int) (string, []byte) {
+	t.Helper()
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i % 253)
+	}
+	path := filepath.Join(t.TempDir(), "esp.img")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path, data
+}
+
+// TestHybridMBR checks the MBR partitions of an image with an appended ESP, the boot code template and the
+// default boot image address patched after it.
+func TestHybridMBR(t *testing.T) {
+	espPath, esp := writeESPImage(t, 100*1024+1)
+	template := bytes.Repeat([]byte{0xEB}, mbrBootCodeSize)
+	templatePath := filepath.Join(t.TempDir(), "isohdpfx.bin")
+	if err := os.WriteFile(templatePath, template, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts := DefaultOptions()
+	opts.Hybrid = &HybridOptions{MBRTemplate: templatePath, EFIImage: espPath}
+	opts.BootEntries = []BootEntry{{Path: "boot.bin"}}
+	image := buildImage(t, writeSourceTree(t, map[string]string{"boot.bin": "boot", "a.txt": "a"}), opts)
+	boot, err := openImage(t, image).Primary().Lookup("BOOT.BIN")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(image[:mbrBootCodeSize], template) {
+		t.Error("MBR boot code differs from the template")
+	}
+	if lba := binary.LittleEndian.Uint64(image[432:440]); lba != uint64(boot.LBA)*4 {
+		t.Errorf("MBR boot image address %d, want %d", lba, uint64(boot.LBA)*4)
+	}
+	partitions := readMBRPartitions(t, image)
+	iso, espPart := partitions[0], partitions[1]
+	if iso.status != 0x80 || iso.partitionType != 0x17 || iso.start != 0 {
+		t.Errorf("ISO partition %+v", iso)
+	}
+	if espPart.partitionType != 0xEF || espPart.start != iso.size || espPart.size != uint32(sectorsToContainBytes(len(esp)))*4 {
+		t.Errorf("ESP partition %+v after an ISO partition of %d blocks", espPart, iso.size)
+	}
+	if end := int(espPart.start+espPart.size) * mbrSectorSize; end > len(image) || !bytes.Equal(image[int(espPart.start)*mbrSectorSize:][:len(esp)], esp) {
+		t.Error("ESP partition doesn't hold the EFI System Partition image")
+	}
+	if partitions[2] != (mbrPartition{}) || partitions[3] != (mbrPartition{}) {
+		t.Errorf("unused partition slots %+v", partitions[2:])
+	}
+}
+
+// TestHybridGPT checks the protective MBR, both GPT headers and their partition entries.
+func TestHybridGPT(t *testing.T) {
+	espPath, esp := writeESPImage(t, 64*1024)
+	opts := DefaultOptions()
+	opts.Hybrid = &HybridOptions{GPT: true, EFIImage: espPath}
+	image := buildImage(t, writeSourceTree(t, map[string]string{"a.txt": "a"}), opts)
+	blocks := uint64(len(image)) / mbrSectorSize
+
+	if protective := readMBRPartitions(t, image)[0]; protective.partitionType != 0xEE || protective.start != 1 || uint64(protective.size) != blocks-1 {
+		t.Errorf("protective MBR partition %+v for %d blocks", protective, blocks)
+	}
+	primary, entries := readGPTHeader(t, image, 1)
+	backup, backupEntries := readGPTHeader(t, image, blocks-1)
+	for _, tc := range []struct {
+		header                      []byte
+		my, alternate, entriesStart uint64
+	}{
+		{primary, 1, blocks - 1, 2},
+		{backup, blocks - 1, 1, blocks - 1 - gptEntryArraySectors},
+	} {
+		if my, alternate, start := binary.LittleEndian.Uint64(tc.header[24:32]), binary.LittleEndian.Uint64(tc.header[32:40]), binary.LittleEndian.Uint64(tc.header[72:80]); my != tc.my || alternate != tc.alternate || start != tc.entriesStart {
+			t.Errorf("GPT header at %d, alternate at %d, entries at %d; want %d, %d, %d", my, alternate, start, tc.my, tc.alternate, tc.entriesStart)
+		}
+	}
+	if !bytes.Equal(entries, backupEntries) || !bytes.Equal(primary[56:72], backup[56:72]) {
+		t.Error("backup GPT differs from the primary GPT")
+	}
+
+	espStart := binary.LittleEndian.Uint64(entries[gptEntrySize+32:])
+	for n, want := range []struct {
+		typeGUID    string
+		first, last uint64
+	}{
+		{gptTypeBasicData, SystemAreaNumSectors * 4, espStart - 1},
+		{gptTypeESP, espStart, espStart + uint64(sectorsToContainBytes(len(esp)))*4 - 1},
+	} {
+		entry := entries[n*gptEntrySize : (n+1)*gptEntrySize]
+		typeGUID := parseGUID(want.typeGUID)
+		first, last := binary.LittleEndian.Uint64(entry[32:40]), binary.LittleEndian.Uint64(entry[40:48])
+		if !bytes.Equal(entry[0:16], typeGUID[:]) || first != want.first || last != want.last {
+			t.Errorf("GPT entry %d: type %x, blocks %d-%d; want %s, %d-%d", n, entry[0:16], first, last, want.typeGUID, want.first, want.last)
+		}
+	}
+	if !bytes.Equal(image[espStart*mbrSectorSize:][:len(esp)], esp) {
+		t.Error("ESP partition doesn't hold the EFI System Partition image")
+	}
+	if bytes.Equal(entries[16:32], entries[gptEntrySize+16:gptEntrySize+32]) || bytes.Equal(entries[16:32], primary[56:72]) {
+		t.Error("partitions and disk share a GUID")
+	}
+}
+
+// TestHybridGUIDs checks the disk GUID is the same across rebuilds with one timestamp, and differs between
+// images of the same label and size built from different trees or at different times.
+func TestHybridGUIDs(t *testing.T) {
+	diskGUID := func(tree map[string]string, timestamp time.Time) string {
+		opts := DefaultOptions()
+		opts.Timestamp = timestamp
+		opts.Hybrid = &HybridOptions{GPT: true}
+		image := buildImage(t, writeSourceTree(t, tree), opts)
+		return fmt.Sprintf("%d/%x", len(image), image[mbrSectorSize+56:mbrSectorSize+72])
+	}
+	day := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	a := diskGUID(map[string]string{"a.txt": "a"}, day)
+	if again := diskGUID(map[string]string{"a.txt": "a"}, day); again != a {
+		t.Errorf("rebuilding gave disk GUID %s, then %s", a, again)
+	}
+	if other := diskGUID(map[string]string{"b.txt": "b"}, day); other == a {
+		t.Errorf("different trees of one size share the disk GUID %s", a)
+	}
+	if later := diskGUID(map[string]string{"a.txt": "a"}, day.Add(time.Hour)); later == a {
+		t.Errorf("builds at different times share the disk GUID %s", a)
+	}
+}
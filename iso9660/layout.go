@@ -7,20 +7,35 @@ import (
 
 // calculateLayout determines all sizes, LBA locations, and pre-generates path tables.
 func (b *ISOBuilder) calculateLayout() error {
+	if level := b.options.InterchangeLevel; level < 0 || level > 3 {
+		return fmt.Errorf("unsupported ISO 9660 interchange level %d (1, 2 or 3)", level)
+	}
+	b.resolveTrees()
 	if err := b.assignSanitizedNamesAndDrSizes(); err != nil {
 		return fmt.Errorf("assigning names/DR sizes: %w", err)
 	}
+	if err := b.assignDirectoryNumbers(); err != nil {
+		return fmt.Errorf("numbering directories: %w", err)
+	}
+	if err := b.resolveBootImages(); err != nil {
+		return fmt.Errorf("resolving El Torito boot images: %w", err)
+	}
+	if err := b.resolveHybrid(); err != nil {
+		return fmt.Errorf("resolving hybrid system area: %w", err)
+	}
 	if err := b.calculateAllDirectoryExtentSizes(); err != nil {
 		return fmt.Errorf("calculating dir extent sizes: %w", err)
 	}
 
-	currentLBA := uint32(SystemAreaNumSectors + 3) // VD area (PVD, SVD, Terminator)
+	currentLBA := uint32(SystemAreaNumSectors + b.numVolumeDescriptors()) // VD area (PVD, Boot Record, SVDs, Terminator)
 	currentLBA = b.determinePathTableLBAs(currentLBA)
 	currentLBA = b.assignContentLBAs(currentLBA)
+	currentLBA = b.assignHybridLBAs(currentLBA)
 
 	b.totalSectors = currentLBA // LBA after the last sector used by content
 	b.totalSectors++            // add one trailing [padding] sector for compatibility
 	// was getting a major headache because of this!!
+	b.totalSectors += b.gptBackupSectors() // the backup GPT must end the image
 
 	if err := b.pregeneratePathTables(); err != nil {
 		return fmt.Errorf("pre-generating path tables: %w", err)
@@ -28,78 +43,112 @@ func (b *ISOBuilder) calculateLayout() error {
 	return nil
 }
 
-// assignSanitizedNamesAndDrSizes prepares ISO9660/Joliet names and calculates DR sizes for all entries.
+// numVolumeDescriptors returns the number of sectors of the volume descriptor set:
+// one descriptor per directory tree, the Boot Record (if bootable) and the Terminator.
+func (b *ISOBuilder) numVolumeDescriptors() int {
+	count := len(b.trees) + 1
+	if b.isBootable() {
+		count++
+	}
+	return count
+}
+
+// assignSanitizedNamesAndDrSizes prepares the names of every tree, resolves name collisions within
+// each directory and calculates DR sizes for all entries.
 func (b *ISOBuilder) assignSanitizedNamesAndDrSizes() error {
+	rules := b.iso9660NameRules()
 	for i := range b.fileEntries {
 		f := &b.fileEntries[i]
-		isRootEntry := (f.pathTableDirNum == 1)
+		isRootEntry := f.isRoot
+		iso, joliet, enhanced := &f.nodes[treeISO9660], &f.nodes[treeJoliet], &f.nodes[treeEnhanced]
 		if f.isDir {
 			if isRootEntry {
-				f.iso9660Name = ""    // ISO9660 Root DR identifier is 0x00 (represented as empty string for DR logic)
-				f.jolietName = "\x00" // Joliet Root DR identifier is 0x00
+				iso.name = ""        // ISO9660 Root DR identifier is 0x00 (represented as empty string for DR logic)
+				joliet.name = "\x00" // Joliet Root DR identifier is 0x00
+				enhanced.name = ""   // ^ same as ISO9660
 			} else {
-				f.iso9660Name = sanitizeISO9660Name(f.originalName, true)
-				f.jolietName = truncateJolietName(f.originalName)
+				iso.name = sanitizeISO9660Name(f.originalName, true, rules)
+				joliet.name = truncateJolietName(f.originalName)
+				enhanced.name = truncateEnhancedName(f.originalName)
 			}
+		} else if f.relocatedDirIndex != 0 {
+			iso.name = sanitizeISO9660Name(f.originalName, true, rules) // placeholder keeps the directory's name
 		} else {
-			f.iso9660Name = sanitizeISO9660Name(f.originalName, false) + ";1" // files get vers. #
-			f.jolietName = truncateJolietName(f.originalName)
+			iso.name = sanitizeISO9660Name(f.originalName, false, rules) + b.iso9660FileVersion() // files get vers. #
+			joliet.name = truncateJolietName(f.originalName)
+			enhanced.name = truncateEnhancedName(f.originalName) // no version numbers in ISO 9660:1999
+		}
+	}
+	b.resolveNameCollisions(rules)
+
+	for i := range b.fileEntries {
+		f := &b.fileEntries[i]
+		isRootEntry := f.isRoot
+		for kind := range f.nodes {
+			// Rock Ridge fields only live in the parent's listing, never in the PVD root DR
+			systemUseLen := 0
+			if treeKind(kind) == treeISO9660 && !isRootEntry {
+				systemUseLen = b.rockRidgeInlineSize(rrRecordKey{dirIndex: f.isoParentIndex, entryIndex: i, role: rrRoleChild})
+			}
+			// Calculate actual DR size for use in parent directory listings
+			f.nodes[kind].drSize = calculateDirectoryRecordSize(getDRIdentifierBytes(f.nodes[kind].name, treeKind(kind), isRootEntry), systemUseLen)
 		}
-		// Calculate actual DR size for use in parent directory listings
-		f.actualISO9660DrSize = calculateDirectoryRecordSize(getDRIdentifierBytes(f.iso9660Name, false, isRootEntry))
-		f.actualJolietDrSize = calculateDirectoryRecordSize(getDRIdentifierBytes(f.jolietName, true, isRootEntry))
 	}
 	return nil
 }
 
-// calculateAllDirectoryExtentSizes computes the on-disk size for each directory's listing.
+// calculateAllDirectoryExtentSizes computes the on-disk size for each directory's listing in every tree.
 func (b *ISOBuilder) calculateAllDirectoryExtentSizes() error {
-	for i := range b.fileEntries {
-		if b.fileEntries[i].isDir {
-			b.fileEntries[i].iso9660Size = b.calculateSingleDirectoryExtentSizeBytes(i, false)
-			b.fileEntries[i].jolietSize = b.calculateSingleDirectoryExtentSizeBytes(i, true)
+	for _, tree := range b.trees {
+		for i := range b.fileEntries {
+			if b.fileEntries[i].isDir && b.fileEntries[i].inTree(tree.kind) {
+				b.fileEntries[i].nodes[tree.kind].size = b.calculateSingleDirectoryExtentSizeBytes(i, tree.kind)
+			}
 		}
 	}
-	// root directory extent sizes for PVD/SVD direct reference
-	b.pvdRootDirExtentSize = b.fileEntries[0].iso9660Size
-	b.svdRootDirExtentSize = b.fileEntries[0].jolietSize
 	return nil
 }
 
 // calculateSingleDirectoryExtentSizeBytes calculates the total byte size of a directory's listing,
 // rounded up to the nearest sector.
 // : size is used for the DataLength field of the directory's DR.
-func (b *ISOBuilder) calculateSingleDirectoryExtentSizeBytes(dirEntryIndex int, isJoliet bool) uint32 {
+func (b *ISOBuilder) calculateSingleDirectoryExtentSizeBytes(dirEntryIndex int, kind treeKind) uint32 {
 	dirEntry := b.fileEntries[dirEntryIndex]
-	isDirEntryRoot := (dirEntry.pathTableDirNum == 1)
+	isDirEntryRoot := dirEntry.isRoot
+	parentIndex, _ := b.treeLinks(dirEntryIndex, kind) // the ISO9660 tree follows relocations
 
 	// every directory listing must contain "." (self) and ".." (parent) entries.
-	dotIdentBytes := getDRIdentifierBytes(".", isJoliet, isDirEntryRoot)
-	dotDRSize := calculateDirectoryRecordSize(dotIdentBytes)
+	// in the ISO9660 tree both may carry Rock Ridge fields.
+	var dotSystemUseLen, dotDotSystemUseLen int
+	if kind == treeISO9660 {
+		dotSystemUseLen = b.rockRidgeInlineSize(rrRecordKey{dirIndex: dirEntryIndex, entryIndex: dirEntryIndex, role: rrRoleSelf})
+		dotDotSystemUseLen = b.rockRidgeInlineSize(rrRecordKey{dirIndex: dirEntryIndex, entryIndex: parentIndex, role: rrRoleParent})
+	}
+	dotIdentBytes := getDRIdentifierBytes(".", kind, isDirEntryRoot)
+	dotDRSize := calculateDirectoryRecordSize(dotIdentBytes, dotSystemUseLen)
 
-	dotDotIdentBytes := getDRIdentifierBytes("..", isJoliet, false)
-	dotDotDRSize := calculateDirectoryRecordSize(dotDotIdentBytes)
+	dotDotIdentBytes := getDRIdentifierBytes("..", kind, false)
+	dotDotDRSize := calculateDirectoryRecordSize(dotDotIdentBytes, dotDotSystemUseLen)
 
+	// records are packed as createDirectoryListing writes them, in the same order, none crossing a sector boundary
 	totalDRBytes := dotDRSize + dotDotDRSize
-	for _, childIndex := range dirEntry.children {
+	for _, childIndex := range b.sortedChildren(dirEntryIndex, kind) {
 		child := b.fileEntries[childIndex]
-		childDrSize := child.actualISO9660DrSize
-		if isJoliet {
-			childDrSize = child.actualJolietDrSize
+		for range child.extents() { // multi-extent files repeat their DR per extent, Rock Ridge fields included
+			totalDRBytes = nextDirectoryRecordOffset(totalDRBytes, child.nodes[kind].drSize) + child.nodes[kind].drSize
 		}
-		totalDRBytes += childDrSize
 	}
 
 	if totalDRBytes == 0 {
 		// sanity check
-		log.Panicf("CriticalError: Dir='%s'(j:%t) totalDRBytes calculated as ZERO. DotDRSize=%d, DotDotDRSize=%d", dirEntry.isoPath, isJoliet, dotDRSize, dotDotDRSize)
+		log.Panicf("CriticalError: Dir='%s'(%s) totalDRBytes calculated as ZERO. DotDRSize=%d, DotDotDRSize=%d", dirEntry.isoPath, kind, dotDRSize, dotDotDRSize)
 	}
 
 	// round up the total DR bytes to the nearest sector size for the extent.
 	numSectors := (uint32(totalDRBytes) + SectorSize - 1) / SectorSize
 	finalExtentSizeBytes := numSectors * SectorSize
 	if finalExtentSizeBytes == 0 {
-		log.Panicf("CriticalError: Dir='%s'(j:%t) finalExtentSizeBytes calculated as ZERO (totalDRBytes=%d, numSectors=%d)", dirEntry.isoPath, isJoliet, totalDRBytes, numSectors)
+		log.Panicf("CriticalError: Dir='%s'(%s) finalExtentSizeBytes calculated as ZERO (totalDRBytes=%d, numSectors=%d)", dirEntry.isoPath, kind, totalDRBytes, numSectors)
 	}
 	return finalExtentSizeBytes
 }
@@ -114,97 +163,122 @@ func assignPathTableSetLBAs(startLBA uint32, numSectorsL, numSectorsM uint32) (l
 }
 
 // determinePathTableLBAs calculates and assigns LBAs for all path tables.
+// : the L/M pairs of every tree, in volume descriptor order, then the second copies in the same order.
 func (b *ISOBuilder) determinePathTableLBAs(startLBA uint32) uint32 {
 	currentLBA := startLBA
-	// Calculate path table sizes (unpadded byte length first)
-	pvdPtLBytes := b.calculatePathTableTotalBytes(false) // PVD L-Type
-	svdPtLBytes := b.calculatePathTableTotalBytes(true)  // SVD L-Type
-
-	// # of sectors needed for each path table type (L and M are typically same size)
-	numSecPvdL := sectorsToContainBytes(pvdPtLBytes)
-	numSecPvdM := numSecPvdL // M-type table typically same size as L-type for same standard
-	numSecSvdL := sectorsToContainBytes(svdPtLBytes)
-	numSecSvdM := numSecSvdL
-
-	// LBAs for PVD primary and optional path tables
-	b.lbaPvdPathTableL, b.lbaPvdPathTableM, currentLBA = assignPathTableSetLBAs(currentLBA, numSecPvdL, numSecPvdM)
-	// LBAs for SVD primary and optional path tables
-	b.lbaSvdPathTableL, b.lbaSvdPathTableM, currentLBA = assignPathTableSetLBAs(currentLBA, numSecSvdL, numSecSvdM)
+	// LBAs for primary path tables
+	for _, tree := range b.trees {
+		// # of sectors needed for each path table type (L and M are the same size)
+		numSectors := sectorsToContainBytes(b.calculatePathTableTotalBytes(tree.kind))
+		tree.lbaPathTableL, tree.lbaPathTableM, currentLBA = assignPathTableSetLBAs(currentLBA, numSectors, numSectors)
+	}
 	// LBAs for second copies (optional tables)
-	b.lbaPvdPathTableL2, b.lbaPvdPathTableM2, currentLBA = assignPathTableSetLBAs(currentLBA, numSecPvdL, numSecPvdM)
-	b.lbaSvdPathTableL2, b.lbaSvdPathTableM2, currentLBA = assignPathTableSetLBAs(currentLBA, numSecSvdL, numSecSvdM)
-
+	for _, tree := range b.trees {
+		numSectors := sectorsToContainBytes(b.calculatePathTableTotalBytes(tree.kind))
+		tree.lbaPathTableL2, tree.lbaPathTableM2, currentLBA = assignPathTableSetLBAs(currentLBA, numSectors, numSectors)
+	}
 	return currentLBA
 }
 
 // assignContentLBAs assigns LBAs to all directory extents and file data extents.
 func (b *ISOBuilder) assignContentLBAs(startLBA uint32) uint32 {
 	currentLBA := startLBA
-	// ISO9660 Directory Extents -> then File Data -> then Joliet Directory Extents
+	// ISO9660 Directory Extents -> Rock Ridge continuation areas -> Boot Catalog -> then File Data -> then Joliet (and Enhanced) Directory Extents
 
-	// ISO9660 Directory Extents
+	// ISO9660 Directory Extents, shallow directories first
+	for _, i := range b.iso9660DirectoryExtentOrder() {
+		currentLBA = b.assignDirectoryExtentLBA(i, treeISO9660, currentLBA)
+	}
+	// relocation placeholders point at their directory's extent, with a data length of 0
 	for i := range b.fileEntries {
-		if b.fileEntries[i].isDir {
-			f := &b.fileEntries[i]
-			f.iso9660Sector = currentLBA
-			if f.iso9660Size == 0 {
-				log.Panicf("InternalError: Dir '%s' iso9660Size is 0 before LBA assignment", f.isoPath)
+		if target := b.fileEntries[i].relocatedDirIndex; target != 0 {
+			b.fileEntries[i].nodes[treeISO9660].sector = b.fileEntries[target].nodes[treeISO9660].sector
+		}
+	}
+	// Rock Ridge continuation areas (System Use fields that overflow their DR)
+	currentLBA = b.assignRockRidgeContinuationLBAs(currentLBA)
+
+	// El Torito boot catalog, with the boot images pinned right behind it
+	pinned := make(map[int]bool) // boot images of the source tree, already placed
+	if b.isBootable() {
+		b.lbaBootCatalog = currentLBA
+		currentLBA += sectorsToContainBytes(b.bootCatalogSize())
+		for n := range b.bootImages {
+			img := &b.bootImages[n]
+			if img.fileIndex < 0 { // external image, only referenced by the catalog
+				if b.isAppendedESP(img.diskPath) {
+					continue // lives in the appended EFI System Partition, see assignHybridLBAs
+				}
+				img.sector = currentLBA
+				currentLBA += sectorsToContainFileBytes(img.size)
+				continue
 			}
-			if f.iso9660Size%SectorSize != 0 {
-				log.Panicf("InternalError: Dir '%s' iso9660Size %d not multiple of SectorSize", f.isoPath, f.iso9660Size)
+			f := &b.fileEntries[img.fileIndex]
+			if !pinned[img.fileIndex] { // several entries may share an image
+				f.setDataSector(currentLBA)
+				currentLBA += f.dataSectors()
+				pinned[img.fileIndex] = true
 			}
-			numSectors := f.iso9660Size / SectorSize
-			currentLBA += numSectors
+			img.sector = f.dataSector()
 		}
 	}
-	// File Data Extents (shared between ISO9660 and Joliet)
+
+	// File Data Extents (shared between all trees)
 	for i := range b.fileEntries {
-		if !b.fileEntries[i].isDir {
+		if pinned[i] {
+			continue
+		}
+		if b.fileEntries[i].hasDataExtent() { // symlinks and special nodes have no data extent (LBA 0)
 			f := &b.fileEntries[i]
-			f.iso9660Sector = currentLBA // file data LBA
-			f.jolietSector = currentLBA  // Joliet DRs point to the same file data LBA
-			numSectors := sectorsToContainFileBytes(f.iso9660Size)
-			currentLBA += numSectors
+			f.setDataSector(currentLBA) // DRs of every tree point to the same file data LBA
+			// multi-extent files are recorded contiguously
+			currentLBA += f.dataSectors()
 		}
 	}
-	// Joliet Directory Extents
-	for i := range b.fileEntries {
-		if b.fileEntries[i].isDir {
-			f := &b.fileEntries[i]
-			f.jolietSector = currentLBA
-			if f.jolietSize == 0 {
-				log.Panicf("InternalError: Dir '%s' jolietSize is 0 before LBA assignment", f.isoPath)
-			}
-			if f.jolietSize%SectorSize != 0 {
-				log.Panicf("InternalError: Dir '%s' jolietSize %d not multiple of SectorSize", f.isoPath, f.jolietSize)
+	// Joliet (and Enhanced) Directory Extents
+	for _, tree := range b.trees {
+		if tree.kind == treeISO9660 {
+			continue
+		}
+		for i := range b.fileEntries {
+			if b.fileEntries[i].isDir && b.fileEntries[i].inTree(tree.kind) {
+				currentLBA = b.assignDirectoryExtentLBA(i, tree.kind, currentLBA)
 			}
-			numSectors := f.jolietSize / SectorSize
-			currentLBA += numSectors
 		}
 	}
 	return currentLBA
 }
 
+// assignDirectoryExtentLBA places the listing extent of directory i in the given tree at lba
+// and returns the LBA following it.
+func (b *ISOBuilder) assignDirectoryExtentLBA(i int, kind treeKind, lba uint32) uint32 {
+	f := &b.fileEntries[i]
+	node := &f.nodes[kind]
+	node.sector = lba
+	if node.size == 0 {
+		log.Panicf("InternalError: Dir '%s' %s extent size is 0 before LBA assignment", f.isoPath, kind)
+	}
+	if node.size%SectorSize != 0 {
+		log.Panicf("InternalError: Dir '%s' %s extent size %d not multiple of SectorSize", f.isoPath, kind, node.size)
+	}
+	return lba + node.size/SectorSize
+}
+
 // pregeneratePathTables creates the byte data for all path tables.
 func (b *ISOBuilder) pregeneratePathTables() error {
-	b.pvdPathTableLData = b.createPathTable(false, false) // PVD, L-Type
-	b.pvdPathTableMData = b.createPathTable(false, true)  // PVD, M-Type
-	b.svdPathTableLData = b.createPathTable(true, false)  // SVD, L-Type
-	b.svdPathTableMData = b.createPathTable(true, true)   // SVD, M-Type
+	for _, tree := range b.trees {
+		tree.pathTableL = b.createPathTable(tree.kind, false) // L-Type
+		tree.pathTableM = b.createPathTable(tree.kind, true)  // M-Type
 
-	// sanity check generated path table sizes against calculated byte lengths
-	if len(b.pvdPathTableLData) != b.calculatePathTableTotalBytes(false) {
-		return fmt.Errorf("PVD L-Path Table generated length %d != calculated %d", len(b.pvdPathTableLData), b.calculatePathTableTotalBytes(false))
-	}
-	if len(b.svdPathTableLData) != b.calculatePathTableTotalBytes(true) {
-		return fmt.Errorf("SVD L-Path Table generated length %d != calculated %d", len(b.svdPathTableLData), b.calculatePathTableTotalBytes(true))
-	}
-	// M-Type tables are often the same length as L-Type for the same standard
-	if len(b.pvdPathTableMData) != b.calculatePathTableTotalBytes(false) {
-		return fmt.Errorf("PVD M-Path Table generated length %d != calculated (L-type for PVD) %d", len(b.pvdPathTableMData), b.calculatePathTableTotalBytes(false))
-	}
-	if len(b.svdPathTableMData) != b.calculatePathTableTotalBytes(true) {
-		return fmt.Errorf("SVD M-Path Table generated length %d != calculated (L-type for SVD) %d", len(b.svdPathTableMData), b.calculatePathTableTotalBytes(true))
+		// sanity check generated path table sizes against calculated byte lengths
+		expected := b.calculatePathTableTotalBytes(tree.kind)
+		if len(tree.pathTableL) != expected {
+			return fmt.Errorf("%s L-Path Table generated length %d != calculated %d", tree.kind, len(tree.pathTableL), expected)
+		}
+		// M-Type tables hold the same records as L-Type
+		if len(tree.pathTableM) != expected {
+			return fmt.Errorf("%s M-Path Table generated length %d != calculated (L-type) %d", tree.kind, len(tree.pathTableM), expected)
+		}
 	}
 	return nil
 }
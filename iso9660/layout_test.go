@@ -0,0 +1,95 @@
+package iso9660
+
+import (
+	"math"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestDirectoryPacking builds directories of random names whose sorted order in a tree differs from the
+// scan order (lowercase and punctuation sanitize to uppercase and "_"), with enough records to span
+// several sectors, and checks every listing is allocated the size it is written with.
+func TestDirectoryPacking(t *testing.T) {
+	const alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.~ é"
+	for seed := int64(1); seed <= 40; seed++ {
+		rng := rand.New(rand.NewSource(seed))
+		tree := make(map[string]string)
+		numFiles := 20 + rng.Intn(200)
+		for len(tree) < numFiles {
+			name := make([]rune, 1+rng.Intn(60))
+			for k := range name {
+				name[k] = []rune(alphabet)[rng.Intn(len([]rune(alphabet)))]
+			}
+			if s := strings.Trim(string(name), ". "); s != "" {
+				tree["dir/"+s] = s
+			}
+		}
+
+		for _, opts := range []*Options{DefaultOptions(), rockRidgeOptions()} {
+			image := buildImage(t, writeSourceTree(t, tree), opts)
+			img := openImage(t, image)
+			for vol, dirName := range map[*Volume]string{img.Primary(): "DIR", img.Joliet(): "dir"} {
+				dir, err := vol.Lookup(dirName)
+				if err != nil {
+					t.Fatalf("seed %d: %v", seed, err)
+				}
+				if dir.Size%SectorSize != 0 {
+					t.Errorf("seed %d: directory size %d is not a multiple of the sector size", seed, dir.Size)
+				}
+				entries, err := vol.ReadDir(dir)
+				if err != nil {
+					t.Fatalf("seed %d: %v", seed, err)
+				}
+				if len(entries) != len(tree) {
+					t.Errorf("seed %d: listing holds %d entries, want %d", seed, len(entries), len(tree))
+				}
+			}
+		}
+	}
+}
+
+// TestNextDirectoryRecordOffset checks records move to the next sector rather than cross a boundary.
+func TestNextDirectoryRecordOffset(t *testing.T) {
+	for _, tc := range []struct{ offset, size, want int }{
+		{0, 34, 0},
+		{2000, 48, 2000},
+		{2000, 49, SectorSize},
+		{SectorSize - 34, 34, SectorSize - 34},
+		{SectorSize + 2040, 10, 2 * SectorSize},
+	} {
+		if got := nextDirectoryRecordOffset(tc.offset, tc.size); got != tc.want {
+			t.Errorf("nextDirectoryRecordOffset(%d, %d) = %d, want %d", tc.offset, tc.size, got, tc.want)
+		}
+	}
+}
+
+// rockRidgeOptions returns the default options with Rock Ridge on.
+func rockRidgeOptions() *Options {
+	opts := DefaultOptions()
+	opts.RockRidge = true
+	return opts
+}
+
+func TestFileEntryExtents(t *testing.T) {
+	const max32 = math.MaxUint32
+	tests := []struct {
+		size int64
+		want []uint32
+	}{
+		{0, []uint32{0}},
+		{1, []uint32{1}},
+		{maxExtentSize, []uint32{maxExtentSize}},
+		{max32, []uint32{max32}}, // the last extent needn't end on a sector boundary
+		{max32 + 1, []uint32{maxExtentSize, max32 + 1 - maxExtentSize}},
+		{2 * maxExtentSize, []uint32{maxExtentSize, maxExtentSize}},
+		{2*maxExtentSize + 5, []uint32{maxExtentSize, maxExtentSize, 5}},
+	}
+	for _, tc := range tests {
+		f := fileEntry{size: tc.size}
+		if got := f.extents(); !slices.Equal(got, tc.want) {
+			t.Errorf("extents of %d bytes = %v, want %v", tc.size, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package iso9660
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+)
+
+// NameMapping records the identifiers a source entry was given in each directory tree of the image.
+type NameMapping struct {
+	Path     string // path relative to the source directory (e.g., "/docs/report-2023.txt")
+	ISO9660  string // identifier in the ISO9660 tree, version number included (e.g., "REPORT_2.TXT;1")
+	Joliet   string // identifier in the Joliet tree
+	Enhanced string // identifier in the ISO 9660:1999 tree, "" unless Options.EnhancedVolumeDescriptor is set
+	Renamed  bool   // a name collision was resolved with a numeric suffix in at least one tree
+}
+
+// NameMappings returns the original -> recorded name table of every entry below the root, in scan order.
+// : the table is filled in by Build (layout phase), it is empty before.
+// : relocated directories report the name of the placeholder left in their original parent.
+func (b *ISOBuilder) NameMappings() []NameMapping {
+	if len(b.trees) == 0 {
+		return nil
+	}
+	placeholders := make(map[int]int) // relocated directory -> its placeholder
+	for i := range b.fileEntries {
+		if b.fileEntries[i].relocatedDirIndex != 0 {
+			placeholders[b.fileEntries[i].relocatedDirIndex] = i
+		}
+	}
+
+	var mappings []NameMapping
+	for i := 1; i < len(b.fileEntries); i++ { // skip the root
+		f := &b.fileEntries[i]
+		if f.isoOnly {
+			continue // RR_MOVED and placeholders are not source entries
+		}
+		iso := f.nodes[treeISO9660]
+		if p, ok := placeholders[i]; ok {
+			iso = b.fileEntries[p].nodes[treeISO9660]
+		}
+		mapping := NameMapping{
+			Path:    f.isoPath,
+			ISO9660: iso.name,
+			Joliet:  f.nodes[treeJoliet].name,
+			Renamed: iso.renamed || f.nodes[treeJoliet].renamed,
+		}
+		if b.hasTree(treeEnhanced) {
+			mapping.Enhanced = f.nodes[treeEnhanced].name
+			mapping.Renamed = mapping.Renamed || f.nodes[treeEnhanced].renamed
+		}
+		mappings = append(mappings, mapping)
+	}
+	return mappings
+}
+
+// resolveNameCollisions makes the names of the children of every directory unique, in each tree independently.
+// : distinct source names may sanitize (ISO9660) or truncate (Joliet, ISO 9660:1999) to the same identifier,
+// the first entry in scan order keeps it and the next ones get a numeric suffix ("REPORT_1.TXT;1", "name~1.txt")
+// within the tree's length limits, so the same source tree always gives the same names.
+// : a single warning counts the renamed names, NameMappings lists them.
+func (b *ISOBuilder) resolveNameCollisions(rules iso9660NameRules) {
+	renamed := 0
+	for _, tree := range b.trees {
+		for i := range b.fileEntries {
+			if b.fileEntries[i].isDir && b.fileEntries[i].inTree(tree.kind) {
+				renamed += b.resolveDirectoryNameCollisions(i, tree.kind, rules)
+			}
+		}
+	}
+	if renamed > 0 {
+		log.Printf("Warning: %d names already used in their directory got a numeric suffix (see ISOBuilder.NameMappings)", renamed)
+	}
+}
+
+// resolveDirectoryNameCollisions renames the children of one directory listing that repeat an earlier child's name,
+// and returns the number of renamed children.
+func (b *ISOBuilder) resolveDirectoryNameCollisions(dirIndex int, kind treeKind, rules iso9660NameRules) int {
+	_, children := b.treeLinks(dirIndex, kind)
+	taken := make(map[string]bool, len(children)) // every name of the listing, a suffixed name must not repeat any
+	for _, childIndex := range children {
+		taken[collisionKey(b.fileEntries[childIndex].nodes[kind].name, kind)] = true
+	}
+
+	seen := make(map[string]bool, len(children))
+	renamed := 0
+	for _, childIndex := range children {
+		child := &b.fileEntries[childIndex]
+		node := &child.nodes[kind]
+		key := collisionKey(node.name, kind)
+		if !seen[key] {
+			seen[key] = true
+			continue
+		}
+		for n := 1; ; n++ {
+			candidate := mangleName(node.name, child.isDir || child.relocatedDirIndex != 0, kind, rules, n)
+			if candidateKey := collisionKey(candidate, kind); !taken[candidateKey] {
+				taken[candidateKey], seen[candidateKey] = true, true
+				node.name = candidate
+				break
+			}
+		}
+		node.renamed = true
+		renamed++
+	}
+	return renamed
+}
+
+// collisionKey returns the part of an identifier two entries of one directory must not share.
+// : ISO9660 readers drop the version number, "FOO" (directory) and "FOO;1" (file) would be the same name.
+func collisionKey(name string, kind treeKind) string {
+	if kind == treeISO9660 {
+		name, _, _ = strings.Cut(name, ";")
+	}
+	return name
+}
+
+// mangleName returns name with the n-th collision suffix inserted before the extension, shortened to
+// the tree's limits: "_n" in the ISO9660 tree (d-characters only), "~n" in the others.
+func mangleName(name string, isDirectory bool, kind treeKind, rules iso9660NameRules, n int) string {
+	version := ""
+	if kind == treeISO9660 {
+		if semicolon := strings.IndexByte(name, ';'); semicolon != -1 {
+			name, version = name[:semicolon], name[semicolon:]
+		}
+	}
+	base, ext := name, ""
+	if !isDirectory {
+		if lastDot := strings.LastIndexByte(name, '.'); lastDot > 0 { // a leading dot is not a separator
+			base, ext = name[:lastDot], name[lastDot+1:]
+		}
+	}
+
+	var suffix string
+	var fits func(base, ext string) bool
+	switch kind {
+	case treeISO9660:
+		suffix = fmt.Sprintf("_%d", n)
+		fits = func(base, ext string) bool { return rules.fits(base, ext, isDirectory) }
+	case treeJoliet:
+		suffix = fmt.Sprintf("~%d", n)
+		fits = func(base, ext string) bool {
+			return utf8.RuneCountInString(joinNameAndExtension(base, ext)) <= JolietMaxFilenameChars
+		}
+	default:
+		suffix = fmt.Sprintf("~%d", n)
+		fits = func(base, ext string) bool { return len(joinNameAndExtension(base, ext)) <= EnhancedMaxNameBytes }
+	}
+
+	// shorten the name, then the extension, until the suffix fits
+	for !fits(base+suffix, ext) && (base != "" || ext != "") {
+		if base != "" {
+			base = trimLastRune(base)
+		} else {
+			ext = trimLastRune(ext)
+		}
+	}
+	return joinNameAndExtension(base+suffix, ext) + version
+}
+
+// fits reports whether a name and extension (without the separator) are within the identifier limits.
+func (rules iso9660NameRules) fits(base, ext string, isDirectory bool) bool {
+	switch {
+	case isDirectory:
+		return len(base) <= rules.maxDirLen
+	case rules.maxExtLen > 0:
+		return len(base) <= rules.maxBaseLen && len(ext) <= rules.maxExtLen
+	}
+	maxLen := rules.maxFileLen
+	if rules.maxIdentifierLen > 0 {
+		maxLen = rules.maxIdentifierLen
+		if ext != "" {
+			maxLen-- // the separator counts towards the identifier
+		}
+	}
+	return len(base)+len(ext) <= maxLen
+}
+
+// joinNameAndExtension puts a name and its extension back together.
+func joinNameAndExtension(base, ext string) string {
+	if ext == "" {
+		return base
+	}
+	return base + "." + ext
+}
+
+// trimLastRune removes the last character of s, without splitting a UTF-8 sequence.
+func trimLastRune(s string) string {
+	_, size := utf8.DecodeLastRuneInString(s)
+	return s[:len(s)-size]
+}
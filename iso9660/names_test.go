@@ -0,0 +1,115 @@
+package iso9660
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"strings"
+	"testing"
+)
+
+// TestNameCollisions checks names sanitizing to the same ISO9660 identifier are made unique with a suffix,
+// listed by NameMappings and summed up in a single warning.
+func TestNameCollisions(t *testing.T) {
+	tree := make(map[string]string)
+	for n := 0; n < 50; n++ {
+		suffix := string(rune('a'+n%26)) + strings.Repeat("x", n/26)
+		tree["dir/report-"+suffix+".txt"] = "" // both REPORT_A.TXT;1, ...
+		tree["dir/report+"+suffix+".txt"] = ""
+	}
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(io.Discard)
+
+	b := NewBuilder(writeSourceTree(t, tree), "", nil)
+	if _, err := b.WriteTo(io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]string)
+	renamed := 0
+	for _, m := range b.NameMappings() {
+		if !strings.HasPrefix(m.Path, "/dir/") {
+			continue
+		}
+		if other, ok := names[m.ISO9660]; ok {
+			t.Errorf("'%s' and '%s' both recorded as '%s'", other, m.Path, m.ISO9660)
+		}
+		names[m.ISO9660] = m.Path
+		if m.Renamed {
+			renamed++
+		}
+	}
+	if len(names) != len(tree) || renamed == 0 {
+		t.Errorf("%d unique names for %d files, %d renamed", len(names), len(tree), renamed)
+	}
+	if lines := strings.Count(logged.String(), "\n"); lines != 1 {
+		t.Errorf("%d lines logged, want a single summary:\n%s", lines, logged.String())
+	}
+}
+
+// TestSanitizeISO9660Name checks the identifiers of files and directories under each interchange level
+// and naming relaxation.
+func TestSanitizeISO9660Name(t *testing.T) {
+	type want struct{ file, dir string }
+	tests := []struct {
+		name  string
+		setup func(*Options)
+		names map[string]want
+	}{
+		{"level 1", func(*Options) {}, map[string]want{
+			"README.TXT":            {"README.TXT", "README_T"},
+			".bashrc":               {"_BASHRC", "_BASHRC"}, // the leading dot is not the extension separator
+			"file.name.txt":         {"FILE_NAM.TXT", "FILE_NAM"},
+			"archive.tar.gz":        {"ARCHIVE_.GZ", "ARCHIVE_"},
+			"verylongfilename.html": {"VERYLONG.HTM", "VERYLONG"},
+			"a b.c":                 {"A_B.C", "A_B_C"},
+			"x.":                    {"X", "X_"},
+			"über.txt":              {"_BER.TXT", "_BER_TXT"},
+			"Makefile":              {"MAKEFILE", "MAKEFILE"},
+		}},
+		{"level 2", func(o *Options) { o.InterchangeLevel = 2 }, map[string]want{
+			".bashrc":               {"_BASHRC", "_BASHRC"},
+			"file.name.txt":         {"FILE_NAME.TXT", "FILE_NAME_TXT"},
+			"verylongfilename.html": {"VERYLONGFILENAME.HTML", "VERYLONGFILENAME_HTML"},
+			// 30 characters for name and extension, the extension kept whole; 31 for directories
+			"a-very-long-file-name-for-level-two.text": {"A_VERY_LONG_FILE_NAME_FOR_.TEXT", "A_VERY_LONG_FILE_NAME_FOR_LEVEL"},
+		}},
+		{"level 3", func(o *Options) { o.InterchangeLevel = 3 }, map[string]want{
+			"file.name.txt": {"FILE_NAME.TXT", "FILE_NAME_TXT"},
+			"a-very-long-file-name-for-level-two.text": {"A_VERY_LONG_FILE_NAME_FOR_.TEXT", "A_VERY_LONG_FILE_NAME_FOR_LEVEL"},
+		}},
+		{"lowercase", func(o *Options) { o.Relaxations.AllowLowercase = true }, map[string]want{
+			"Makefile":  {"Makefile", "Makefile"},
+			"lower.Txt": {"lower.Txt", "lower_Tx"},
+			".bashrc":   {"_bashrc", "_bashrc"},
+		}},
+		{"multi-dot", func(o *Options) { o.Relaxations.AllowMultiDot = true }, map[string]want{
+			"file.name.txt":  {"FILE.NAM.TXT", "FILE.NAM"},
+			"archive.tar.gz": {"ARCHIVE.GZ", "ARCHIVE."}, // no doubled separator where the base was cut
+			"a b.c":          {"A_B.C", "A_B.C"},
+		}},
+		{"leading dots", func(o *Options) { o.Relaxations.AllowLeadingDots = true }, map[string]want{
+			".bashrc":  {".BASHRC", ".BASHRC"},
+			".cfg.old": {".CFG.OLD", ".CFG_OLD"},
+		}},
+		{"37 characters", func(o *Options) { o.Relaxations.MaxLength37 = true }, map[string]want{
+			"file.name.txt": {"FILE_NAME.TXT", "FILE_NAME_TXT"},
+			// 37 characters including the separator, no version number follows
+			"a-very-long-file-name-for-level-two.text": {"A_VERY_LONG_FILE_NAME_FOR_LEVEL_.TEXT", "A_VERY_LONG_FILE_NAME_FOR_LEVEL_TWO_T"},
+			"a-name-of-exactly-thirty-seven-chars.x":   {"A_NAME_OF_EXACTLY_THIRTY_SEVEN_CHAR.X", "A_NAME_OF_EXACTLY_THIRTY_SEVEN_CHARS_"},
+		}},
+	}
+	for _, tt := range tests {
+		opts := DefaultOptions()
+		tt.setup(opts)
+		rules := (&ISOBuilder{options: opts}).iso9660NameRules()
+		for name, w := range tt.names {
+			if got := sanitizeISO9660Name(name, false, rules); got != w.file {
+				t.Errorf("%s: file '%s' -> '%s', want '%s'", tt.name, name, got, w.file)
+			}
+			if got := sanitizeISO9660Name(name, true, rules); got != w.dir {
+				t.Errorf("%s: directory '%s' -> '%s', want '%s'", tt.name, name, got, w.dir)
+			}
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package iso9660
 
+import "time"
+
 // Options configures the ISO image creation.
 type Options struct {
 	VolumeIdentifierISO          string  // PVD, max 32 d-characters (e.g., "Whatever")
@@ -12,6 +14,44 @@ type Options struct {
 	ApplicationIdentifierISO     string  // PVD, max 128 a-characters
 	ApplicationIdentifierJoliet  string  // SVD, max 64 UCS-2 characters
 	JolietEscapeSequence         [3]byte // Joliet UCS level -> {'%', '/', 'E'} - Level 3
+	RockRidge                    bool    // record POSIX names, permissions, owners and timestamps (RRIP) in the ISO9660 tree, off by default
+	FollowSymlinks               bool    // embed symlink targets' content instead of recording links (Rock Ridge SL)
+
+	// volume creation/modification timestamps, and recording time of entries that have none (RR_MOVED, ...);
+	// zero: the time of the build, taken once. Set it for reproducible images.
+	Timestamp time.Time
+
+	// ISO 9660 interchange level: 1 (8.3 names, default, also for 0), 2 (31-character names), 3 (Level 2 names, multi-extent files).
+	InterchangeLevel int
+	// mkisofs-style loosening of the ISO9660 naming rules, readers may not all accept them.
+	Relaxations NameRelaxations
+	// add an ISO 9660:1999 Enhanced Volume Descriptor with its own tree: 207-byte names, no version numbers, no depth limit.
+	EnhancedVolumeDescriptor bool
+	// record files of 4 GiB and more as several extents (implied by InterchangeLevel 3); without it such files are refused.
+	MultiExtent bool
+	// beyond 65,535 directories, clamp path table parent numbers to 65,535 instead of failing (as libisofs does);
+	// readers walking the directory records still see the whole tree.
+	ClampPathTables bool
+	// read upcoming files with this many goroutines while the data is written (0 or 1: one file at a time);
+	// helps trees of many small files on network storage or SSDs, the image is the same either way.
+	ReadAheadWorkers int
+
+	// El Torito boot images (see ISOBuilder.SetBootImage and AddBootEntry), the first is the default entry.
+	// : empty for a non-bootable image
+	BootEntries []BootEntry
+
+	// MBR/GPT partition tables in the system area and an appended ESP, for USB boot; nil for a blank system area.
+	Hybrid *HybridOptions
+}
+
+// NameRelaxations break the ISO9660 naming rules of the interchange level in the ways mkisofs allows.
+// : the Joliet and Rock Ridge names are unaffected.
+type NameRelaxations struct {
+	AllowLowercase   bool // keep lowercase letters (-allow-lowercase)
+	AllowMultiDot    bool // keep every dot of a name, not only the extension separator (-allow-multidot)
+	OmitVersion      bool // no ";1" version number on file names (-N)
+	AllowLeadingDots bool // keep the leading dot of names like ".bashrc" (-allow-leading-dots)
+	MaxLength37      bool // 37-character names whatever the level, implies OmitVersion (-max-iso9660-filenames)
 }
 
 // DefaultOptions returns a new Options struct with sensible defaults.
@@ -27,5 +67,6 @@ func DefaultOptions() *Options {
 		ApplicationIdentifierISO:     "goiso9660",
 		ApplicationIdentifierJoliet:  "goiso9660 joliet",
 		JolietEscapeSequence:         [3]byte{'%', '/', 'E'}, // UCS-2 Level 3
+		InterchangeLevel:             1,
 	}
 }
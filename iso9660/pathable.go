@@ -3,6 +3,7 @@ package iso9660
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"sort"
 )
 
@@ -30,100 +31,153 @@ func marshalPathTableRecord(fields *pathTableRecordFields, identifier []byte, us
 	return record, nil
 }
 
-// createPathTable generates the bytes for a Path Table (L-Type or M-Type).
-// useBigEndian: true for M-Type (Big Endian), false for L-Type (Little Endian).
-func (b *ISOBuilder) createPathTable(isJoliet bool, useBigEndian bool) []byte {
-	buffer := new(bytes.Buffer)
-	var pathTableDirs []fileEntry
-
-	for _, fe := range b.fileEntries {
-		if fe.isDir && fe.pathTableDirNum > 0 { // pathTableDirNum > 0 filters out any non-directory entries by mistake
-			pathTableDirs = append(pathTableDirs, fe)
+// assignDirectoryNumbers numbers the directories of every tree for their path tables, once names are final.
+// : breadth-first from the root, siblings in identifier order, so directories are numbered by level, then
+// : parent directory number, then identifier (ECMA-119 9.4). The ISO9660 tree follows relocations.
+// : parent numbers are 16 bits, a tree of more than maxPathTableDirNum directories needs Options.ClampPathTables.
+func (b *ISOBuilder) assignDirectoryNumbers() error {
+	for i := range b.fileEntries {
+		for kind := range b.fileEntries[i].nodes {
+			b.fileEntries[i].nodes[kind].dirNum = 0 // entries absent from a tree keep 0
 		}
 	}
+	for _, tree := range b.trees {
+		kind := tree.kind
+		b.fileEntries[0].nodes[kind].dirNum = 1
+		nextDirNum := uint32(2)
+		queue := []int{0}
+		for len(queue) > 0 {
+			dirIndex := queue[0]
+			queue = queue[1:]
 
-	// sort according to L-Type (by dir number) or M-Type (by name hierarchy)
-	sort.Slice(pathTableDirs, func(i, j int) bool {
-		dirI, dirJ := pathTableDirs[i], pathTableDirs[j]
-		if useBigEndian { // M-Type: sort by directory identifier (name), but hierarchically (ECMA-119 9.4.4)
-			var nameIBytes, nameJBytes []byte
-			if dirI.pathTableDirNum == 1 {
-				nameIBytes = []byte{0x00}
-			} else {
-				if isJoliet {
-					nameIBytes = encodeUTF16BE(dirI.jolietName)
-				} else {
-					nameIBytes = []byte(dirI.iso9660Name)
-				}
-			}
-			if dirJ.pathTableDirNum == 1 {
-				nameJBytes = []byte{0x00}
-			} else {
-				if isJoliet {
-					nameJBytes = encodeUTF16BE(dirJ.jolietName)
-				} else {
-					nameJBytes = []byte(dirJ.iso9660Name)
+			var subdirs []int
+			_, children := b.treeLinks(dirIndex, kind)
+			for _, childIndex := range children {
+				if b.fileEntries[childIndex].isDir {
+					subdirs = append(subdirs, childIndex)
 				}
 			}
-			// sort by parent directory number, secondary by name to group children of same parent
-			if dirI.parentIndex != dirJ.parentIndex && b.fileEntries[dirI.parentIndex].pathTableDirNum != b.fileEntries[dirJ.parentIndex].pathTableDirNum {
-				return b.fileEntries[dirI.parentIndex].pathTableDirNum < b.fileEntries[dirJ.parentIndex].pathTableDirNum
+			sort.Slice(subdirs, func(i, j int) bool {
+				return comparePathTableIdentifiers(pathTableIdentifier(&b.fileEntries[subdirs[i]], kind), pathTableIdentifier(&b.fileEntries[subdirs[j]], kind), kind) < 0
+			})
+			for _, childIndex := range subdirs {
+				b.fileEntries[childIndex].nodes[kind].dirNum = nextDirNum
+				nextDirNum++
 			}
-			return bytes.Compare(nameIBytes, nameJBytes) < 0
+			queue = append(queue, subdirs...)
+		}
+		if numDirs := nextDirNum - 1; numDirs > maxPathTableDirNum && !b.options.ClampPathTables {
+			return fmt.Errorf("%s tree has %d directories, path tables can't refer to more than %d (Options.ClampPathTables)", kind, numDirs, maxPathTableDirNum)
+		}
+	}
+	return nil
+}
 
+// comparePathTableIdentifiers orders two encoded directory identifiers of a tree as ECMA-119 9.4 requires:
+// byte by byte, the shorter one padded on the right with (20) bytes (UCS-2 spaces for Joliet).
+func comparePathTableIdentifiers(a, b []byte, kind treeKind) int {
+	padding := []byte{0x20}
+	if kind == treeJoliet {
+		padding = []byte{0x00, 0x20}
+	}
+	at := func(identifier []byte, n int) byte {
+		if n < len(identifier) {
+			return identifier[n]
+		}
+		return padding[(n-len(identifier))%len(padding)]
+	}
+	for n := 0; n < max(len(a), len(b)); n++ {
+		if ca, cb := at(a, n), at(b, n); ca != cb {
+			return int(ca) - int(cb)
 		}
-		// L-Type: sort by directory number (ECMA-119 9.4.3)
-		return dirI.pathTableDirNum < dirJ.pathTableDirNum
+	}
+	return 0
+}
+
+// iso9660DirectoryExtentOrder returns the indices of the ISO9660 tree's directories in extent order.
+// : ordered by depth in the source tree, so every directory's extent follows both its ISO9660 parent
+// : (RR_MOVED sits at depth 1) and, for relocated directories, the parent holding the CL placeholder.
+// : readers that walk the image front to back (libarchive) rely on that.
+func (b *ISOBuilder) iso9660DirectoryExtentOrder() []int {
+	var dirs []int
+	depth := make(map[int]int)
+	for i := range b.fileEntries {
+		if !b.fileEntries[i].isDir || b.fileEntries[i].nodes[treeISO9660].dirNum == 0 {
+			continue
+		}
+		dirs = append(dirs, i)
+		for p := i; p != 0; p = b.fileEntries[p].parentIndex {
+			depth[i]++
+		}
+	}
+	sort.SliceStable(dirs, func(i, j int) bool {
+		if depth[dirs[i]] != depth[dirs[j]] {
+			return depth[dirs[i]] < depth[dirs[j]]
+		}
+		return b.fileEntries[dirs[i]].nodes[treeISO9660].dirNum < b.fileEntries[dirs[j]].nodes[treeISO9660].dirNum
 	})
+	return dirs
+}
 
-	for _, dir := range pathTableDirs {
-		var ptFields pathTableRecordFields
-		ptFields.ExtendedAttributeRecordLength = 0
+// pathTableIdentifier returns the identifier of a directory as recorded in the path tables of the given tree.
+func pathTableIdentifier(dir *fileEntry, kind treeKind) []byte {
+	if dir.isRoot {
+		return []byte{0x00} // root
+	}
+	if kind == treeJoliet {
+		return encodeUTF16BE(dir.nodes[kind].name)
+	}
+	return []byte(dir.nodes[kind].name)
+}
 
-		var identifierBytes []byte
-		if dir.pathTableDirNum == 1 {
-			identifierBytes = []byte{0x00}
-			ptFields.ParentDirectoryNumber = 1
-		} else {
-			// non-root directory
-			if isJoliet {
-				identifierBytes = encodeUTF16BE(dir.jolietName)
-			} else {
-				identifierBytes = []byte(dir.iso9660Name)
-			}
-			ptFields.ParentDirectoryNumber = b.fileEntries[dir.parentIndex].pathTableDirNum
+// createPathTable generates the bytes for a Path Table (L-Type or M-Type) of the given tree.
+// useBigEndian: true for M-Type (Big Endian), false for L-Type (Little Endian).
+func (b *ISOBuilder) createPathTable(kind treeKind, useBigEndian bool) []byte {
+	buffer := new(bytes.Buffer)
+	var pathTableDirs []int // indices in fileEntries
+	for i, fe := range b.fileEntries {
+		if fe.isDir && fe.nodes[kind].dirNum > 0 { // dirNum > 0 filters out any non-directory entries by mistake (and ISO9660-only RR_MOVED)
+			pathTableDirs = append(pathTableDirs, i)
 		}
+	}
+	dirNum := func(i int) uint32 { return b.fileEntries[i].nodes[kind].dirNum }
+	parentDirNum := func(i int) uint16 {
+		parentIndex, _ := b.treeLinks(i, kind)
+		// past maxPathTableDirNum (Options.ClampPathTables) the records keep their order but not their parent,
+		// readers find these directories through the directory records.
+		return uint16(min(dirNum(parentIndex), maxPathTableDirNum))
+	}
 
-		// locationn of the directory's extent
-		if isJoliet {
-			ptFields.LocationOfExtent = dir.jolietSector
-		} else {
-			ptFields.LocationOfExtent = dir.iso9660Sector
-		}
+	// numbering already follows the spec ordering (and relocations),
+	// both L-Type and M-Type tables list directories by number (ECMA-119 9.4).
+	sort.Slice(pathTableDirs, func(i, j int) bool {
+		return dirNum(pathTableDirs[i]) < dirNum(pathTableDirs[j])
+	})
 
-		recordBytes, _ := marshalPathTableRecord(&ptFields, identifierBytes, useBigEndian)
+	for _, i := range pathTableDirs {
+		dir := &b.fileEntries[i]
+		ptFields := pathTableRecordFields{
+			ExtendedAttributeRecordLength: 0,
+			LocationOfExtent:              dir.nodes[kind].sector, // location of the directory's extent
+			ParentDirectoryNumber:         1,
+		}
+		if dir.nodes[kind].dirNum != 1 { // non-root directory
+			ptFields.ParentDirectoryNumber = parentDirNum(i)
+		}
+		recordBytes, _ := marshalPathTableRecord(&ptFields, pathTableIdentifier(dir, kind), useBigEndian)
 		buffer.Write(recordBytes)
 	}
 	return buffer.Bytes()
 }
 
-// calculatePathTableTotalBytes calculates the total unpadded byte length of a path table.
+// calculatePathTableTotalBytes calculates the total unpadded byte length of a path table of the given tree.
 // : determine how many sectors the path table will occupy.
-func (b *ISOBuilder) calculatePathTableTotalBytes(isJoliet bool) int {
+func (b *ISOBuilder) calculatePathTableTotalBytes(kind treeKind) int {
 	totalBytes := 0
-	for _, fe := range b.fileEntries {
-		if fe.isDir && fe.pathTableDirNum > 0 {
-			var identifierBytes []byte
-			if fe.pathTableDirNum == 1 {
-				identifierBytes = []byte{0x00}
-			} else {
-				if isJoliet {
-					identifierBytes = encodeUTF16BE(fe.jolietName)
-				} else {
-					identifierBytes = []byte(fe.iso9660Name)
-				}
-			}
-
+	for i := range b.fileEntries {
+		fe := &b.fileEntries[i]
+		if fe.isDir && fe.nodes[kind].dirNum > 0 {
+			identifierBytes := pathTableIdentifier(fe, kind)
 			recordFinalLen := ptRecFixedPartSize + len(identifierBytes)
 			if len(identifierBytes)%2 != 0 {
 				recordFinalLen++
@@ -0,0 +1,150 @@
+package iso9660
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestPathTableOrder checks the path tables of each tree list directories by level, then parent number,
+// then identifier padded with spaces, that the L and M tables agree, and that every record locates its directory.
+func TestPathTableOrder(t *testing.T) {
+	source := writeSourceTree(t, map[string]string{
+		"b/c/":   "",
+		"a/z/":   "",
+		"a/y/":   "",
+		"a_b/":   "",
+		"a\x01/": "",  // sorts before "a" where names keep control characters (below the padding)
+		"ab/x/w": "w", // a file, not listed
+	})
+	opts := DefaultOptions()
+	opts.EnhancedVolumeDescriptor = true
+	img := openImage(t, buildImage(t, source, opts))
+
+	// "A" < "AB" < "A_" < "A_B" once padded: 0x20 sorts before 'B', 'B' before '_';
+	// "a\x01" < "a" < "a_b" < "ab" in the Joliet (UCS-2) and Enhanced trees
+	type record struct {
+		path   string
+		parent uint16
+	}
+	upper := []record{{"", 1}, {"A", 1}, {"AB", 1}, {"A_", 1}, {"A_B", 1}, {"B", 1}, {"A/Y", 2}, {"A/Z", 2}, {"AB/X", 3}, {"B/C", 6}}
+	lower := []record{{"", 1}, {"a\x01", 1}, {"a", 1}, {"a_b", 1}, {"ab", 1}, {"b", 1}, {"a/y", 3}, {"a/z", 3}, {"ab/x", 5}, {"b/c", 6}}
+	for _, tt := range []struct {
+		vol  *Volume
+		want []record
+	}{
+		{img.Primary(), upper},
+		{img.Joliet(), lower},
+		{img.Enhanced(), lower},
+	} {
+		little, err := tt.vol.ReadPathTable(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		big, err := tt.vol.ReadPathTable(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(little, big) {
+			t.Errorf("volume %q: L path table %v, M path table %v", tt.vol.VolumeIdentifier, little, big)
+		}
+		if len(little) != len(tt.want) {
+			t.Fatalf("volume %q: %d path table records, want %d", tt.vol.VolumeIdentifier, len(little), len(tt.want))
+		}
+		for k, w := range tt.want {
+			got := little[k]
+			if got.Identifier != lastElement(w.path) || got.ParentDirectoryNumber != w.parent {
+				t.Errorf("volume %q: record %d is '%s' in %d, want '%s' in %d", tt.vol.VolumeIdentifier, k+1, got.Identifier, got.ParentDirectoryNumber, w.path, w.parent)
+				continue
+			}
+			dir, err := tt.vol.Lookup(w.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.LocationExtent != dir.LBA {
+				t.Errorf("volume %q: record of '%s' at LBA %d, directory at %d", tt.vol.VolumeIdentifier, w.path, got.LocationExtent, dir.LBA)
+			}
+		}
+	}
+}
+
+// lastElement returns the last element of a slash separated path, "" for the root.
+func lastElement(path string) string {
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+// decodePathTable decodes the identifiers and parent numbers of a path table built by createPathTable.
+func decodePathTable(t *testing.T, table []byte, order binary.ByteOrder) (identifiers []string, parents []uint16) {
+	t.Helper()
+	for offset := 0; offset < len(table); {
+		identifierLen := int(table[offset])
+		if identifierLen == 0 {
+			t.Fatalf("path table record at offset %d without identifier", offset)
+		}
+		identifiers = append(identifiers, string(table[offset+ptRecFixedPartSize:offset+ptRecFixedPartSize+identifierLen]))
+		parents = append(parents, order.Uint16(table[offset+6:offset+8]))
+		offset += ptRecFixedPartSize + identifierLen + identifierLen%2
+	}
+	return identifiers, parents
+}
+
+// TestPathTableDirectoryLimit lays out a tree of one directory more than the path tables can number,
+// the last one below a directory numbered past maxPathTableDirNum: refused by default, the parent
+// clamped with Options.ClampPathTables.
+func TestPathTableDirectoryLimit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("creates 65,536 directories")
+	}
+	source := t.TempDir()
+	for i := 0; i < maxPathTableDirNum; i++ {
+		if err := os.Mkdir(filepath.Join(source, fmt.Sprintf("d%05d", i)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	last := fmt.Sprintf("d%05d", maxPathTableDirNum-1) // number maxPathTableDirNum+1
+	if err := os.Mkdir(filepath.Join(source, last, "x"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	const numDirs = maxPathTableDirNum + 2 // root, its subdirectories and x
+
+	err := NewBuilder(source, "", DefaultOptions()).prepareLayout()
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("%d directories", numDirs)) {
+		t.Fatalf("laying out %d directories: %v, want a refusal", numDirs, err)
+	}
+
+	opts := DefaultOptions()
+	opts.ClampPathTables = true
+	b := NewBuilder(source, "", opts)
+	if err := b.prepareLayout(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tree := range b.trees {
+		identifiers, parents := decodePathTable(t, tree.pathTableL, binary.LittleEndian)
+		mIdentifiers, mParents := decodePathTable(t, tree.pathTableM, binary.BigEndian)
+		if !slices.Equal(identifiers, mIdentifiers) || !slices.Equal(parents, mParents) {
+			t.Errorf("%s tree: L and M path tables differ", tree.kind)
+		}
+		if len(identifiers) != numDirs {
+			t.Fatalf("%s tree: %d path table records, want %d", tree.kind, len(identifiers), numDirs)
+		}
+		for k, parent := range parents[:numDirs-1] {
+			if parent != 1 {
+				t.Fatalf("%s tree: record %d in %d, want the root", tree.kind, k+1, parent)
+			}
+		}
+		// x's parent is number maxPathTableDirNum+1, past what 16 bits hold
+		wantX := map[treeKind]string{treeISO9660: "X", treeJoliet: "\x00x"}[tree.kind]
+		if x := numDirs - 1; identifiers[x] != wantX || parents[x] != maxPathTableDirNum {
+			t.Errorf("%s tree: last record %q in %d, want %q clamped to %d", tree.kind, identifiers[x], parents[x], wantX, maxPathTableDirNum)
+		}
+		for _, f := range b.fileEntries {
+			if f.originalName == last && f.nodes[tree.kind].dirNum != maxPathTableDirNum+1 {
+				t.Errorf("%s tree: '%s' numbered %d", tree.kind, last, f.nodes[tree.kind].dirNum)
+			}
+		}
+	}
+}
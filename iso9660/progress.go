@@ -0,0 +1,96 @@
+package iso9660
+
+import "fmt"
+
+// ProgressPhase is the step of a build a Progress report was made in.
+type ProgressPhase int
+
+const (
+	PhaseScan        ProgressPhase = iota // walking the source directory
+	PhaseLayout                           // naming entries, numbering directories, assigning LBAs
+	PhaseDescriptors                      // system area and volume descriptors
+	PhasePathTables                       // path tables of every tree
+	PhaseDirectories                      // ISO9660 directory listings and Rock Ridge continuation areas
+	PhaseFileData                         // boot catalog and boot images, file data extents, then the listings of the other trees
+	PhaseFinalize                         // appended EFI System Partition, trailing padding, backup GPT
+)
+
+// String returns the name of the phase, for messages.
+func (p ProgressPhase) String() string {
+	switch p {
+	case PhaseScan:
+		return "scan"
+	case PhaseLayout:
+		return "layout"
+	case PhaseDescriptors:
+		return "descriptors"
+	case PhasePathTables:
+		return "path tables"
+	case PhaseDirectories:
+		return "directories"
+	case PhaseFileData:
+		return "file data"
+	case PhaseFinalize:
+		return "finalize"
+	}
+	return fmt.Sprintf("ProgressPhase(%d)", int(p))
+}
+
+// Progress is a snapshot of a running build, passed to the function registered with SetProgressFunc.
+type Progress struct {
+	Phase          ProgressPhase
+	EntriesScanned int    // files, directories, links and special files found so far
+	BytesWritten   int64  // image bytes written so far
+	TotalBytes     int64  // size of the image, known once the layout is done (0 before)
+	CurrentFile    string // file being written in PhaseFileData (e.g., "/docs/report-2023.txt"), "" otherwise
+}
+
+// progressScanInterval is the number of scanned entries between two reports of the scan phase.
+const progressScanInterval = 256
+
+// SetProgressFunc registers fn to be called as Build (BuildContext, BuildTo, WriteTo, VirtualImage) goes on,
+// nil stops reporting.
+// : fn is called on the building goroutine at every phase change, every few hundred scanned entries, every file
+// : written and every 16 MiB of large files, so it should return quickly (a progress bar throttles its redraws).
+// : the phase never goes back (PhaseScan to PhaseFinalize) and the last report has BytesWritten at TotalBytes.
+func (b *ISOBuilder) SetProgressFunc(fn func(Progress)) {
+	b.progressFunc = fn
+}
+
+// reportProgress passes the current progress to the registered function, if any.
+func (b *ISOBuilder) reportProgress() {
+	if b.progressFunc != nil {
+		b.progressFunc(b.progress)
+	}
+}
+
+// reportPhase reports the start of a phase, bytesWritten bytes into the image.
+func (b *ISOBuilder) reportPhase(phase ProgressPhase, bytesWritten int64) {
+	b.progress.Phase = phase
+	b.progress.BytesWritten = bytesWritten
+	b.progress.CurrentFile = ""
+	b.reportProgress()
+}
+
+// reportFile reports the start of the data of a file (isoPath), bytesWritten bytes into the image.
+func (b *ISOBuilder) reportFile(isoPath string, bytesWritten int64) {
+	b.progress.BytesWritten = bytesWritten
+	b.progress.CurrentFile = isoPath
+	b.reportProgress()
+}
+
+// reportBytesWritten reports the image bytes written so far, within a phase or a file.
+func (b *ISOBuilder) reportBytesWritten(bytesWritten int64) {
+	b.progress.BytesWritten = bytesWritten
+	b.reportProgress()
+}
+
+// reportScanned reports the number of entries found so far, every progressScanInterval entries
+// (and always when final is set).
+func (b *ISOBuilder) reportScanned(final bool) {
+	n := len(b.fileEntries) - 1 // the root isn't a found entry
+	if final || n%progressScanInterval == 0 && n != b.progress.EntriesScanned {
+		b.progress.EntriesScanned = n
+		b.reportProgress()
+	}
+}
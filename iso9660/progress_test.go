@@ -0,0 +1,65 @@
+package iso9660
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"testing"
+)
+
+// TestProgressReports checks the reports of a build of every tree, a boot image and a hybrid system area:
+// phases in order, entries counted while scanning, TotalBytes once laid out and BytesWritten reaching it.
+func TestProgressReports(t *testing.T) {
+	tree := equivalenceTree()
+	for n := 0; n < 2*progressScanInterval; n++ {
+		tree[fmt.Sprintf("more/file%03d.txt", n)] = "more"
+	}
+	source := writeSourceTree(t, tree)
+	numEntries := -1 // the root isn't a found entry
+	if err := filepath.WalkDir(source, func(string, fs.DirEntry, error) error { numEntries++; return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, readAhead := range []int{0, 4} {
+		b := newEquivalenceBuilder(source, readAhead)
+		var reports []Progress
+		b.SetProgressFunc(func(p Progress) { reports = append(reports, p) })
+		var buf bytes.Buffer
+		if _, err := b.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+
+		var phases []ProgressPhase
+		var scanned []int
+		for k, p := range reports {
+			if len(phases) == 0 || p.Phase != phases[len(phases)-1] {
+				phases = append(phases, p.Phase)
+			}
+			if p.Phase == PhaseScan && (len(scanned) == 0 || p.EntriesScanned != scanned[len(scanned)-1]) {
+				scanned = append(scanned, p.EntriesScanned)
+			}
+			if p.Phase > PhaseLayout && p.TotalBytes != int64(buf.Len()) || p.Phase == PhaseScan && p.TotalBytes != 0 {
+				t.Errorf("read-ahead %d: report %d (%s) has TotalBytes %d, the image is %d bytes", readAhead, k, p.Phase, p.TotalBytes, buf.Len())
+			}
+			if k > 0 && p.BytesWritten < reports[k-1].BytesWritten {
+				t.Errorf("read-ahead %d: report %d (%s) has BytesWritten %d after %d", readAhead, k, p.Phase, p.BytesWritten, reports[k-1].BytesWritten)
+			}
+			if p.CurrentFile != "" && p.Phase != PhaseFileData {
+				t.Errorf("read-ahead %d: report %d (%s) names the current file '%s'", readAhead, k, p.Phase, p.CurrentFile)
+			}
+		}
+		wantPhases := []ProgressPhase{PhaseScan, PhaseLayout, PhaseDescriptors, PhasePathTables, PhaseDirectories, PhaseFileData, PhaseFinalize}
+		if fmt.Sprint(phases) != fmt.Sprint(wantPhases) {
+			t.Errorf("read-ahead %d: phases %v, want %v", readAhead, phases, wantPhases)
+		}
+		if last := reports[len(reports)-1]; last.BytesWritten != int64(buf.Len()) || last.CurrentFile != "" {
+			t.Errorf("read-ahead %d: last report has BytesWritten %d of %d, current file '%s'", readAhead, last.BytesWritten, buf.Len(), last.CurrentFile)
+		}
+		// 0 at the start of the scan, every progressScanInterval entries, then the total
+		wantScanned := []int{0, progressScanInterval, 2 * progressScanInterval, numEntries}
+		if fmt.Sprint(scanned) != fmt.Sprint(wantScanned) || reports[len(reports)-1].EntriesScanned != numEntries {
+			t.Errorf("read-ahead %d: entries scanned %v, want %v", readAhead, scanned, wantScanned)
+		}
+	}
+}
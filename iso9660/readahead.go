@@ -0,0 +1,88 @@
+package iso9660
+
+import "sync"
+
+// readAheadMaxFileSize is the largest file read ahead by the workers, bigger files are streamed by the writer
+// : in-flight buffers never exceed 2 * Options.ReadAheadWorkers * readAheadMaxFileSize bytes.
+const readAheadMaxFileSize = 4 << 20
+
+// readAheadResult is the content of a file read by a worker, or the error reading it.
+type readAheadResult struct {
+	data []byte
+	err  error
+}
+
+// writeFileDataReadAhead writes the data extents of the entries of order (LBA order) while a pool of
+// Options.ReadAheadWorkers goroutines reads the upcoming small files.
+// : files are dispatched in LBA order and every read-ahead buffer holds a slot until it's written, so the
+// : file the writer waits for is always being read or done, and memory stays bounded.
+func (b *ISOBuilder) writeFileDataReadAhead(w *imageWriter, order []int) error {
+	workers := b.options.ReadAheadWorkers
+	results := make([]chan readAheadResult, len(order)) // nil: streamed by the writer
+	for n, i := range order {
+		if b.fileEntries[i].size <= readAheadMaxFileSize {
+			results[n] = make(chan readAheadResult, 1)
+		}
+	}
+
+	slots := make(chan struct{}, 2*workers)
+	jobs := make(chan int)
+	done := make(chan struct{}) // closed when the writer returns, early on errors
+	var wg sync.WaitGroup
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+
+	wg.Add(1)
+	go func() { // dispatcher
+		defer wg.Done()
+		defer close(jobs)
+		for n := range order {
+			if results[n] == nil {
+				continue
+			}
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+			select {
+			case jobs <- n:
+			case <-done:
+				return
+			}
+		}
+	}()
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range jobs {
+				f := &b.fileEntries[order[n]]
+				data, err := readFileExactly(f.diskPath, f.size)
+				results[n] <- readAheadResult{data: data, err: err}
+			}
+		}()
+	}
+
+	for n, i := range order {
+		f := &b.fileEntries[i]
+		if results[n] == nil {
+			if err := b.writeFileData(w, f, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		result := <-results[n]
+		if result.err != nil {
+			return result.err
+		}
+		err := b.writeFileData(w, f, result.data)
+		<-slots // buffer written, the next file may be read
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
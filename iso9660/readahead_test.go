@@ -0,0 +1,52 @@
+package iso9660
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// equivalenceTree holds more files than a VirtualImage keeps open, files on both sides of
+// readAheadMaxFileSize and a boot image with a boot info table patched in.
+func equivalenceTree() map[string]string {
+	tree := map[string]string{
+		"isolinux/isolinux.bin":      strings.Repeat("\x90", 4*SectorSize),
+		"large.bin":                  strings.Repeat("large", readAheadMaxFileSize/5+1000),
+		"a/b/c/d/e/f/g/h/i/deep.txt": "deep",
+	}
+	for n := 0; n < 3*maxOpenSourceFiles; n++ {
+		tree[fmt.Sprintf("many/file%03d.txt", n)] = strings.Repeat(fmt.Sprint(n), 1+n*37%3000)
+	}
+	return tree
+}
+
+// newEquivalenceBuilder returns a builder of a bootable, hybrid image with every tree and a fixed timestamp.
+func newEquivalenceBuilder(source string, readAheadWorkers int) *ISOBuilder {
+	opts := rockRidgeOptions()
+	opts.EnhancedVolumeDescriptor = true
+	opts.Timestamp = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	opts.ReadAheadWorkers = readAheadWorkers
+	opts.Hybrid = &HybridOptions{GPT: true}
+	opts.BootEntries = []BootEntry{{Path: "isolinux/isolinux.bin", SectorCount: 4, BootInfoTable: true}}
+	return NewBuilder(source, "", opts)
+}
+
+// TestReadAheadOutput checks writing with read-ahead workers produces the same image as without.
+func TestReadAheadOutput(t *testing.T) {
+	source := writeSourceTree(t, equivalenceTree())
+	var want bytes.Buffer
+	if _, err := newEquivalenceBuilder(source, 0).WriteTo(&want); err != nil {
+		t.Fatal(err)
+	}
+	for _, workers := range []int{2, 8} {
+		var got bytes.Buffer
+		if _, err := newEquivalenceBuilder(source, workers).WriteTo(&got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Errorf("image written with %d read-ahead workers differs", workers)
+		}
+	}
+}
@@ -0,0 +1,550 @@
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// maxVolumeDescriptors bounds the scan of the volume descriptor set so a corrupt image
+// without a terminator can't make Open read forever.
+const maxVolumeDescriptors = 64
+
+// maxDirectoryExtentSize and maxPathTableSize bound the buffers allocated from sizes recorded in the image,
+// so a corrupt or hostile image can't make the reader allocate gigabytes.
+const (
+	maxDirectoryExtentSize = 256 << 20 // far beyond the listing of a million entries
+	maxPathTableSize       = 64 << 20  // 65,535 directories of 255-byte names take 17 MB
+)
+
+// ErrNotISO9660 is returned by Open when sector 16 does not hold a volume descriptor.
+var ErrNotISO9660 = errors.New("iso9660: not an ISO 9660 image")
+
+// Image is a read-only view of an existing ISO 9660 / Joliet image.
+// : all reads are served from the underlying io.ReaderAt, nothing is cached besides descriptors.
+type Image struct {
+	r        io.ReaderAt
+	size     int64   // size of r in bytes, -1 if it can't tell (the volume space size bounds reads then)
+	primary  *Volume // PVD, always present
+	joliet   *Volume // Joliet SVD, nil if the image has none
+	enhanced *Volume // ISO 9660:1999 Enhanced Volume Descriptor, nil if the image has none
+}
+
+// Volume is a decoded Primary or Supplementary Volume Descriptor together with its directory tree.
+// -> ECMA-119 Section 8.4 / 8.5 for field details.
+type Volume struct {
+	Type                   byte // vdTypePrimary or vdTypeSupplementary
+	Joliet                 bool // SVD carrying one of the Joliet UCS-2 escape sequences
+	Enhanced               bool // ISO 9660:1999 Enhanced Volume Descriptor (SVD version 2)
+	SystemIdentifier       string
+	VolumeIdentifier       string
+	VolumeSpaceSize        uint32 // in logical blocks
+	LogicalBlockSize       uint16
+	PathTableSize          uint32 // size in bytes of the path table
+	LPathTableLocation     uint32
+	MPathTableLocation     uint32
+	VolumeSetIdentifier    string
+	PublisherIdentifier    string
+	DataPreparerIdentifier string
+	ApplicationIdentifier  string
+	CreationTime           time.Time
+	ModificationTime       time.Time
+	EscapeSequences        [32]byte
+	FileStructureVersion   byte
+
+	img  *Image
+	root *Entry
+}
+
+// Entry is a decoded Directory Record.
+// -> ECMA-119 Section 9.1 for field details.
+type Entry struct {
+	Name          string    // identifier as recorded ("FILE.TXT;1" for ISO 9660, decoded UCS-2 for Joliet), "" for the root
+	LBA           uint32    // location of the extent
+	Size          int64     // data length in bytes, summed over all extents of a multi-extent file
+	RecordingTime time.Time // recording date and time
+	FileFlags     byte      // FileFlagHidden, FileFlagDirectory, ...
+	SystemUse     []byte    // raw System Use field (SUSP / Rock Ridge entries), nil if empty
+
+	vol     *Volume
+	extents []fileExtent // all extents of a multi-extent file, in order; nil for single-extent entries
+}
+
+// fileExtent is the location and length of one extent of a multi-extent file.
+type fileExtent struct {
+	lba  uint32
+	size int64
+}
+
+// PathTableRecord is a decoded Path Table Record.
+// -> ECMA-119 Section 9.4 for field details.
+type PathTableRecord struct {
+	Identifier            string // directory identifier, "" for the root
+	LocationExtent        uint32
+	ParentDirectoryNumber uint16
+}
+
+// Open parses the volume descriptor set of an ISO 9660 image starting at sector 16.
+// : the PVD is required, a Joliet SVD is picked up if present.
+func Open(r io.ReaderAt) (*Image, error) {
+	img := &Image{r: r, size: -1}
+	switch sized := r.(type) {
+	case interface{ Size() int64 }: // *bytes.Reader, *io.SectionReader, *VirtualImage, ...
+		img.size = sized.Size()
+	case interface{ Stat() (fs.FileInfo, error) }: // *os.File
+		if info, err := sized.Stat(); err == nil && info.Mode().IsRegular() {
+			img.size = info.Size()
+		}
+	}
+	sector := make([]byte, SectorSize)
+
+	for i := 0; i < maxVolumeDescriptors; i++ {
+		lba := int64(SystemAreaNumSectors + i)
+		if _, err := r.ReadAt(sector, lba*SectorSize); err != nil {
+			if i == 0 && errors.Is(err, io.EOF) {
+				return nil, ErrNotISO9660
+			}
+			return nil, fmt.Errorf("reading volume descriptor at sector %d: %w", lba, err)
+		}
+		if !bytes.Equal(sector[1:6], []byte("CD001")) {
+			if i == 0 {
+				return nil, ErrNotISO9660
+			}
+			return nil, fmt.Errorf("volume descriptor at sector %d: bad standard identifier %q", lba, sector[1:6])
+		}
+
+		switch sector[0] {
+		case vdTypePrimary:
+			if img.primary != nil {
+				continue // only the first PVD is meaningful
+			}
+			vol, err := img.parseVolumeDescriptor(sector)
+			if err != nil {
+				return nil, fmt.Errorf("parsing PVD at sector %d: %w", lba, err)
+			}
+			img.primary = vol
+		case vdTypeSupplementary:
+			if isEnhancedVolumeDescriptor(sector) {
+				if img.enhanced != nil {
+					continue
+				}
+				vol, err := img.parseVolumeDescriptor(sector)
+				if err != nil {
+					return nil, fmt.Errorf("parsing Enhanced Volume Descriptor at sector %d: %w", lba, err)
+				}
+				img.enhanced = vol
+				continue
+			}
+			if img.joliet != nil || !isJolietEscapeSequence(sector[88:120]) {
+				continue
+			}
+			vol, err := img.parseVolumeDescriptor(sector)
+			if err != nil {
+				return nil, fmt.Errorf("parsing Joliet SVD at sector %d: %w", lba, err)
+			}
+			img.joliet = vol
+		case vdTypeTerminator:
+			if img.primary == nil {
+				return nil, fmt.Errorf("volume descriptor set has no primary volume descriptor")
+			}
+			return img, nil
+		}
+	}
+	return nil, fmt.Errorf("no volume descriptor set terminator within %d sectors", maxVolumeDescriptors)
+}
+
+// Primary returns the volume described by the Primary Volume Descriptor.
+func (img *Image) Primary() *Volume { return img.primary }
+
+// Joliet returns the volume described by the Joliet SVD, or nil if the image has none.
+func (img *Image) Joliet() *Volume { return img.joliet }
+
+// Enhanced returns the volume described by the ISO 9660:1999 Enhanced Volume Descriptor, or nil if the image has none.
+func (img *Image) Enhanced() *Volume { return img.enhanced }
+
+// isEnhancedVolumeDescriptor reports whether an SVD sector is an ISO 9660:1999 Enhanced Volume Descriptor
+// (version and file structure version 2).
+func isEnhancedVolumeDescriptor(sector []byte) bool {
+	return sector[0] == vdTypeSupplementary && sector[6] == 2 && sector[881] == 2
+}
+
+// isJolietEscapeSequence reports whether an SVD escape sequence field selects a Joliet UCS-2 level.
+func isJolietEscapeSequence(esc []byte) bool {
+	return esc[0] == '%' && esc[1] == '/' && (esc[2] == '@' || esc[2] == 'C' || esc[2] == 'E')
+}
+
+// parseVolumeDescriptor decodes a PVD or SVD sector (the inverse of
+// createPrimaryVolumeDescriptor / createJolietVolumeDescriptor).
+func (img *Image) parseVolumeDescriptor(sector []byte) (*Volume, error) {
+	vol := &Volume{
+		Type:                 sector[0],
+		Joliet:               sector[0] == vdTypeSupplementary && isJolietEscapeSequence(sector[88:120]),
+		Enhanced:             isEnhancedVolumeDescriptor(sector),
+		VolumeSpaceSize:      binary.LittleEndian.Uint32(sector[80:84]),
+		LogicalBlockSize:     binary.LittleEndian.Uint16(sector[128:130]),
+		PathTableSize:        binary.LittleEndian.Uint32(sector[132:136]),
+		LPathTableLocation:   binary.LittleEndian.Uint32(sector[140:144]),
+		MPathTableLocation:   binary.BigEndian.Uint32(sector[148:152]),
+		CreationTime:         parseVolumeTimestamp(sector[813:830]),
+		ModificationTime:     parseVolumeTimestamp(sector[830:847]),
+		FileStructureVersion: sector[881],
+		img:                  img,
+	}
+	copy(vol.EscapeSequences[:], sector[88:120])
+	if vol.LogicalBlockSize != SectorSize {
+		return nil, fmt.Errorf("unsupported logical block size %d", vol.LogicalBlockSize)
+	}
+
+	decode := decodeVolumeString
+	if vol.Joliet {
+		decode = decodeJolietVolumeString
+	}
+	vol.SystemIdentifier = decodeVolumeString(sector[8:40]) // a-characters even in Joliet SVDs
+	vol.VolumeIdentifier = decode(sector[40:72])
+	vol.VolumeSetIdentifier = decode(sector[190:318])
+	vol.PublisherIdentifier = decode(sector[318:446])
+	vol.DataPreparerIdentifier = decode(sector[446:574])
+	vol.ApplicationIdentifier = decode(sector[574:702])
+
+	root, err := vol.parseDirectoryRecord(sector[156:190])
+	if err != nil {
+		return nil, fmt.Errorf("root directory record: %w", err)
+	}
+	if !root.IsDir() {
+		return nil, fmt.Errorf("root directory record is not flagged as a directory")
+	}
+	root.Name = ""
+	vol.root = root
+	return vol, nil
+}
+
+// Root returns the root directory entry of the volume.
+func (v *Volume) Root() *Entry { return v.root }
+
+// ReadDir returns the entries of directory dir, excluding "." and "..", in recorded order.
+func (v *Volume) ReadDir(dir *Entry) ([]*Entry, error) {
+	if !dir.IsDir() {
+		return nil, fmt.Errorf("'%s' is not a directory", dir.Name)
+	}
+	extent, err := v.readMetadataExtent(dir.LBA, dir.Size, maxDirectoryExtentSize)
+	if err != nil {
+		return nil, fmt.Errorf("reading directory extent at LBA %d: %w", dir.LBA, err)
+	}
+
+	var entries []*Entry
+	for offset, index := 0, 0; offset < len(extent); {
+		recordLen := int(extent[offset])
+		if recordLen == 0 {
+			// records never span sectors, a zero length byte means "continue at the next sector"
+			offset = (offset/SectorSize + 1) * SectorSize
+			continue
+		}
+		if offset+recordLen > len(extent) {
+			return nil, fmt.Errorf("directory record at offset %d (len %d) overruns extent at LBA %d", offset, recordLen, dir.LBA)
+		}
+		e, err := v.parseDirectoryRecord(extent[offset : offset+recordLen])
+		if err != nil {
+			return nil, fmt.Errorf("directory record at offset %d in extent at LBA %d: %w", offset, dir.LBA, err)
+		}
+		offset += recordLen
+		index++
+		if index <= 2 { // "." and ".." always come first (ECMA-119 Section 6.8.2.2)
+			continue
+		}
+		if n := len(entries); n > 0 && entries[n-1].FileFlags&FileFlagMultiExtent != 0 {
+			// continuation of a multi-extent file, fold it into the entry started by the previous records
+			prev := entries[n-1]
+			if prev.extents == nil {
+				prev.extents = []fileExtent{{lba: prev.LBA, size: prev.Size}}
+			}
+			prev.extents = append(prev.extents, fileExtent{lba: e.LBA, size: e.Size})
+			prev.Size += e.Size
+			prev.FileFlags = e.FileFlags
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Lookup resolves a slash separated path relative to the root of the volume.
+// : ISO 9660 names match with or without their ";1" version suffix.
+func (v *Volume) Lookup(name string) (*Entry, error) {
+	current := v.root
+	for _, component := range strings.Split(strings.Trim(name, "/"), "/") {
+		if component == "" || component == "." {
+			continue
+		}
+		children, err := v.ReadDir(current)
+		if err != nil {
+			return nil, err
+		}
+		var next *Entry
+		for _, child := range children {
+			if child.Name == component || (!v.Joliet && stripVersion(child.Name) == component) {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			return nil, fmt.Errorf("'%s': %w", name, errNotFound)
+		}
+		current = next
+	}
+	return current, nil
+}
+
+// errNotFound is wrapped by Lookup when a path component doesn't exist.
+var errNotFound = errors.New("no such file or directory")
+
+// ReadPathTable reads and decodes the L-Type (or M-Type if mType) path table of the volume.
+func (v *Volume) ReadPathTable(mType bool) ([]PathTableRecord, error) {
+	location := v.LPathTableLocation
+	order := binary.ByteOrder(binary.LittleEndian)
+	if mType {
+		location = v.MPathTableLocation
+		order = binary.BigEndian
+	}
+	table, err := v.readMetadataExtent(location, int64(v.PathTableSize), maxPathTableSize)
+	if err != nil {
+		return nil, fmt.Errorf("reading path table at LBA %d: %w", location, err)
+	}
+
+	var records []PathTableRecord
+	for offset := 0; offset < len(table); {
+		if offset+ptRecFixedPartSize > len(table) {
+			return nil, fmt.Errorf("truncated path table record at offset %d", offset)
+		}
+		identifierLen := int(table[offset])
+		recordLen := ptRecFixedPartSize + identifierLen
+		if identifierLen%2 != 0 {
+			recordLen++
+		}
+		if identifierLen == 0 || offset+recordLen > len(table) {
+			return nil, fmt.Errorf("invalid path table record at offset %d (identifier length %d)", offset, identifierLen)
+		}
+		identifier := table[offset+ptRecFixedPartSize : offset+ptRecFixedPartSize+identifierLen]
+		rec := PathTableRecord{
+			LocationExtent:        order.Uint32(table[offset+2 : offset+6]),
+			ParentDirectoryNumber: order.Uint16(table[offset+6 : offset+8]),
+		}
+		if !(identifierLen == 1 && identifier[0] == 0x00) { // root is recorded as 0x00
+			rec.Identifier = v.decodeIdentifier(identifier)
+		}
+		records = append(records, rec)
+		offset += recordLen
+	}
+	return records, nil
+}
+
+// readMetadataExtent reads the size bytes recorded at lba (a directory extent or a path table).
+// : size comes from the image, it is checked against limit and the end of the image before allocating.
+func (v *Volume) readMetadataExtent(lba uint32, size int64, limit int64) ([]byte, error) {
+	if size > limit {
+		return nil, fmt.Errorf("recorded size %d exceeds %d bytes", size, limit)
+	}
+	imageSize := v.img.size
+	if imageSize < 0 {
+		imageSize = int64(v.VolumeSpaceSize) * SectorSize
+	}
+	if end := int64(lba)*SectorSize + size; end > imageSize {
+		return nil, fmt.Errorf("%d bytes end at offset %d, beyond the image (%d bytes)", size, end, imageSize)
+	}
+	data := make([]byte, size)
+	if _, err := v.img.r.ReadAt(data, int64(lba)*SectorSize); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// IsDir reports whether the entry is a directory.
+func (e *Entry) IsDir() bool { return e.FileFlags&FileFlagDirectory != 0 }
+
+// IsHidden reports whether the entry has the Hidden (existence) flag set.
+func (e *Entry) IsHidden() bool { return e.FileFlags&FileFlagHidden != 0 }
+
+// Reader returns a reader over the file's data, served directly from the image.
+// : the extents of a multi-extent file read as one stream.
+func (e *Entry) Reader() *io.SectionReader {
+	contiguous := true
+	for k := 1; k < len(e.extents); k++ {
+		prev := e.extents[k-1]
+		if int64(e.extents[k].lba)*SectorSize != int64(prev.lba)*SectorSize+prev.size {
+			contiguous = false
+		}
+	}
+	if contiguous {
+		return io.NewSectionReader(e.vol.img.r, int64(e.LBA)*SectorSize, e.Size)
+	}
+	return io.NewSectionReader(&extentReader{r: e.vol.img.r, extents: e.extents}, 0, e.Size)
+}
+
+// extentReader serves reads across the (non-contiguous) extents of a multi-extent file.
+type extentReader struct {
+	r       io.ReaderAt
+	extents []fileExtent
+}
+
+// ReadAt implements io.ReaderAt, off is relative to the start of the file data.
+func (er *extentReader) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for _, extent := range er.extents {
+		if len(p) == 0 {
+			break
+		}
+		if off >= extent.size {
+			off -= extent.size
+			continue
+		}
+		chunk := p[:min(int64(len(p)), extent.size-off)]
+		m, err := er.r.ReadAt(chunk, int64(extent.lba)*SectorSize+off)
+		n += m
+		if err != nil && !(errors.Is(err, io.EOF) && m == len(chunk)) {
+			return n, err
+		}
+		p, off = p[m:], 0
+	}
+	if len(p) > 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// parseDirectoryRecord decodes a single Directory Record (the inverse of marshalDirectoryRecord).
+// : "." and ".." come back with names "\x00" and "\x01".
+func (v *Volume) parseDirectoryRecord(record []byte) (*Entry, error) {
+	fields, identifier, systemUse, err := unmarshalDirectoryRecord(record)
+	if err != nil {
+		return nil, err
+	}
+	e := &Entry{
+		LBA:           fields.LocationExtent,
+		Size:          int64(fields.DataLength),
+		RecordingTime: parseRecordingTime(fields.RecordingTime),
+		FileFlags:     fields.FileFlags,
+		vol:           v,
+	}
+	if len(systemUse) > 0 {
+		e.SystemUse = systemUse
+	}
+	if len(identifier) == 1 && identifier[0] <= 0x01 {
+		e.Name = string(identifier)
+	} else {
+		e.Name = v.decodeIdentifier(identifier)
+	}
+	return e, nil
+}
+
+// unmarshalDirectoryRecord splits a Directory Record into its fixed fields, identifier and System Use area.
+func unmarshalDirectoryRecord(record []byte) (*directoryRecordFields, []byte, []byte, error) {
+	if len(record) < drFixedPartSize+1 {
+		return nil, nil, nil, fmt.Errorf("directory record too short (%d bytes)", len(record))
+	}
+	recordLen := int(record[0])
+	if recordLen > len(record) {
+		return nil, nil, nil, fmt.Errorf("directory record length %d exceeds available %d bytes", recordLen, len(record))
+	}
+	identifierLen := int(record[32])
+	identifierEnd := drFixedPartSize + identifierLen
+	if identifierEnd > recordLen {
+		return nil, nil, nil, fmt.Errorf("identifier length %d overruns directory record of %d bytes", identifierLen, recordLen)
+	}
+
+	fields := &directoryRecordFields{
+		ExtendedAttributeRecordLength: record[1],
+		LocationExtent:                binary.LittleEndian.Uint32(record[2:6]),
+		DataLength:                    binary.LittleEndian.Uint32(record[10:14]),
+		FileFlags:                     record[25],
+		FileUnitSize:                  record[26],
+		InterleaveGapSize:             record[27],
+		VolumeSequenceNumber:          binary.LittleEndian.Uint16(record[28:30]),
+	}
+	copy(fields.RecordingTime[:], record[18:25])
+
+	systemUseStart := identifierEnd
+	if identifierLen%2 == 0 { // padding byte follows even length identifiers
+		systemUseStart++
+	}
+	var systemUse []byte
+	if systemUseStart < recordLen {
+		systemUse = record[systemUseStart:recordLen]
+	}
+	return fields, record[drFixedPartSize:identifierEnd], systemUse, nil
+}
+
+// decodeIdentifier decodes a file or directory identifier for this volume's character set.
+func (v *Volume) decodeIdentifier(identifier []byte) string {
+	if v.Joliet {
+		return decodeUTF16BE(identifier)
+	}
+	return string(identifier)
+}
+
+// decodeUTF16BE decodes UCS-2 / UTF-16 Big Endian bytes (the inverse of encodeUTF16BE).
+func decodeUTF16BE(b []byte) string {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = binary.BigEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(units))
+}
+
+// decodeVolumeString trims the space padding of a d-/a-character volume descriptor field.
+func decodeVolumeString(b []byte) string {
+	return strings.TrimRight(string(b), " \x00")
+}
+
+// decodeJolietVolumeString decodes and trims a UCS-2BE volume descriptor field.
+func decodeJolietVolumeString(b []byte) string {
+	return strings.TrimRight(decodeUTF16BE(b), " \x00")
+}
+
+// stripVersion removes a trailing ";<n>" version number from an ISO 9660 file identifier.
+// : a trailing dot left by names without extension ("README.;1") is dropped as well.
+func stripVersion(name string) string {
+	if i := strings.LastIndexByte(name, ';'); i != -1 {
+		name = name[:i]
+		if strings.HasSuffix(name, ".") && len(name) > 1 {
+			name = name[:len(name)-1]
+		}
+	}
+	return name
+}
+
+// parseRecordingTime decodes the 7-byte Directory Record date and time (ECMA-119 Section 9.1.5).
+func parseRecordingTime(t [7]byte) time.Time {
+	if t == [7]byte{} {
+		return time.Time{}
+	}
+	offset := int(int8(t[6])) * 15 * 60 // 15 minute intervals from GMT
+	return time.Date(1900+int(t[0]), time.Month(t[1]), int(t[2]), int(t[3]), int(t[4]), int(t[5]), 0, time.FixedZone("", offset))
+}
+
+// parseVolumeTimestamp decodes a 17-byte volume descriptor timestamp (the inverse of formatTimestamp).
+// : "not specified" and malformed timestamps come back as the zero time.
+func parseVolumeTimestamp(ts []byte) time.Time {
+	digits := string(ts[:16])
+	if strings.Trim(digits, "0") == "" {
+		return time.Time{}
+	}
+	var fields [7]int
+	widths := [7]int{4, 2, 2, 2, 2, 2, 2} // YYYY MM DD HH MM SS hh
+	pos := 0
+	for i, width := range widths {
+		n, err := strconv.Atoi(digits[pos : pos+width])
+		if err != nil {
+			return time.Time{}
+		}
+		fields[i] = n
+		pos += width
+	}
+	offset := int(int8(ts[16])) * 15 * 60
+	return time.Date(fields[0], time.Month(fields[1]), fields[2], fields[3], fields[4], fields[5], fields[6]*10_000_000, time.FixedZone("", offset))
+}
@@ -0,0 +1,170 @@
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// TestReaderRejectsHostileSizes checks sizes recorded in a corrupt image are bounded before the reader
+// allocates buffers for them.
+func TestReaderRejectsHostileSizes(t *testing.T) {
+	image := buildImage(t, writeSourceTree(t, map[string]string{"a.txt": "a"}), nil)
+	pvd := SystemAreaNumSectors * SectorSize
+
+	for _, size := range []uint32{0xFFFFF800, 64 << 20} { // beyond the limit, within it but beyond the image
+		corrupt := append([]byte(nil), image...)
+		rootRecord := corrupt[pvd+156 : pvd+190]
+		binary.LittleEndian.PutUint32(rootRecord[10:14], size)
+		binary.BigEndian.PutUint32(rootRecord[14:18], size)
+		binary.LittleEndian.PutUint32(corrupt[pvd+132:pvd+136], size) // path table size
+		binary.BigEndian.PutUint32(corrupt[pvd+136:pvd+140], size)
+
+		vol := openImage(t, corrupt).Primary()
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		if _, err := vol.ReadDir(vol.Root()); err == nil {
+			t.Errorf("ReadDir of a root directory of %d bytes succeeded", size)
+		}
+		if _, err := vol.ReadPathTable(false); err == nil {
+			t.Errorf("ReadPathTable of a path table of %d bytes succeeded", size)
+		}
+		runtime.ReadMemStats(&after)
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+			t.Errorf("reading %d byte extents allocated %d bytes", size, allocated)
+		}
+	}
+}
+
+// TestMultiExtentFile builds a sparse file of three extents into a VirtualImage (nothing of its size is
+// written), checks the Directory Records of each extent and that ReadDir folds them into one entry whose
+// reader crosses the extent boundaries.
+func TestMultiExtentFile(t *testing.T) {
+	const size = 2*maxExtentSize + SectorSize + 5
+	source := writeSourceTree(t, map[string]string{"small.txt": "small"})
+	bigPath := filepath.Join(source, "big.bin")
+	big, err := os.Create(bigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	markers := map[int64]string{0: "first", maxExtentSize - 3: "boundary", 2*maxExtentSize - 1: "second", size - 4: "last"}
+	for off, marker := range markers {
+		if _, err := big.WriteAt([]byte(marker), off); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := big.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(bigPath, time.Now().Add(time.Hour), sourceTreeTime); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := rockRidgeOptions()
+	opts.MultiExtent = true
+	v, err := NewBuilder(source, "", opts).VirtualImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	img, err := Open(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the records of the ISO9660 tree, each extent with the same Rock Ridge fields
+	root := img.Primary().Root()
+	listing := make([]byte, root.Size)
+	if _, err := v.ReadAt(listing, int64(root.LBA)*SectorSize); err != nil {
+		t.Fatal(err)
+	}
+	metadata := make([]byte, int64(root.LBA+1)*SectorSize) // continuation areas of the short names are unused
+	wantSizes := []uint32{maxExtentSize, maxExtentSize, SectorSize + 5}
+	var records []*directoryRecordFields
+	for offset := 0; offset < len(listing); {
+		if listing[offset] == 0 {
+			offset = (offset/SectorSize + 1) * SectorSize
+			continue
+		}
+		record := listing[offset : offset+int(listing[offset])]
+		offset += len(record)
+		fields, identifier, systemUse, err := unmarshalDirectoryRecord(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(identifier) != "BIG.BIN;1" {
+			continue
+		}
+		entries, _ := readSUSP(t, metadata, systemUse)
+		if name := rrName(t, entries); name != "big.bin" || len(susPayloads(entries, "PX")) != 1 {
+			t.Errorf("extent %d: NM name %q, %d PX entries", len(records), name, len(susPayloads(entries, "PX")))
+		}
+		records = append(records, fields)
+	}
+	if len(records) != len(wantSizes) {
+		t.Fatalf("%d records for a file of %d bytes, want %d", len(records), int64(size), len(wantSizes))
+	}
+	for k, r := range records {
+		isLast := k == len(records)-1
+		if multiExtent := r.FileFlags&FileFlagMultiExtent != 0; multiExtent == isLast {
+			t.Errorf("extent %d: multi-extent flag %t", k, multiExtent)
+		}
+		if r.DataLength != wantSizes[k] || r.LocationExtent != records[0].LocationExtent+uint32(k)*(maxExtentSize/SectorSize) {
+			t.Errorf("extent %d: %d bytes at LBA %d", k, r.DataLength, r.LocationExtent)
+		}
+	}
+
+	for _, vol := range []*Volume{img.Primary(), img.Joliet()} {
+		entries, err := vol.ReadDir(vol.Root())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 2 {
+			t.Fatalf("%d entries in the root, want big.bin and small.txt", len(entries))
+		}
+		e := entries[0]
+		if e.Size != size || e.FileFlags&FileFlagMultiExtent != 0 || len(e.extents) != len(wantSizes) {
+			t.Fatalf("'%s' folded into %d bytes over %d extents, flags %#x", e.Name, e.Size, len(e.extents), e.FileFlags)
+		}
+		r := e.Reader()
+		for off, marker := range markers {
+			got := make([]byte, len(marker))
+			if _, err := r.ReadAt(got, off); err != nil || string(got) != marker {
+				t.Errorf("'%s' at %d: %q, %v, want %q", e.Name, off, got, err, marker)
+			}
+		}
+	}
+}
+
+// TestExtentReader reads across extents recorded out of order, which Entry.Reader can't serve as one section.
+func TestExtentReader(t *testing.T) {
+	image := make([]byte, 8*SectorSize)
+	for i := range image {
+		image[i] = byte(i * 7 / SectorSize)
+	}
+	extents := []fileExtent{{lba: 4, size: SectorSize + 10}, {lba: 1, size: SectorSize}, {lba: 7, size: 7}}
+	var want []byte
+	for _, x := range extents {
+		want = append(want, image[int64(x.lba)*SectorSize:int64(x.lba)*SectorSize+x.size]...)
+	}
+	e := &Entry{LBA: 4, Size: int64(len(want)), vol: &Volume{img: &Image{r: bytes.NewReader(image)}}, extents: extents}
+	r := e.Reader()
+	for _, off := range []int64{0, 5, SectorSize + 9, SectorSize + 10, 2*SectorSize + 9, int64(len(want)) - 1} {
+		for _, n := range []int64{1, 2, SectorSize, 3 * SectorSize} {
+			p := make([]byte, n)
+			got, err := r.ReadAt(p, off)
+			end := min(off+n, int64(len(want)))
+			if int64(got) != end-off || (err != nil) != (end-off < n) || !bytes.Equal(p[:got], want[off:end]) {
+				t.Errorf("ReadAt(%d bytes, %d) = %d, %v", n, off, got, err)
+			}
+		}
+	}
+	if got, err := io.ReadAll(e.Reader()); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("ReadAll: %d bytes, %v", len(got), err)
+	}
+}
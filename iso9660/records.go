@@ -10,13 +10,13 @@ import (
 	"time"
 )
 
-// marshalDirectoryRecord converts directoryRecordFields and an identifier into a full DR byte slice.
-func marshalDirectoryRecord(fields *directoryRecordFields, identifier []byte) ([]byte, error) {
+// marshalDirectoryRecord converts directoryRecordFields, an identifier and an optional
+// System Use field (Rock Ridge) into a full DR byte slice.
+func marshalDirectoryRecord(fields *directoryRecordFields, identifier []byte, systemUse []byte) ([]byte, error) {
 	identifierLen := byte(len(identifier))
-	// base DR size (33) + identifier length
-	recordLen := drFixedPartSize + int(identifierLen)
-	if recordLen%2 != 0 { // DR length must be even
-		recordLen++
+	recordLen := calculateDirectoryRecordSize(identifier, len(systemUse))
+	if recordLen > drMaxSize {
+		return nil, fmt.Errorf("directory record length %d exceeds %d bytes", recordLen, drMaxSize)
 	}
 
 	buf := make([]byte, recordLen)
@@ -39,6 +39,8 @@ func marshalDirectoryRecord(fields *directoryRecordFields, identifier []byte) ([
 	buf[32] = identifierLen // len of File Identifier
 	copy(buf[33:], identifier)
 	// padding byte (if any, due to identifierLen being odd, making overall DR length odd before final padding) is zero-filled by make().
+	// System Use field starts right after it (ECMA-119 9.1.13).
+	copy(buf[calculateDirectoryRecordSize(identifier, 0):], systemUse)
 	return buf, nil
 }
 
@@ -52,8 +54,11 @@ func (b *ISOBuilder) populateDirectoryRecordFields(drFields *directoryRecordFiel
 	drFields.DataLength = extentOrDataSize
 
 	var fileTime time.Time
-	nowUTC := time.Now().UTC() // fallback
-	if targetEntry != nil && targetEntry.diskPath != "" {
+	nowUTC := b.buildTime // fallback
+	if targetEntry != nil && !targetEntry.modTime.IsZero() {
+		// captured while scanning, also right for symlinks whose target may not exist
+		fileTime = targetEntry.modTime.UTC()
+	} else if targetEntry != nil && targetEntry.diskPath != "" {
 		// "." and ".." entries, use the ModTime of the directory they represent
 		// for root's "." or "..", targetEntry might be the root entry itself.
 		// other entries, it's the actual file/dir.
@@ -61,7 +66,7 @@ func (b *ISOBuilder) populateDirectoryRecordFields(drFields *directoryRecordFiel
 		if err == nil {
 			fileTime = statInfo.ModTime().UTC()
 		} else {
-			log.Printf("Warning: Stat '%s' for timestamp: %v. Using current time.", targetEntry.diskPath, err)
+			log.Printf("Warning: Stat '%s' for timestamp: %v. Using the build time.", targetEntry.diskPath, err)
 			fileTime = nowUTC
 		}
 	} else {
@@ -70,17 +75,11 @@ func (b *ISOBuilder) populateDirectoryRecordFields(drFields *directoryRecordFiel
 		fileTime = nowUTC
 	}
 
-	drFields.RecordingTime[0] = byte(fileTime.Year() - 1900)
-	drFields.RecordingTime[1] = byte(fileTime.Month())
-	drFields.RecordingTime[2] = byte(fileTime.Day())
-	drFields.RecordingTime[3] = byte(fileTime.Hour())
-	drFields.RecordingTime[4] = byte(fileTime.Minute())
-	drFields.RecordingTime[5] = byte(fileTime.Second())
-	drFields.RecordingTime[6] = 0 // GMT Offset (0 for UTC or local time unknown as per ECMA-119 9.1.5)
+	drFields.RecordingTime = formatRecordingTime(fileTime)
 
 	var baseFileFlags byte
 	if targetEntry.isDir {
-		baseFileFlags |= 0x02 // bit 1: Directory
+		baseFileFlags |= FileFlagDirectory // bit 1: Directory
 	}
 	// other flags like Associated (0x04), Record attributes (0x08, 0x10) not used here.
 	// implement them yourself...
@@ -91,7 +90,7 @@ func (b *ISOBuilder) populateDirectoryRecordFields(drFields *directoryRecordFiel
 	// this ensures that the "Hidden" flag is only set on the DR for the actual file/directory entry,
 	if drIDNameToEncode != "." && drIDNameToEncode != ".." && drIDNameToEncode != "" && drIDNameToEncode != "\x00" {
 		if targetEntry.isHidden {
-			finalFileFlags |= 0x01 // set to 0x01 for hidden
+			finalFileFlags |= FileFlagHidden // bit 0: Hidden
 		}
 	}
 	drFields.FileFlags = finalFileFlags
@@ -102,32 +101,32 @@ func (b *ISOBuilder) populateDirectoryRecordFields(drFields *directoryRecordFiel
 }
 
 // createDirectoryRecordBytes creates the full byte slice for a Directory Record.
-// : populates fields and then marshals them with the appropriate identifier.
-func (b *ISOBuilder) createDirectoryRecordBytes(extentLBA, extentOrDataSize uint32, drIDNameToEncode string, targetEntry *fileEntry, isJoliet bool) ([]byte, error) {
+// : populates fields and then marshals them with the appropriate identifier and System Use field.
+func (b *ISOBuilder) createDirectoryRecordBytes(extentLBA, extentOrDataSize uint32, drIDNameToEncode string, targetEntry *fileEntry, kind treeKind, systemUse []byte) ([]byte, error) {
 	var drFields directoryRecordFields
 	b.populateDirectoryRecordFields(&drFields, extentLBA, extentOrDataSize, drIDNameToEncode, targetEntry)
 
-	isTargetEntryRoot := (targetEntry.pathTableDirNum == 1)
+	isTargetEntryRoot := targetEntry.isRoot
 
 	var isNameForRootItself bool
 	if isTargetEntryRoot {
-		if isJoliet && (drIDNameToEncode == "\x00" || drIDNameToEncode == ".") { // Joliet root DR (\x00) or root's "."
+		if kind == treeJoliet && (drIDNameToEncode == "\x00" || drIDNameToEncode == ".") { // Joliet root DR (\x00) or root's "."
 			isNameForRootItself = true
-		} else if !isJoliet && (drIDNameToEncode == "" || drIDNameToEncode == ".") { // ISO root DR ("") or root's "."
+		} else if kind != treeJoliet && (drIDNameToEncode == "" || drIDNameToEncode == ".") { // ISO/Enhanced root DR ("") or root's "."
 			isNameForRootItself = true
 		}
 	}
 	// non-root entries, or for names like "..", isNameForRootItself remains false.
 
-	identifierBytes := getDRIdentifierBytes(drIDNameToEncode, isJoliet, isNameForRootItself)
-	return marshalDirectoryRecord(&drFields, identifierBytes)
+	identifierBytes := getDRIdentifierBytes(drIDNameToEncode, kind, isNameForRootItself)
+	return marshalDirectoryRecord(&drFields, identifierBytes, systemUse)
 }
 
 // getDRIdentifierBytes returns the byte representation for a Directory Record identifier,
 // handling special cases for root, ".", and "..".
 // isIdentifierForRootItself: true if this identifier is for the root directory itself (e.g., PVD/SVD root DR, or root's "." entry).
-func getDRIdentifierBytes(name string, isJoliet bool, isIdentifierForRootItself bool) []byte {
-	if isJoliet {
+func getDRIdentifierBytes(name string, kind treeKind, isIdentifierForRootItself bool) []byte {
+	if kind == treeJoliet {
 		if isIdentifierForRootItself && (name == "\x00" || name == ".") {
 			return []byte{0x00}
 		}
@@ -141,114 +140,128 @@ func getDRIdentifierBytes(name string, isJoliet bool, isIdentifierForRootItself
 		return encodeUTF16BE(name)
 	}
 
-	// ISO9660
+	// ISO9660 and Enhanced (ISO 9660:1999 names are recorded as is)
 	if name == "." || (isIdentifierForRootItself && name == "") { // "" is placeholder for root in PVD
 		return []byte{0x00}
 	}
 	if name == ".." {
 		return []byte{0x01}
 	}
-	// ISO9660 name
+	// ISO9660 / Enhanced name
 	return []byte(name)
 }
 
-// calculateDirectoryRecordSize calculates the total byte length of a Directory Record, including padding.
-func calculateDirectoryRecordSize(identifierBytes []byte) int {
+// calculateDirectoryRecordSize calculates the total byte length of a Directory Record, including padding
+// and a System Use field of systemUseLen bytes.
+func calculateDirectoryRecordSize(identifierBytes []byte, systemUseLen int) int {
 	length := drFixedPartSize + len(identifierBytes) // base + len(identifier)
-	if length%2 != 0 {                               // DRs must be an even number of bytes
+	if length%2 != 0 {                               // padding field after even length identifiers
+		length++
+	}
+	length += systemUseLen
+	if length%2 != 0 { // DRs must be an even number of bytes
 		length++
 	}
 	return length
 }
 
-// createDirectoryListing generates the byte stream for a directory's content (., .., and children DRs).
-func (b *ISOBuilder) createDirectoryListing(dirEntryIndex int, isJoliet bool) ([]byte, error) {
+// nextDirectoryRecordOffset returns the offset in a directory listing of offset bytes at which the next
+// record of recordSize bytes starts.
+// : a record never spans a sector boundary, one that doesn't fit in the rest of the sector starts the next
+// : sector, the unused bytes are zero (ECMA-119 Section 6.8.1.1). Readers skip them as a record of length 0.
+func nextDirectoryRecordOffset(offset, recordSize int) int {
+	if offset%SectorSize+recordSize > SectorSize {
+		return (offset/SectorSize + 1) * SectorSize
+	}
+	return offset
+}
+
+// sortedChildren returns the children of a directory in the order of its listing: sorted by their name in the tree.
+// : sizing (calculateSingleDirectoryExtentSizeBytes) and writing must pack the records in the same order,
+// : the padding before a record that would cross a sector boundary depends on the records before it.
+func (b *ISOBuilder) sortedChildren(dirIndex int, kind treeKind) []int {
+	_, children := b.treeLinks(dirIndex, kind)
+	sorted := append([]int(nil), children...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return b.fileEntries[sorted[i]].nodes[kind].name < b.fileEntries[sorted[j]].nodes[kind].name
+	})
+	return sorted
+}
+
+// createDirectoryListing generates the byte stream for a directory's content (., .., and children DRs) in the given tree.
+func (b *ISOBuilder) createDirectoryListing(dirEntryIndex int, kind treeKind) ([]byte, error) {
 	buffer := new(bytes.Buffer)
 	currentDir := b.fileEntries[dirEntryIndex]
-
-	var selfLBA, selfExtentSizeBytes uint32
-	if isJoliet {
-		selfLBA, selfExtentSizeBytes = currentDir.jolietSector, currentDir.jolietSize
-	} else {
-		selfLBA, selfExtentSizeBytes = currentDir.iso9660Sector, currentDir.iso9660Size
-	}
+	self := currentDir.nodes[kind]
 
 	// "." entry (points to the current directory itself)
-	dotDRBytes, err := b.createDirectoryRecordBytes(selfLBA, selfExtentSizeBytes, ".", &currentDir, isJoliet)
+	var dotSystemUse []byte
+	if kind == treeISO9660 {
+		dotSystemUse = b.rockRidgeSystemUse(rrRecordKey{dirIndex: dirEntryIndex, entryIndex: dirEntryIndex, role: rrRoleSelf})
+	}
+	dotDRBytes, err := b.createDirectoryRecordBytes(self.sector, self.size, ".", &currentDir, kind, dotSystemUse)
 	if err != nil {
-		return nil, fmt.Errorf("creating '.' DR for '%s' (joliet: %t): %w", currentDir.isoPath, isJoliet, err)
+		return nil, fmt.Errorf("creating '.' DR for '%s' (%s): %w", currentDir.isoPath, kind, err)
 	}
-	expectedDotDRLen := calculateDirectoryRecordSize(getDRIdentifierBytes(".", isJoliet, currentDir.pathTableDirNum == 1))
+	expectedDotDRLen := calculateDirectoryRecordSize(getDRIdentifierBytes(".", kind, currentDir.isRoot), len(dotSystemUse))
 	if len(dotDRBytes) != expectedDotDRLen {
-		log.Panicf("CriticalDRLenMismatch: '.' in '%s'(j:%t): Marshalled %d != Expected %d", currentDir.isoPath, isJoliet, len(dotDRBytes), expectedDotDRLen)
+		log.Panicf("CriticalDRLenMismatch: '.' in '%s'(%s): Marshalled %d != Expected %d", currentDir.isoPath, kind, len(dotDRBytes), expectedDotDRLen)
 	}
 	buffer.Write(dotDRBytes)
 
 	// ".." entry (points to the parent directory)
-	parentDir := b.fileEntries[currentDir.parentIndex] // for root, parentIndex is 0 (self)
-	var parentLBA, parentExtentSizeBytes uint32
-	if isJoliet {
-		parentLBA, parentExtentSizeBytes = parentDir.jolietSector, parentDir.jolietSize
-	} else {
-		parentLBA, parentExtentSizeBytes = parentDir.iso9660Sector, parentDir.iso9660Size
+	// for root, parentIndex is 0 (self); the ISO9660 tree follows relocations
+	parentIndex, children := b.treeLinks(dirEntryIndex, kind)
+	parentDir := b.fileEntries[parentIndex]
+	parent := parentDir.nodes[kind]
+	var dotDotSystemUse []byte
+	if kind == treeISO9660 {
+		dotDotSystemUse = b.rockRidgeSystemUse(rrRecordKey{dirIndex: dirEntryIndex, entryIndex: parentIndex, role: rrRoleParent})
 	}
 	// targetEntry for ".." is the parent directory.
-	dotDotDRBytes, err := b.createDirectoryRecordBytes(parentLBA, parentExtentSizeBytes, "..", &parentDir, isJoliet)
+	dotDotDRBytes, err := b.createDirectoryRecordBytes(parent.sector, parent.size, "..", &parentDir, kind, dotDotSystemUse)
 	if err != nil {
-		return nil, fmt.Errorf("creating '..' DR for '%s' (joliet: %t): %w", currentDir.isoPath, isJoliet, err)
+		return nil, fmt.Errorf("creating '..' DR for '%s' (%s): %w", currentDir.isoPath, kind, err)
 	}
-	expectedDotDotDRLen := calculateDirectoryRecordSize(getDRIdentifierBytes("..", isJoliet, false)) // ".." is never root itself in this context
+	expectedDotDotDRLen := calculateDirectoryRecordSize(getDRIdentifierBytes("..", kind, false), len(dotDotSystemUse)) // ".." is never root itself in this context
 	if len(dotDotDRBytes) != expectedDotDotDRLen {
-		log.Panicf("CriticalDRLenMismatch: '..' in '%s'(j:%t): Marshalled %d != Expected %d", currentDir.isoPath, isJoliet, len(dotDotDRBytes), expectedDotDotDRLen)
+		log.Panicf("CriticalDRLenMismatch: '..' in '%s'(%s): Marshalled %d != Expected %d", currentDir.isoPath, kind, len(dotDotDRBytes), expectedDotDotDRLen)
 	}
 	buffer.Write(dotDotDRBytes)
 
-	// entries for children, sorted alphabetically by their respective standard's name
-	if len(currentDir.children) > 0 {
-		childrenEntries := make([]fileEntry, len(currentDir.children))
-		for i, idx := range currentDir.children {
-			childrenEntries[i] = b.fileEntries[idx]
-		}
-		sort.Slice(childrenEntries, func(i, j int) bool {
-			if isJoliet {
-				return childrenEntries[i].jolietName < childrenEntries[j].jolietName
-			}
-			return childrenEntries[i].iso9660Name < childrenEntries[j].iso9660Name
-		})
-
-		for _, childEntry := range childrenEntries {
-			var childLBA, childSizeOrDataLen uint32
-			var childRecordName string
-			var expectedChildDRLen int
-
-			if childEntry.isDir {
-				if isJoliet {
-					childLBA, childSizeOrDataLen, childRecordName = childEntry.jolietSector, childEntry.jolietSize, childEntry.jolietName
-					expectedChildDRLen = childEntry.actualJolietDrSize
-				} else {
-					childLBA, childSizeOrDataLen, childRecordName = childEntry.iso9660Sector, childEntry.iso9660Size, childEntry.iso9660Name
-					expectedChildDRLen = childEntry.actualISO9660DrSize
-				}
-			} else {
-				// files -> LBA and data length are the same for ISO9660 and Joliet
-				childLBA, childSizeOrDataLen = childEntry.iso9660Sector, childEntry.iso9660Size
-				if isJoliet {
-					childRecordName = childEntry.jolietName
-					expectedChildDRLen = childEntry.actualJolietDrSize
-				} else {
-					childRecordName = childEntry.iso9660Name
-					expectedChildDRLen = childEntry.actualISO9660DrSize
-				}
+	// entries for children, sorted by their name in this tree
+	if len(children) > 0 {
+		for _, childIndex := range b.sortedChildren(dirEntryIndex, kind) {
+			childEntry := b.fileEntries[childIndex]
+			child := childEntry.nodes[kind] // files -> LBA is the same in every tree
+			var childSystemUse []byte
+			if kind == treeISO9660 {
+				childSystemUse = b.rockRidgeSystemUse(rrRecordKey{dirIndex: dirEntryIndex, entryIndex: childIndex, role: rrRoleChild})
 			}
 
-			childDRBytes, err := b.createDirectoryRecordBytes(childLBA, childSizeOrDataLen, childRecordName, &childEntry, isJoliet)
-			if err != nil {
-				return nil, fmt.Errorf("creating child DR for '%s' in '%s' (joliet: %t): %w", childEntry.isoPath, currentDir.isoPath, isJoliet, err)
+			// files too large for one Data Length get a DR per extent, all but the last flagged multi-extent.
+			// every DR repeats the Rock Ridge fields (the CE, if any, points to the same continuation area):
+			// Linux isofs takes the attributes from the first DR but the NM name from the last one in readdir.
+			extents := []uint32{child.size}
+			if !childEntry.isDir {
+				extents = childEntry.extents()
 			}
-			if len(childDRBytes) != expectedChildDRLen {
-				log.Panicf("CriticalDRLenMismatch: Child '%s'(orig:'%s',isDir:%t,j:%t) in '%s': Marshalled %d != Expected %d. IDForDR:'%s'(%x)", childEntry.isoPath, childEntry.originalName, childEntry.isDir, isJoliet, currentDir.isoPath, len(childDRBytes), expectedChildDRLen, childRecordName, getDRIdentifierBytes(childRecordName, isJoliet, false))
+			for k, extentSize := range extents {
+				extentLBA := child.sector + uint32(k)*(maxExtentSize/SectorSize)
+				childDRBytes, err := b.createDirectoryRecordBytes(extentLBA, extentSize, child.name, &childEntry, kind, childSystemUse)
+				if err != nil {
+					return nil, fmt.Errorf("creating child DR for '%s' in '%s' (%s): %w", childEntry.isoPath, currentDir.isoPath, kind, err)
+				}
+				if len(childDRBytes) != child.drSize {
+					log.Panicf("CriticalDRLenMismatch: Child '%s'(orig:'%s',isDir:%t,%s) in '%s': Marshalled %d != Expected %d. IDForDR:'%s'(%x)", childEntry.isoPath, childEntry.originalName, childEntry.isDir, kind, currentDir.isoPath, len(childDRBytes), child.drSize, child.name, getDRIdentifierBytes(child.name, kind, false))
+				}
+				if k < len(extents)-1 {
+					childDRBytes[25] |= FileFlagMultiExtent // File Flags byte (ECMA-119 Section 9.1.6)
+				}
+				// zero-fill the rest of the sector rather than let the record cross into the next one
+				buffer.Write(make([]byte, nextDirectoryRecordOffset(buffer.Len(), len(childDRBytes))-buffer.Len()))
+				buffer.Write(childDRBytes)
 			}
-			buffer.Write(childDRBytes)
 		}
 	}
 	return buffer.Bytes(), nil
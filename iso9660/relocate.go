@@ -0,0 +1,99 @@
+package iso9660
+
+import (
+	"io/fs"
+	"log"
+)
+
+// ECMA-119 6.8.2.1 limits the ISO9660 directory hierarchy to 8 levels. Deeper directories are moved
+// into RR_MOVED in the ISO9660 tree only, leaving a placeholder behind. Rock Ridge CL (placeholder),
+// PL (".." of the moved directory) and RE (its record in RR_MOVED) entries let RRIP readers rebuild
+// the real hierarchy, while the Joliet tree keeps the natural structure (parentIndex/children).
+// Without Rock Ridge nothing would record where a moved directory belongs, deep directories then stay
+// in place (as mkisofs does without -R): most readers accept them, strict ones may not.
+
+// buildISO9660Hierarchy derives the ISO9660 tree (isoParentIndex/isoChildren) from the scanned tree,
+// relocating directories deeper than maxISO9660DirLevel when Rock Ridge is on.
+func (b *ISOBuilder) buildISO9660Hierarchy() {
+	deepDirs := 0
+	for i := range b.fileEntries {
+		b.fileEntries[i].isoParentIndex = b.fileEntries[i].parentIndex
+		b.fileEntries[i].isoChildren = append([]int(nil), b.fileEntries[i].children...)
+		if b.fileEntries[i].isDir && b.fileEntries[i].level > maxISO9660DirLevel {
+			deepDirs++
+		}
+	}
+	b.rrMovedIndex = 0
+	if b.options.RockRidge {
+		b.relocateDeepDirectories(0, 0)
+	} else if deepDirs > 0 {
+		log.Printf("Warning: %d directories are deeper than the 8 levels of ISO 9660, recorded in place (Options.RockRidge relocates them into %s)", deepDirs, rrMovedName)
+	}
+}
+
+// relocateDeepDirectories walks the scanned tree below dirIndex, which sits at isoLevel in the ISO9660 tree.
+func (b *ISOBuilder) relocateDeepDirectories(dirIndex int, isoLevel int) {
+	for _, childIndex := range b.fileEntries[dirIndex].children {
+		if !b.fileEntries[childIndex].isDir {
+			continue
+		}
+		childLevel := isoLevel + 1
+		if childLevel > maxISO9660DirLevel {
+			b.relocateDirectory(childIndex)
+			childLevel = 2 // root -> RR_MOVED -> relocated directory
+		}
+		b.relocateDeepDirectories(childIndex, childLevel)
+	}
+}
+
+// relocateDirectory moves a directory into RR_MOVED (created on first use) and leaves a
+// zero-length placeholder in its original parent's ISO9660 listing.
+func (b *ISOBuilder) relocateDirectory(dirIndex int) {
+	if b.rrMovedIndex == 0 {
+		root := b.fileEntries[0]
+		rrMoved := fileEntry{
+			originalName: rrMovedName,
+			isoPath:      "/" + rrMovedName,
+			isDir:        true,
+			level:        1,
+			isoOnly:      true,
+			mode:         fs.ModeDir | 0o555,
+			modTime:      root.modTime,
+			accessTime:   root.accessTime,
+			changeTime:   root.changeTime,
+		}
+		b.fileEntries = append(b.fileEntries, rrMoved)
+		b.rrMovedIndex = len(b.fileEntries) - 1
+		b.fileEntries[0].isoChildren = append(b.fileEntries[0].isoChildren, b.rrMovedIndex)
+	}
+
+	dir := b.fileEntries[dirIndex]
+	placeholder := fileEntry{
+		originalName:      dir.originalName,
+		isoPath:           dir.isoPath,
+		level:             dir.level,
+		parentIndex:       dir.parentIndex,
+		isoParentIndex:    dir.parentIndex,
+		isoOnly:           true,
+		relocatedDirIndex: dirIndex,
+		isHidden:          dir.isHidden,
+		mode:              dir.mode,
+		uid:               dir.uid,
+		gid:               dir.gid,
+		modTime:           dir.modTime,
+		accessTime:        dir.accessTime,
+		changeTime:        dir.changeTime,
+	}
+	b.fileEntries = append(b.fileEntries, placeholder)
+	placeholderIndex := len(b.fileEntries) - 1
+
+	parent := &b.fileEntries[dir.parentIndex]
+	for i, childIndex := range parent.isoChildren {
+		if childIndex == dirIndex {
+			parent.isoChildren[i] = placeholderIndex
+			break
+		}
+	}
+	b.fileEntries[dirIndex].isoParentIndex = b.rrMovedIndex
+	b.fileEntries[b.rrMovedIndex].isoChildren = append(b.fileEntries[b.rrMovedIndex].isoChildren, dirIndex)
+}
@@ -0,0 +1,30 @@
+package iso9660
+
+import "testing"
+
+// TestRelocation checks directories deeper than 8 levels move into RR_MOVED with Rock Ridge only,
+// the Joliet tree keeping the natural hierarchy either way.
+func TestRelocation(t *testing.T) {
+	source := writeSourceTree(t, map[string]string{"a/b/c/d/e/f/g/h/i/deep.txt": "deep"})
+
+	for _, tc := range []struct {
+		opts     *Options
+		isoPath  string // of deep.txt in the ISO9660 tree
+		relocate bool
+	}{
+		{DefaultOptions(), "A/B/C/D/E/F/G/H/I/DEEP.TXT", false},
+		{rockRidgeOptions(), "RR_MOVED/H/I/DEEP.TXT", true},
+	} {
+		img := openImage(t, buildImage(t, source, tc.opts))
+		if _, err := img.Primary().Lookup(tc.isoPath); err != nil {
+			t.Errorf("RockRidge=%t: %v", tc.opts.RockRidge, err)
+		}
+		_, err := img.Primary().Lookup("RR_MOVED")
+		if relocated := err == nil; relocated != tc.relocate {
+			t.Errorf("RockRidge=%t: RR_MOVED present %t, want %t", tc.opts.RockRidge, relocated, tc.relocate)
+		}
+		if _, err := img.Joliet().Lookup("a/b/c/d/e/f/g/h/i/deep.txt"); err != nil {
+			t.Errorf("RockRidge=%t: Joliet tree: %v", tc.opts.RockRidge, err)
+		}
+	}
+}
@@ -0,0 +1,422 @@
+package iso9660
+
+import (
+	"encoding/binary"
+	"io/fs"
+	"log"
+	"strings"
+	"time"
+)
+
+// Rock Ridge (RRIP 1.10) entries are carried in the System Use field of the ISO 9660 tree's
+// Directory Records, following SUSP 1.10. Joliet records never carry a System Use field.
+// : when an entry's System Use field doesn't fit in its DR (255 bytes max), the overflow moves
+// into continuation areas (CE) packed into dedicated sectors after the ISO 9660 directory extents.
+
+// rrRole tells which Directory Record of a listing a System Use field belongs to.
+type rrRole int
+
+const (
+	rrRoleSelf   rrRole = iota // "." record of a directory
+	rrRoleParent               // ".." record of a directory
+	rrRoleChild                // record of a child inside its parent's listing
+)
+
+// rrRecordKey identifies a single Directory Record of the ISO 9660 tree.
+type rrRecordKey struct {
+	dirIndex   int // directory whose listing holds the record
+	entryIndex int // entry described by the record
+	role       rrRole
+}
+
+// susLocation is where a continuation area was placed (CE block / offset / length).
+type susLocation struct {
+	lba    uint32
+	offset uint32
+	length uint32
+}
+
+// rockRidgeEntries returns the SUSP / RRIP entries of one Directory Record, in recording order.
+// : the result's size never depends on LBAs, so it can be used before and after layout.
+func (b *ISOBuilder) rockRidgeEntries(key rrRecordKey) [][]byte {
+	target := &b.fileEntries[key.entryIndex]
+	isRootSelf := key.role == rrRoleSelf && key.dirIndex == 0
+
+	var entries [][]byte
+	if isRootSelf {
+		entries = append(entries, susSP()) // SP must be the first entry of the root's "."
+	}
+	entries = append(entries, rrPX(target, b.linkCount(key.entryIndex)), rrTF(target))
+	if key.role == rrRoleChild {
+		entries = append(entries, rrNM(target.originalName)...)
+	}
+	if target.isSymlink() {
+		entries = append(entries, rrSL(target.symlinkTarget)...)
+	}
+	if target.mode&fs.ModeDevice != 0 { // block and character devices
+		entries = append(entries, rrPN(target.rdev))
+	}
+	switch {
+	case key.role == rrRoleChild && target.relocatedDirIndex != 0:
+		entries = append(entries, rrLocationEntry("CL", b.fileEntries[target.relocatedDirIndex].nodes[treeISO9660].sector))
+	case key.role == rrRoleChild && target.isRelocated():
+		entries = append(entries, susEntry("RE", nil))
+	case key.role == rrRoleParent && b.fileEntries[key.dirIndex].isRelocated():
+		entries = append(entries, rrLocationEntry("PL", b.fileEntries[b.fileEntries[key.dirIndex].parentIndex].nodes[treeISO9660].sector))
+	}
+	if isRootSelf {
+		entries = append(entries, susER())
+	}
+	return entries
+}
+
+// rockRidgeAreas returns the System Use field of a Directory Record split into the inline
+// part (index 0) and its continuation areas.
+// : every area except the last ends with a zeroed CE placeholder, filled by fillContinuationEntry.
+func (b *ISOBuilder) rockRidgeAreas(key rrRecordKey) [][]byte {
+	if !b.options.RockRidge {
+		return nil
+	}
+	var identifierBytes []byte
+	switch key.role {
+	case rrRoleSelf:
+		identifierBytes = getDRIdentifierBytes(".", treeISO9660, key.dirIndex == 0)
+	case rrRoleParent:
+		identifierBytes = getDRIdentifierBytes("..", treeISO9660, false)
+	default:
+		identifierBytes = getDRIdentifierBytes(b.fileEntries[key.entryIndex].nodes[treeISO9660].name, treeISO9660, false)
+	}
+	// DR length must stay even and fit in a single byte
+	inlineLimit := (drMaxSize &^ 1) - calculateDirectoryRecordSize(identifierBytes, 0)
+	return splitSystemUse(b.rockRidgeEntries(key), inlineLimit)
+}
+
+// rockRidgeInlineSize returns the byte length of a record's inline System Use field.
+func (b *ISOBuilder) rockRidgeInlineSize(key rrRecordKey) int {
+	areas := b.rockRidgeAreas(key)
+	if len(areas) == 0 {
+		return 0
+	}
+	return len(areas[0])
+}
+
+// rockRidgeSystemUse returns the finished inline System Use field of a record,
+// with its CE entry pointing at the continuation area assigned during layout.
+func (b *ISOBuilder) rockRidgeSystemUse(key rrRecordKey) []byte {
+	areas := b.rockRidgeAreas(key)
+	if len(areas) == 0 {
+		return nil
+	}
+	locations := b.rrContinuations[key]
+	if len(locations) != len(areas)-1 {
+		log.Panicf("InternalError: Rock Ridge record %+v has %d continuation areas but %d locations", key, len(areas)-1, len(locations))
+	}
+	if len(locations) > 0 {
+		fillContinuationEntry(areas[0], locations[0])
+	}
+	return areas[0]
+}
+
+// forEachRockRidgeRecord calls fn for every Directory Record of the ISO 9660 tree, in listing order.
+func (b *ISOBuilder) forEachRockRidgeRecord(fn func(key rrRecordKey)) {
+	for i := range b.fileEntries {
+		dir := &b.fileEntries[i]
+		if !dir.isDir {
+			continue
+		}
+		fn(rrRecordKey{dirIndex: i, entryIndex: i, role: rrRoleSelf})
+		fn(rrRecordKey{dirIndex: i, entryIndex: dir.isoParentIndex, role: rrRoleParent})
+		for _, childIndex := range dir.isoChildren {
+			fn(rrRecordKey{dirIndex: i, entryIndex: childIndex, role: rrRoleChild})
+		}
+	}
+}
+
+// assignRockRidgeContinuationLBAs packs all continuation areas into sectors starting at startLBA.
+// : an area never crosses a sector boundary (SUSP 5.1 / what the Linux kernel enforces).
+func (b *ISOBuilder) assignRockRidgeContinuationLBAs(startLBA uint32) uint32 {
+	b.rrContinuations = make(map[rrRecordKey][]susLocation)
+	b.lbaRockRidgeContinuation = startLBA
+	if !b.options.RockRidge {
+		b.rrContinuationSectors = 0
+		return startLBA
+	}
+
+	currentLBA, offset := startLBA, uint32(0)
+	used := false
+	b.forEachRockRidgeRecord(func(key rrRecordKey) {
+		areas := b.rockRidgeAreas(key)
+		for _, area := range areas[1:] {
+			if offset+uint32(len(area)) > SectorSize {
+				currentLBA++
+				offset = 0
+			}
+			b.rrContinuations[key] = append(b.rrContinuations[key], susLocation{lba: currentLBA, offset: offset, length: uint32(len(area))})
+			offset += uint32(len(area))
+			used = true
+		}
+	})
+	if used {
+		currentLBA++ // close the last partially filled sector
+	}
+	b.rrContinuationSectors = currentLBA - startLBA
+	return currentLBA
+}
+
+// createRockRidgeContinuationAreas generates the sectors holding all continuation areas.
+func (b *ISOBuilder) createRockRidgeContinuationAreas() []byte {
+	data := make([]byte, b.rrContinuationSectors*SectorSize)
+	b.forEachRockRidgeRecord(func(key rrRecordKey) {
+		areas := b.rockRidgeAreas(key)
+		locations := b.rrContinuations[key]
+		for i, area := range areas[1:] {
+			if i+1 < len(locations) {
+				fillContinuationEntry(area, locations[i+1])
+			}
+			start := (locations[i].lba-b.lbaRockRidgeContinuation)*SectorSize + locations[i].offset
+			copy(data[start:], area)
+		}
+	})
+	return data
+}
+
+// splitSystemUse distributes SUSP entries over the inline System Use field (inlineLimit bytes)
+// and as many continuation areas (one sector max each) as needed.
+// : entries are never split, a CE placeholder is appended to every area that continues.
+func splitSystemUse(entries [][]byte, inlineLimit int) [][]byte {
+	var areas [][]byte
+	var current []byte
+	limit := inlineLimit
+
+	remaining := 0
+	for _, e := range entries {
+		remaining += len(e)
+	}
+	for i := 0; i < len(entries); {
+		if len(current)+remaining <= limit { // everything left fits, no CE needed
+			for _, e := range entries[i:] {
+				current = append(current, e...)
+			}
+			break
+		}
+		if len(current)+len(entries[i])+susCESize <= limit {
+			current = append(current, entries[i]...)
+			remaining -= len(entries[i])
+			i++
+			continue
+		}
+		current = append(current, make([]byte, susCESize)...)
+		areas = append(areas, current)
+		current, limit = nil, SectorSize
+	}
+	return append(areas, current)
+}
+
+// fillContinuationEntry writes the CE entry into the placeholder at the end of area.
+func fillContinuationEntry(area []byte, loc susLocation) {
+	data := make([]byte, 24)
+	putBothEndian32(data[0:8], loc.lba)
+	putBothEndian32(data[8:16], loc.offset)
+	putBothEndian32(data[16:24], loc.length)
+	copy(area[len(area)-susCESize:], susEntry("CE", data))
+}
+
+// linkCount returns the POSIX link count of an entry: 2 + subdirectories for directories.
+// : counted on the natural tree (what Rock Ridge readers reconstruct), placeholders count as their directory.
+func (b *ISOBuilder) linkCount(entryIndex int) uint32 {
+	f := &b.fileEntries[entryIndex]
+	if f.relocatedDirIndex != 0 {
+		f = &b.fileEntries[f.relocatedDirIndex]
+	}
+	if !f.isDir {
+		return 1
+	}
+	children := f.children
+	if f.isoOnly { // RR_MOVED
+		children = f.isoChildren
+	}
+	links := uint32(2)
+	for _, childIndex := range children {
+		if b.fileEntries[childIndex].isDir {
+			links++
+		}
+	}
+	return links
+}
+
+// susEntry assembles a SUSP entry (version 1) from its signature and payload.
+func susEntry(signature string, data []byte) []byte {
+	entry := make([]byte, susHeaderSize+len(data))
+	copy(entry[0:2], signature)
+	entry[2] = byte(len(entry))
+	entry[3] = 1 // entry version
+	copy(entry[susHeaderSize:], data)
+	return entry
+}
+
+// susSP returns the SP entry announcing SUSP, with no bytes skipped (SUSP 5.3).
+func susSP() []byte {
+	return susEntry("SP", []byte{0xBE, 0xEF, 0})
+}
+
+// susER returns the ER entry identifying the RRIP 1.10 extension (SUSP 5.5).
+func susER() []byte {
+	data := []byte{byte(len(rrExtensionID)), byte(len(rrExtensionDescriptor)), byte(len(rrExtensionSource)), 1}
+	data = append(data, rrExtensionID...)
+	data = append(data, rrExtensionDescriptor...)
+	data = append(data, rrExtensionSource...)
+	return susEntry("ER", data)
+}
+
+// rrPX returns the POSIX file attributes entry (RRIP 4.1.1).
+func rrPX(f *fileEntry, links uint32) []byte {
+	data := make([]byte, rrPXSize-susHeaderSize)
+	putBothEndian32(data[0:8], posixMode(f.mode))
+	putBothEndian32(data[8:16], links)
+	putBothEndian32(data[16:24], f.uid)
+	putBothEndian32(data[24:32], f.gid)
+	return susEntry("PX", data)
+}
+
+// rrPN returns the POSIX device number entry (RRIP 4.1.2): high and low 32 bits of the dev_t,
+// as mkisofs writes them (the Linux kernel decodes small major/minor pairs from the low word).
+func rrPN(rdev uint64) []byte {
+	data := make([]byte, 16)
+	putBothEndian32(data[0:8], uint32(rdev>>32))
+	putBothEndian32(data[8:16], uint32(rdev))
+	return susEntry("PN", data)
+}
+
+// rrLocationEntry returns a CL or PL entry (RRIP 4.1.5.1 / 4.1.5.2) pointing at a directory extent.
+func rrLocationEntry(signature string, lba uint32) []byte {
+	data := make([]byte, 8)
+	putBothEndian32(data, lba)
+	return susEntry(signature, data)
+}
+
+// rrTF returns the time stamps entry (RRIP 4.1.6) with modify, access and attributes times.
+func rrTF(f *fileEntry) []byte {
+	const (
+		tfModify     = 0x02
+		tfAccess     = 0x04
+		tfAttributes = 0x08
+	)
+	data := []byte{tfModify | tfAccess | tfAttributes}
+	for _, t := range []time.Time{f.modTime, f.accessTime, f.changeTime} {
+		ts := formatRecordingTime(t)
+		data = append(data, ts[:]...)
+	}
+	return susEntry("TF", data)
+}
+
+// rrNM returns the alternate name entries (RRIP 4.1.4) for a name,
+// split over several NM entries with the CONTINUE flag when longer than one entry can hold.
+func rrNM(name string) [][]byte {
+	const nmContinue = 0x01
+	var entries [][]byte
+	for {
+		chunk, flags := name, byte(0)
+		if len(chunk) > rrNMMaxName {
+			chunk, flags = name[:rrNMMaxName], nmContinue
+		}
+		entries = append(entries, susEntry("NM", append([]byte{flags}, chunk...)))
+		name = name[len(chunk):]
+		if name == "" {
+			return entries
+		}
+	}
+}
+
+// rrSL returns the symbolic link entries (RRIP 4.1.3) for a link target.
+// : component records are packed into as many SL entries as needed, chained with the CONTINUE flag,
+// and components longer than one record are split with the component CONTINUE flag.
+func rrSL(target string) [][]byte {
+	const (
+		slContinue      = 0x01
+		compContinue    = 0x01
+		compCurrent     = 0x02
+		compParent      = 0x04
+		compRoot        = 0x08
+		maxRecordsBytes = susMaxEntry - susHeaderSize - 1 // component area of one SL entry
+		maxContent      = maxRecordsBytes - 2             // content of one component record
+	)
+	var components [][]byte
+	if strings.HasPrefix(target, "/") {
+		components = append(components, []byte{compRoot, 0})
+	}
+	for _, part := range strings.Split(target, "/") {
+		switch part {
+		case "": // leading, trailing or repeated slashes
+		case ".":
+			components = append(components, []byte{compCurrent, 0})
+		case "..":
+			components = append(components, []byte{compParent, 0})
+		default:
+			for len(part) > maxContent {
+				components = append(components, append([]byte{compContinue, maxContent}, part[:maxContent]...))
+				part = part[maxContent:]
+			}
+			components = append(components, append([]byte{0, byte(len(part))}, part...))
+		}
+	}
+
+	var entries [][]byte
+	var records []byte
+	for _, component := range components {
+		if len(records)+len(component) > maxRecordsBytes {
+			entries = append(entries, susEntry("SL", append([]byte{slContinue}, records...)))
+			records = nil
+		}
+		records = append(records, component...)
+	}
+	return append(entries, susEntry("SL", append([]byte{0}, records...)))
+}
+
+// posixMode converts an fs.FileMode into POSIX st_mode bits (file type + permissions).
+func posixMode(m fs.FileMode) uint32 {
+	const (
+		sIFIFO  = 0o010000
+		sIFCHR  = 0o020000
+		sIFDIR  = 0o040000
+		sIFBLK  = 0o060000
+		sIFREG  = 0o100000
+		sIFLNK  = 0o120000
+		sIFSOCK = 0o140000
+		sISUID  = 0o4000
+		sISGID  = 0o2000
+		sISVTX  = 0o1000
+	)
+	mode := uint32(m.Perm())
+	switch {
+	case m.IsDir():
+		mode |= sIFDIR
+	case m&fs.ModeSymlink != 0:
+		mode |= sIFLNK
+	case m&fs.ModeNamedPipe != 0:
+		mode |= sIFIFO
+	case m&fs.ModeSocket != 0:
+		mode |= sIFSOCK
+	case m&fs.ModeCharDevice != 0:
+		mode |= sIFCHR
+	case m&fs.ModeDevice != 0:
+		mode |= sIFBLK
+	default:
+		mode |= sIFREG
+	}
+	if m&fs.ModeSetuid != 0 {
+		mode |= sISUID
+	}
+	if m&fs.ModeSetgid != 0 {
+		mode |= sISGID
+	}
+	if m&fs.ModeSticky != 0 {
+		mode |= sISVTX
+	}
+	return mode
+}
+
+// putBothEndian32 writes v as an ECMA-119 both-byte-order 32-bit value (7.3.3) into b[0:8].
+func putBothEndian32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b[0:4], v)
+	binary.BigEndian.PutUint32(b[4:8], v)
+}
@@ -0,0 +1,57 @@
+package iso9660
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// TestRockRidgeOwner checks the PX entry records the owner of the source file.
+func TestRockRidgeOwner(t *testing.T) {
+	source := writeSourceTree(t, map[string]string{"file.txt": "file"})
+	info, err := os.Stat(filepath.Join(source, "file.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := info.Sys().(*syscall.Stat_t)
+
+	image := buildImage(t, source, rockRidgeOptions())
+	vol := openImage(t, image).Primary()
+	listing, _ := readRockRidgeListing(t, image, vol, vol.Root())
+	px := susPayloads(listing["file.txt"].susp, "PX")[0]
+	if uid, gid := bothEndian32(t, px[16:24]), bothEndian32(t, px[24:32]); uid != st.Uid || gid != st.Gid {
+		t.Errorf("PX owner %d:%d, want %d:%d", uid, gid, st.Uid, st.Gid)
+	}
+}
+
+// TestRockRidgeSpecialFiles checks FIFOs and device nodes are recorded with their PX file type, and devices
+// with their device number in a PN entry.
+func TestRockRidgeSpecialFiles(t *testing.T) {
+	source := writeSourceTree(t, map[string]string{"file.txt": "file"})
+	if err := syscall.Mkfifo(filepath.Join(source, "fifo"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	const nullDevice = 1<<8 | 3 // major 1, minor 3 in the Linux dev_t encoding
+	hasDevice := syscall.Mknod(filepath.Join(source, "null"), syscall.S_IFCHR|0o600, nullDevice) == nil
+
+	image := buildImage(t, source, rockRidgeOptions())
+	vol := openImage(t, image).Primary()
+	listing, _ := readRockRidgeListing(t, image, vol, vol.Root())
+
+	checkPX(t, listing["fifo"].susp, 0o010000|0o600, 1)
+	if pns := susPayloads(listing["fifo"].susp, "PN"); len(pns) != 0 {
+		t.Errorf("FIFO recorded with %d PN entries", len(pns))
+	}
+	if !hasDevice {
+		t.Skip("creating a device node needs CAP_MKNOD")
+	}
+	checkPX(t, listing["null"].susp, 0o020000|0o600, 1)
+	pns := susPayloads(listing["null"].susp, "PN")
+	if len(pns) != 1 {
+		t.Fatalf("device recorded with %d PN entries", len(pns))
+	}
+	if high, low := bothEndian32(t, pns[0][0:8]), bothEndian32(t, pns[0][8:16]); high != 0 || low != nullDevice {
+		t.Errorf("PN device number %#x:%#x, want 0:%#x", high, low, nullDevice)
+	}
+}
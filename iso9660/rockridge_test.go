@@ -0,0 +1,338 @@
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// susTestEntry is a SUSP entry read back from an image.
+type susTestEntry struct {
+	signature string
+	data      []byte // payload after the 4 byte header
+}
+
+// readSUSP decodes the SUSP entries of a System Use field, following CE entries into the continuation
+// areas of image, and returns them with the number of continuation areas visited.
+func readSUSP(t *testing.T, image, systemUse []byte) ([]susTestEntry, int) {
+	t.Helper()
+	var entries []susTestEntry
+	continuations := 0
+	for area := systemUse; area != nil; {
+		var next []byte
+		for len(area) >= susHeaderSize && area[0] != 0 { // a zero byte is the record's padding
+			length := int(area[2])
+			if length < susHeaderSize || length > len(area) {
+				t.Fatalf("SUSP entry '%s' of %d bytes in an area of %d", area[:2], length, len(area))
+			}
+			entry := susTestEntry{signature: string(area[:2]), data: area[susHeaderSize:length]}
+			if area[3] != 1 {
+				t.Errorf("%s entry version %d", entry.signature, area[3])
+			}
+			area = area[length:]
+			if entry.signature == "ST" {
+				break
+			}
+			if entry.signature != "CE" {
+				entries = append(entries, entry)
+				continue
+			}
+			lba, offset, size := bothEndian32(t, entry.data[0:8]), bothEndian32(t, entry.data[8:16]), bothEndian32(t, entry.data[16:24])
+			if offset+size > SectorSize {
+				t.Fatalf("continuation area at offset %d of %d bytes crosses a sector boundary", offset, size)
+			}
+			start := int(lba)*SectorSize + int(offset)
+			if start+int(size) > len(image) {
+				t.Fatalf("continuation area at LBA %d beyond the image", lba)
+			}
+			next = image[start : start+int(size)]
+			continuations++
+		}
+		area = next
+	}
+	return entries, continuations
+}
+
+// bothEndian32 decodes an ECMA-119 both-byte-order 32-bit value, checking both halves agree.
+func bothEndian32(t *testing.T, b []byte) uint32 {
+	t.Helper()
+	le, be := binary.LittleEndian.Uint32(b[0:4]), binary.BigEndian.Uint32(b[4:8])
+	if le != be {
+		t.Errorf("both-endian value %#x / %#x", le, be)
+	}
+	return le
+}
+
+// susPayloads returns the payloads of the entries with signature, in recording order.
+func susPayloads(entries []susTestEntry, signature string) [][]byte {
+	var payloads [][]byte
+	for _, e := range entries {
+		if e.signature == signature {
+			payloads = append(payloads, e.data)
+		}
+	}
+	return payloads
+}
+
+// rrName joins the NM entries of a record, checking the CONTINUE flag is set on all but the last.
+func rrName(t *testing.T, entries []susTestEntry) string {
+	t.Helper()
+	var name strings.Builder
+	nms := susPayloads(entries, "NM")
+	for i, nm := range nms {
+		if continued := nm[0]&0x01 != 0; continued != (i < len(nms)-1) {
+			t.Errorf("NM entry %d of %d: CONTINUE flag %t", i+1, len(nms), continued)
+		}
+		name.Write(nm[1:])
+	}
+	return name.String()
+}
+
+// rrTestRecord is a Directory Record of the ISO9660 tree with its decoded SUSP entries.
+type rrTestRecord struct {
+	entry         *Entry
+	susp          []susTestEntry
+	continuations int
+}
+
+// readRockRidgeListing returns the records of the children of dir in the ISO9660 tree, keyed by their
+// NM name, and the SUSP entries of the directory's own "." record.
+func readRockRidgeListing(t *testing.T, image []byte, vol *Volume, dir *Entry) (map[string]rrTestRecord, []susTestEntry) {
+	t.Helper()
+	children, err := vol.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listing := make(map[string]rrTestRecord)
+	for _, child := range children {
+		entries, continuations := readSUSP(t, image, child.SystemUse)
+		listing[rrName(t, entries)] = rrTestRecord{entry: child, susp: entries, continuations: continuations}
+	}
+	self := image[int(dir.LBA)*SectorSize:]
+	_, _, systemUse, err := unmarshalDirectoryRecord(self[:self[0]])
+	if err != nil {
+		t.Fatal(err)
+	}
+	selfEntries, _ := readSUSP(t, image, systemUse)
+	return listing, selfEntries
+}
+
+// TestRockRidgeEntries reads back the SP, ER, PX, TF and NM entries of a built image, a name long enough
+// to need a continuation area included.
+func TestRockRidgeEntries(t *testing.T) {
+	longName := strings.Repeat("long name ", 25) + ".txt" // 254 bytes, two NM entries
+	source := writeSourceTree(t, map[string]string{
+		"file.txt":     "file",
+		"dir/sub/":     "",
+		"dir/file.txt": "inner",
+		longName:       "long",
+	})
+	if err := os.Chmod(filepath.Join(source, "file.txt"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	image := buildImage(t, source, rockRidgeOptions())
+	vol := openImage(t, image).Primary()
+	listing, rootSelf := readRockRidgeListing(t, image, vol, vol.Root())
+
+	if len(rootSelf) == 0 || rootSelf[0].signature != "SP" || !bytes.Equal(rootSelf[0].data, []byte{0xBE, 0xEF, 0}) {
+		t.Errorf("root '.' record does not start with an SP entry: %+v", rootSelf)
+	}
+	if ers := susPayloads(rootSelf, "ER"); len(ers) != 1 {
+		t.Errorf("%d ER entries in the root '.' record", len(ers))
+	} else if er := ers[0]; string(er[4:4+er[0]]) != rrExtensionID || er[3] != 1 {
+		t.Errorf("ER entry identifies '%s' version %d", er[4:4+er[0]], er[3])
+	}
+	checkPX(t, rootSelf, 0o040000|sourcePerm(t, source), 3) // "dir" and the root's own links
+
+	for _, name := range []string{"file.txt", "dir", longName} {
+		if _, ok := listing[name]; !ok {
+			t.Fatalf("no record named '%s' by NM entries", name)
+		}
+	}
+	file := listing["file.txt"].susp
+	checkPX(t, file, 0o100640, 1)
+	checkPX(t, listing["dir"].susp, 0o040000|sourcePerm(t, filepath.Join(source, "dir")), 3)
+
+	tfs := susPayloads(file, "TF")
+	if len(tfs) != 1 || len(tfs[0]) != 1+3*7 || tfs[0][0] != 0x0E {
+		t.Fatalf("TF entries %v, want modify, access and attributes times", tfs)
+	}
+	var modTime [7]byte
+	copy(modTime[:], tfs[0][1:8])
+	if got := parseRecordingTime(modTime); !got.Equal(sourceTreeTime) {
+		t.Errorf("TF modification time %v, want %v", got, sourceTreeTime)
+	}
+
+	if long := listing[longName]; long.continuations == 0 || len(susPayloads(long.susp, "NM")) != 2 {
+		t.Errorf("long name recorded with %d NM entries and %d continuation areas", len(susPayloads(long.susp, "NM")), long.continuations)
+	}
+}
+
+// sourcePerm returns the permission bits of a source file.
+func sourcePerm(t *testing.T, path string) uint32 {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return uint32(info.Mode().Perm())
+}
+
+// checkPX checks the mode and link count of the PX entry among entries.
+func checkPX(t *testing.T, entries []susTestEntry, mode, links uint32) {
+	t.Helper()
+	pxs := susPayloads(entries, "PX")
+	if len(pxs) != 1 {
+		t.Fatalf("%d PX entries", len(pxs))
+	}
+	if got := bothEndian32(t, pxs[0][0:8]); got != mode {
+		t.Errorf("PX mode %o, want %o", got, mode)
+	}
+	if got := bothEndian32(t, pxs[0][8:16]); got != links {
+		t.Errorf("PX links %d, want %d", got, links)
+	}
+}
+
+// rrSymlinkTarget reassembles the link target of a record's SL entries, checking the CONTINUE flag is set
+// on all but the last entry.
+func rrSymlinkTarget(t *testing.T, entries []susTestEntry) string {
+	t.Helper()
+	var parts []string
+	var component strings.Builder
+	sls := susPayloads(entries, "SL")
+	for i, sl := range sls {
+		if continued := sl[0]&0x01 != 0; continued != (i < len(sls)-1) {
+			t.Errorf("SL entry %d of %d: CONTINUE flag %t", i+1, len(sls), continued)
+		}
+		for records := sl[1:]; len(records) > 0; {
+			flags, content := records[0], records[2:2+records[1]]
+			records = records[2+len(content):]
+			switch {
+			case flags&0x08 != 0: // root
+				parts = append(parts, "")
+			case flags&0x04 != 0:
+				parts = append(parts, "..")
+			case flags&0x02 != 0:
+				parts = append(parts, ".")
+			default:
+				component.Write(content)
+				if flags&0x01 == 0 { // last record of the component
+					parts = append(parts, component.String())
+					component.Reset()
+				}
+			}
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// TestRockRidgeSymlinks reads back the SL entries of links to relative and absolute targets, with "." and
+// ".." components, a component longer than one record and a target spread over several SL entries.
+func TestRockRidgeSymlinks(t *testing.T) {
+	targets := map[string]string{
+		"relative": "file.txt",
+		"absolute": "/usr/lib/../share/./zoneinfo",
+		"parent":   "../up",
+		"long":     strings.Repeat("c", 300) + "/d",
+		"chained":  strings.Repeat("component/", 40) + "end",
+	}
+	source := writeSourceTree(t, map[string]string{"file.txt": "file"})
+	for name, target := range targets {
+		if err := os.Symlink(target, filepath.Join(source, name)); err != nil {
+			t.Skipf("creating symlinks: %v", err)
+		}
+	}
+
+	image := buildImage(t, source, rockRidgeOptions())
+	vol := openImage(t, image).Primary()
+	listing, _ := readRockRidgeListing(t, image, vol, vol.Root())
+	for name, target := range targets {
+		record, ok := listing[name]
+		if !ok {
+			t.Errorf("no record for symlink '%s'", name)
+			continue
+		}
+		info, err := os.Lstat(filepath.Join(source, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkPX(t, record.susp, 0o120000|uint32(info.Mode().Perm()), 1)
+		if got := rrSymlinkTarget(t, record.susp); got != target {
+			t.Errorf("symlink '%s' points to '%s', want '%s'", name, got, target)
+		}
+		if record.entry.Size != 0 {
+			t.Errorf("symlink '%s' has a %d byte extent", name, record.entry.Size)
+		}
+	}
+	if n := len(susPayloads(listing["chained"].susp, "SL")); n < 2 {
+		t.Errorf("target of %d bytes recorded in %d SL entry", len(targets["chained"]), n)
+	}
+}
+
+// TestRRSLComponents checks the component records of a target: root, parent and current directory flags,
+// and a component split over two records with the component CONTINUE flag, each record in its own SL entry.
+func TestRRSLComponents(t *testing.T) {
+	long := strings.Repeat("x", 250)
+	want := [][]byte{
+		{0x01, 0x08, 0, 0, 1, 'a', 0x04, 0, 0x02, 0}, // CONTINUE: root, "a", "..", "."
+		append([]byte{0x01, 0x01, 248}, long[:248]...),
+		{0, 0, 2, 'x', 'x'},
+	}
+	entries := rrSL("/a/.././" + long)
+	if len(entries) != len(want) {
+		t.Fatalf("%d SL entries, want %d", len(entries), len(want))
+	}
+	for i, entry := range entries {
+		if got := entry[susHeaderSize:]; !bytes.Equal(got, want[i]) {
+			t.Errorf("SL entry %d: %v, want %v", i+1, got, want[i])
+		}
+	}
+}
+
+// TestFollowSymlinks checks followed links embed their targets and a link back to a parent directory
+// fails the scan instead of recursing forever.
+func TestFollowSymlinks(t *testing.T) {
+	source := writeSourceTree(t, map[string]string{"real/f.txt": "real"})
+	for name, target := range map[string]string{"link": "real", "flink": "real/f.txt", "dangling": "missing"} {
+		if err := os.Symlink(target, filepath.Join(source, name)); err != nil {
+			t.Skipf("creating symlinks: %v", err)
+		}
+	}
+	opts := DefaultOptions()
+	opts.FollowSymlinks = true
+
+	img := openImage(t, buildImage(t, source, opts))
+	for _, name := range []string{"real/f.txt", "link/f.txt", "flink"} {
+		if data, err := fs.ReadFile(img.FS(), name); err != nil || string(data) != "real" {
+			t.Errorf("reading '%s': %q, %v", name, data, err)
+		}
+	}
+	if _, err := fs.Stat(img.FS(), "dangling"); err == nil {
+		t.Error("dangling symlink recorded")
+	}
+
+	if err := os.Symlink("..", filepath.Join(source, "real", "loop")); err != nil {
+		t.Fatal(err)
+	}
+	_, err := NewBuilder(source, "", opts).WriteTo(io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "symlink loop") {
+		t.Errorf("building a tree with a symlink loop: %v", err)
+	}
+}
+
+// TestRRPN checks the PN entry splits a device number into its high and low 32 bits.
+func TestRRPN(t *testing.T) {
+	pn := rrPN(0x0000_0012_0000_0103)
+	if string(pn[0:2]) != "PN" || int(pn[2]) != len(pn) || len(pn) != susHeaderSize+16 {
+		t.Fatalf("PN entry %v", pn)
+	}
+	if high, low := bothEndian32(t, pn[4:12]), bothEndian32(t, pn[12:20]); high != 0x12 || low != 0x103 {
+		t.Errorf("PN device number %#x:%#x, want 0x12:0x103", high, low)
+	}
+}
@@ -2,6 +2,9 @@ package iso9660
 
 import (
 	"fmt"
+	"io/fs"
+	"log"
+	"math"
 	"os"
 	"path/filepath"
 )
@@ -10,28 +13,40 @@ import (
 // This can be called explicitly by the user or implicitly by Build.
 func (b *ISOBuilder) ScanSourceDirectory() error {
 	b.fileEntries = nil // Clear previous scan results if any
+	b.progress = Progress{}
+	b.reportPhase(PhaseScan, 0)
 	absPath, err := filepath.Abs(b.sourceDir)
 	if err != nil {
 		return fmt.Errorf("getting absolute path for source '%s': %w", b.sourceDir, err)
 	}
 
+	rootInfo, err := os.Stat(absPath)
+	if err != nil {
+		return fmt.Errorf("getting info for source '%s': %w", absPath, err)
+	}
 	rootEntry := fileEntry{
-		originalName:    "\x00",
-		diskPath:        absPath,
-		isoPath:         "/",
-		isDir:           true,
-		level:           0,
-		parentIndex:     0, // roots parent is itself (index 0)
-		pathTableDirNum: 1, // root directory is always #1 in path table
+		originalName: "\x00",
+		diskPath:     absPath,
+		isoPath:      "/",
+		isDir:        true,
+		level:        0,
+		parentIndex:  0, // roots parent is itself (index 0)
+		isRoot:       true,
 	}
+	applyFileInfo(&rootEntry, rootInfo)
 	b.fileEntries = append(b.fileEntries, rootEntry)
 
-	nextPathTableNum := uint16(2) // next available path table number
-	return b.scanDirectoryRecursive(absPath, 0 /*parentIndex for root*/, &nextPathTableNum, absPath /*sourceBaseDiskPath*/)
+	if err := b.scanDirectoryRecursive(absPath, 0 /*parentIndex for root*/, absPath /*sourceBaseDiskPath*/, []os.FileInfo{rootInfo}); err != nil {
+		return err
+	}
+	b.reportScanned(true)
+	b.buildISO9660Hierarchy()
+	return nil
 }
 
 // scanDirectoryRecursive performs a depth-first scan of the filesystem.
-func (b *ISOBuilder) scanDirectoryRecursive(currentDiskPath string, parentEntryIndex int, nextPathTableNumber *uint16, sourceBaseDiskPath string) error {
+// ancestors holds the directories from the source root down to currentDiskPath, for symlink loop detection.
+func (b *ISOBuilder) scanDirectoryRecursive(currentDiskPath string, parentEntryIndex int, sourceBaseDiskPath string, ancestors []os.FileInfo) error {
 	osEntries, err := os.ReadDir(currentDiskPath)
 	if err != nil {
 		return fmt.Errorf("reading directory '%s': %w", currentDiskPath, err)
@@ -57,25 +72,67 @@ func (b *ISOBuilder) scanDirectoryRecursive(currentDiskPath string, parentEntryI
 			level:        b.fileEntries[parentEntryIndex].level + 1,
 			parentIndex:  parentEntryIndex,
 		}
+		applyFileInfo(&fe, fileInfo)
+
+		if fileInfo.Mode()&fs.ModeSymlink != 0 {
+			if b.options.FollowSymlinks {
+				// embed whatever the link points to, under the link's name
+				targetInfo, err := os.Stat(fullDiskPath)
+				if err != nil {
+					log.Printf("Warning: skipping dangling symlink '%s': %v", fullDiskPath, err)
+					continue
+				}
+				if targetInfo.IsDir() {
+					for _, ancestor := range ancestors {
+						if os.SameFile(ancestor, targetInfo) {
+							return fmt.Errorf("symlink loop: '%s' points back to one of its parent directories", fullDiskPath)
+						}
+					}
+				}
+				fileInfo = targetInfo
+				applyFileInfo(&fe, fileInfo)
+			} else if b.options.RockRidge {
+				// recorded as a zero-length entry carrying Rock Ridge SL entries
+				target, err := os.Readlink(fullDiskPath)
+				if err != nil {
+					return fmt.Errorf("reading symlink '%s': %w", fullDiskPath, err)
+				}
+				fe.symlinkTarget = target
+			} else {
+				log.Printf("Warning: skipping symlink '%s' (needs Options.RockRidge or Options.FollowSymlinks)", fullDiskPath)
+				continue
+			}
+		}
 
-		if osEntry.IsDir() {
+		if fe.isSpecial() && !b.options.RockRidge {
+			log.Printf("Warning: skipping special file '%s' (needs Options.RockRidge)", fullDiskPath)
+			continue
+		}
+
+		if fileInfo.IsDir() {
 			fe.isDir = true
-			fe.pathTableDirNum = *nextPathTableNumber
-			(*nextPathTableNumber)++
 			b.fileEntries = append(b.fileEntries, fe)
 			newEntryIndex := len(b.fileEntries) - 1 // newly added dir
 			b.fileEntries[parentEntryIndex].children = append(b.fileEntries[parentEntryIndex].children, newEntryIndex)
-			if errRec := b.scanDirectoryRecursive(fullDiskPath, newEntryIndex, nextPathTableNumber, sourceBaseDiskPath); errRec != nil {
+			if errRec := b.scanDirectoryRecursive(fullDiskPath, newEntryIndex, sourceBaseDiskPath, append(ancestors, fileInfo)); errRec != nil {
 				return errRec
 			}
 		} else if fileInfo.Mode().IsRegular() {
 			fe.isDir = false
-			fe.iso9660Size = uint32(fileInfo.Size()) // data size
-			fe.jolietSize = fe.iso9660Size           // ^ same for joliet
+			fe.size = fileInfo.Size()
+			if fe.size > math.MaxUint32 && !b.options.MultiExtent && b.options.InterchangeLevel < 3 {
+				return fmt.Errorf("file '%s' is %d bytes, files of 4 GiB and more need Options.MultiExtent or InterchangeLevel 3", fullDiskPath, fe.size)
+			}
+			b.fileEntries = append(b.fileEntries, fe)
+			newEntryIndex := len(b.fileEntries) - 1
+			b.fileEntries[parentEntryIndex].children = append(b.fileEntries[parentEntryIndex].children, newEntryIndex)
+		} else if fe.isSymlink() || fe.isSpecial() {
+			// no data extent, size stays 0
 			b.fileEntries = append(b.fileEntries, fe)
 			newEntryIndex := len(b.fileEntries) - 1
 			b.fileEntries[parentEntryIndex].children = append(b.fileEntries[parentEntryIndex].children, newEntryIndex)
 		}
+		b.reportScanned(false)
 	}
 	return nil
 }
@@ -0,0 +1,98 @@
+package iso9660
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ImageHandler serves a VirtualImage over HTTP: GET and HEAD with Content-Length, a strong ETag,
+// conditional and Range requests (resumable downloads, iPXE/virt-manager HTTP boot).
+// : the image is laid out once and reads file data from the source files on demand. Every request
+// : re-checks the size and modification time of the source files and fails once one of them changed,
+// : rather than serve bytes that match neither the layout nor the entity tag.
+type ImageHandler struct {
+	image   *VirtualImage
+	name    string // file name of the image, for the Content-Disposition header
+	sources string // sourceStamp of the image when it was laid out
+	etag    string
+	modTime time.Time
+}
+
+// Handler lays out the image as a VirtualImage (see ISOBuilder.VirtualImage) and returns a handler serving it,
+// named after the output file given to NewBuilder.
+func (b *ISOBuilder) Handler() (*ImageHandler, error) {
+	image, err := b.VirtualImage()
+	if err != nil {
+		return nil, err
+	}
+	sources, err := image.sourceStamp()
+	if err != nil {
+		return nil, err
+	}
+	return &ImageHandler{
+		image:   image,
+		name:    filepath.Base(b.outputFilename),
+		sources: sources,
+		etag:    image.etag(sources),
+		modTime: b.buildTime,
+	}, nil
+}
+
+// Image returns the served image.
+func (h *ImageHandler) Image() *VirtualImage {
+	return h.image
+}
+
+// ServeHTTP implements http.Handler.
+func (h *ImageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if sources, err := h.image.sourceStamp(); err != nil || sources != h.sources {
+		http.Error(w, "the source files of the image changed since it was laid out", http.StatusInternalServerError)
+		return
+	}
+	header := w.Header()
+	header.Set("Content-Type", "application/x-iso9660-image")
+	header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": h.name})) // quoted or RFC 2231 encoded
+	header.Set("ETag", h.etag)                                                                                   // ServeContent evaluates If-Match, If-None-Match and If-Range against it
+	http.ServeContent(w, r, h.name, h.modTime, io.NewSectionReader(h.image, 0, h.image.Size()))
+}
+
+// etag derives a strong entity tag from the generated metadata (volume timestamps included) and the
+// sourceStamp of the file ranges, so neither two generations of an image nor an image whose source files
+// were edited in place share one.
+func (v *VirtualImage) etag(sources string) string {
+	hash := sha256.New()
+	for _, seg := range v.segments {
+		hash.Write(seg.data)
+	}
+	hash.Write([]byte(sources))
+	return `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+}
+
+// sourceStamp hashes the path, size and modification time of the source file of every file range.
+// : one stat per source file, taken again for every request served.
+func (v *VirtualImage) sourceStamp() (string, error) {
+	hash := sha256.New()
+	for _, seg := range v.segments {
+		if seg.diskPath == "" {
+			continue
+		}
+		info, err := os.Stat(seg.diskPath)
+		if err != nil {
+			return "", fmt.Errorf("stat '%s': %w", seg.diskPath, err)
+		}
+		fmt.Fprintf(hash, "%s\x00%d\x00%d\x00", seg.diskPath, info.Size(), info.ModTime().UnixNano())
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,160 @@
+package iso9660
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestHandler returns the handler of sourceDir with a fixed build timestamp.
+func newTestHandler(t *testing.T, sourceDir string) *ImageHandler {
+	t.Helper()
+	opts := DefaultOptions()
+	opts.Timestamp = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	h, err := NewBuilder(sourceDir, "test.iso", opts).Handler()
+	if err != nil {
+		t.Fatalf("Handler: %v", err)
+	}
+	return h
+}
+
+// TestHandlerRequests checks full, ranged, conditional, HEAD and unsupported requests against the bytes
+// of the served image.
+func TestHandlerRequests(t *testing.T) {
+	source := writeSourceTree(t, map[string]string{"a.txt": "a", "docs/b.txt": "b"})
+	h := newTestHandler(t, source)
+	image, err := io.ReadAll(io.NewSectionReader(h.Image(), 0, h.Image().Size()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := len(image)
+
+	tests := []struct {
+		name        string
+		method      string
+		header      map[string]string
+		wantStatus  int
+		wantBody    []byte
+		wantHeaders map[string]string
+	}{
+		{"get", http.MethodGet, nil, http.StatusOK, image,
+			map[string]string{"Content-Length": fmt.Sprint(size), "ETag": h.etag, "Accept-Ranges": "bytes"}},
+		{"range", http.MethodGet, map[string]string{"Range": "bytes=100-2147"}, http.StatusPartialContent, image[100:2148],
+			map[string]string{"Content-Range": fmt.Sprintf("bytes 100-2147/%d", size), "Content-Length": "2048"}},
+		{"suffix range", http.MethodGet, map[string]string{"Range": "bytes=-10"}, http.StatusPartialContent, image[size-10:],
+			map[string]string{"Content-Range": fmt.Sprintf("bytes %d-%d/%d", size-10, size-1, size)}},
+		{"unsatisfiable range", http.MethodGet, map[string]string{"Range": fmt.Sprintf("bytes=%d-", size)}, http.StatusRequestedRangeNotSatisfiable, nil,
+			map[string]string{"Content-Range": fmt.Sprintf("bytes */%d", size)}},
+		{"if-none-match", http.MethodGet, map[string]string{"If-None-Match": h.etag}, http.StatusNotModified, []byte{},
+			map[string]string{"ETag": h.etag}},
+		{"if-none-match other", http.MethodGet, map[string]string{"If-None-Match": `"other"`}, http.StatusOK, image, nil},
+		{"if-range stale", http.MethodGet, map[string]string{"Range": "bytes=0-9", "If-Range": `"other"`}, http.StatusOK, image, nil},
+		{"head", http.MethodHead, nil, http.StatusOK, []byte{},
+			map[string]string{"Content-Length": fmt.Sprint(size)}},
+		{"post", http.MethodPost, nil, http.StatusMethodNotAllowed, nil,
+			map[string]string{"Allow": "GET, HEAD"}},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/test.iso", nil)
+		for k, v := range tt.header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		resp := rec.Result()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d", tt.name, resp.StatusCode, tt.wantStatus)
+			continue
+		}
+		if tt.wantBody != nil && !bytes.Equal(body, tt.wantBody) {
+			t.Errorf("%s: body of %d bytes differs from the %d bytes expected", tt.name, len(body), len(tt.wantBody))
+		}
+		for k, v := range tt.wantHeaders {
+			if got := resp.Header.Get(k); got != v {
+				t.Errorf("%s: %s %q, want %q", tt.name, k, got, v)
+			}
+		}
+	}
+}
+
+// TestHandlerETagTracksSources checks a source file edited in place (same size, same second, so the same
+// directory records) changes the entity tag.
+func TestHandlerETagTracksSources(t *testing.T) {
+	source := writeSourceTree(t, map[string]string{"a.txt": "version 1"})
+	path := filepath.Join(source, "a.txt")
+	second := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(path, second, second.Add(100*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	before := newTestHandler(t, source).etag
+
+	if err := os.WriteFile(path, []byte("version 2"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, second, second.Add(500*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if after := newTestHandler(t, source).etag; after == before {
+		t.Errorf("entity tag %s unchanged after editing a source file", after)
+	}
+}
+
+// TestHandlerRefusesChangedSources checks requests fail once a source file of the served image changed,
+// its modification time or its size, rather than serve bytes that don't match the layout.
+func TestHandlerRefusesChangedSources(t *testing.T) {
+	for _, change := range []string{"touched", "resized", "removed"} {
+		source := writeSourceTree(t, map[string]string{"a.txt": "a", "docs/b.txt": "b"})
+		path := filepath.Join(source, "docs", "b.txt")
+		h := newTestHandler(t, source)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/test.iso", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d before any change", rec.Code)
+		}
+
+		var err error
+		switch change {
+		case "touched":
+			err = os.Chtimes(path, time.Now(), sourceTreeTime.Add(time.Second))
+		case "resized":
+			err = os.WriteFile(path, []byte("bb"), 0o644)
+			if err == nil {
+				err = os.Chtimes(path, time.Now(), sourceTreeTime)
+			}
+		case "removed":
+			err = os.Remove(path)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, method := range []string{http.MethodGet, http.MethodHead} {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(method, "/test.iso", nil))
+			if rec.Code != http.StatusInternalServerError || rec.Header().Get("ETag") != "" {
+				t.Errorf("%s source, %s: status %d, ETag %q, want a server error", change, method, rec.Code, rec.Header().Get("ETag"))
+			}
+		}
+	}
+}
+
+// TestHandlerContentDisposition checks the file name is quoted, whatever characters it holds.
+func TestHandlerContentDisposition(t *testing.T) {
+	h := newTestHandler(t, writeSourceTree(t, map[string]string{"a.txt": "a"}))
+	for _, name := range []string{"test.iso", `a "quoted"; name.iso`, "café.iso"} {
+		h.name = name
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/test.iso", nil))
+		_, params, err := mime.ParseMediaType(rec.Header().Get("Content-Disposition"))
+		if err != nil || params["filename"] != name {
+			t.Errorf("Content-Disposition %q: filename %q (%v), want %q", rec.Header().Get("Content-Disposition"), params["filename"], err, name)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package iso9660
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// applyFileInfo copies the POSIX metadata of a scanned source entry into fe.
+// : ownership, access/change times and device numbers come from the underlying syscall.Stat_t.
+func applyFileInfo(fe *fileEntry, info fs.FileInfo) {
+	fe.mode = info.Mode()
+	fe.modTime = info.ModTime()
+	fe.accessTime = fe.modTime
+	fe.changeTime = fe.modTime
+
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	fe.uid = st.Uid
+	fe.gid = st.Gid
+	fe.accessTime = time.Unix(st.Atim.Unix())
+	fe.changeTime = time.Unix(st.Ctim.Unix())
+	fe.rdev = uint64(st.Rdev)
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package iso9660
+
+import "io/fs"
+
+// applyFileInfo copies the portable metadata of a scanned source entry into fe.
+// : ownership and device numbers aren't available here, they stay 0 and all timestamps are the mtime.
+func applyFileInfo(fe *fileEntry, info fs.FileInfo) {
+	fe.mode = info.Mode()
+	fe.modTime = info.ModTime()
+	fe.accessTime = fe.modTime
+	fe.changeTime = fe.modTime
+}
@@ -0,0 +1,54 @@
+package iso9660
+
+import "fmt"
+
+// The image records the scanned entries under several directory trees, one per volume descriptor:
+// the ISO9660 tree of the PVD, the Joliet tree of its SVD and, optionally, the ISO 9660:1999 tree of
+// an Enhanced Volume Descriptor. Files share one data extent, every tree has its own names,
+// directory extents and path tables (fileEntry.nodes, ISOBuilder.trees).
+
+// resolveTrees sets up the list of directory trees recorded in the image, in volume descriptor order.
+func (b *ISOBuilder) resolveTrees() {
+	b.trees = []*directoryTree{{kind: treeISO9660}, {kind: treeJoliet}}
+	if b.options.EnhancedVolumeDescriptor {
+		b.trees = append(b.trees, &directoryTree{kind: treeEnhanced})
+	}
+}
+
+// hasTree reports whether the image records the tree of the given kind.
+func (b *ISOBuilder) hasTree(kind treeKind) bool {
+	for _, tree := range b.trees {
+		if tree.kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// treeLinks returns the parent and children of entry i in the tree of the given kind.
+// : only the ISO9660 tree follows relocations.
+func (b *ISOBuilder) treeLinks(i int, kind treeKind) (parentIndex int, children []int) {
+	f := &b.fileEntries[i]
+	if kind == treeISO9660 {
+		return f.isoParentIndex, f.isoChildren
+	}
+	return f.parentIndex, f.children
+}
+
+// inTree reports whether the entry is part of the tree of the given kind.
+func (f *fileEntry) inTree(kind treeKind) bool {
+	return kind == treeISO9660 || !f.isoOnly
+}
+
+// String returns the name of the tree's volume descriptor flavour, for messages.
+func (k treeKind) String() string {
+	switch k {
+	case treeISO9660:
+		return "ISO9660"
+	case treeJoliet:
+		return "Joliet"
+	case treeEnhanced:
+		return "Enhanced"
+	}
+	return fmt.Sprintf("treeKind(%d)", int(k))
+}
@@ -1,5 +1,11 @@
 package iso9660
 
+import (
+	"io/fs"
+	"math"
+	"time"
+)
+
 // volumeDescriptorHeader is common to PVD, SVD, Terminator.
 // (ECMA-119 Section 8.4.1, 8.5.1, 8.6.1)
 type volumeDescriptorHeader struct {
@@ -99,6 +105,36 @@ type pathTableRecordFields struct {
 	ParentDirectoryNumber         uint16 // Path Table directory number of the parent directory
 }
 
+// treeKind identifies one of the directory hierarchies of the image, each recorded under its own
+// volume descriptor with its own directory extents and path tables.
+type treeKind int
+
+const (
+	treeISO9660  treeKind = iota // PVD: sanitized names, deep directories relocated, Rock Ridge
+	treeJoliet                   // Joliet SVD: UCS-2 names
+	treeEnhanced                 // ISO 9660:1999 Enhanced Volume Descriptor: 207-byte names, no depth limit
+	numTreeKinds
+)
+
+// treeNode is the place of an entry in one directory tree.
+type treeNode struct {
+	name string // identifier in this tree (e.g., "MY_DOC.TXT;1"), "" ("\x00" for Joliet) for the root
+
+	// abs LBA of the start of the content.
+	// files: LBA of file data, the same in every tree.
+	// directories: LBA of this tree's directory listing extent.
+	sector uint32
+	size   uint32 // directories: sector-aligned byte size of the listing extent
+
+	// exact byte length (including padding) of the Directory Record for this entry when it
+	// appears as a child in its parent's directory listing, or in the volume descriptor for the root.
+	drSize int
+
+	dirNum uint32 // directories: number in this tree's path tables (1 for root), 0 if not part of the tree
+
+	renamed bool // name was given a numeric suffix to resolve a collision within the parent directory
+}
+
 // fileEntry is the internal representation of a scanned file or directory from the source filesystem.
 // It holds metadata needed to construct the ISO image.
 type fileEntry struct {
@@ -111,26 +147,85 @@ type fileEntry struct {
 	parentIndex int   // index in ISOBuilder.fileEntries slice of this entry's parent
 	children    []int // indices of children fileEntry items
 
-	iso9660Name string // ISO9660 sanitized name (e.g., "MY_DOC.TXT;1")
-	jolietName  string // Joliet name
+	// position in the ISO9660 tree, differs from the above once deep directories are relocated.
+	// the Joliet and Enhanced trees always use parentIndex/children.
+	isoParentIndex    int
+	isoChildren       []int
+	isoOnly           bool // RR_MOVED and relocation placeholders, absent from the Joliet and Enhanced trees
+	relocatedDirIndex int  // placeholders: index of the relocated directory (Rock Ridge CL), 0 otherwise
 
-	// files: actual data length in bytes.
-	// directories: sector-aligned byte size of their directory listing extent.
-	iso9660Size uint32
-	jolietSize  uint32
+	// name, extent and DR size in each directory tree (see treeKind).
+	nodes [numTreeKinds]treeNode
 
-	// abs LBA of the start of the content.
-	// files: LBA of file data. iso9660Sector and jolietSector will point to the same LBA.
-	// directories: LBA of their specific (ISO9660 or Joliet) directory listing extent.
-	iso9660Sector uint32
-	jolietSector  uint32
-
-	// actualISO9660DrSize and actualJolietDrSize store the exact byte length
-	// (including padding) of the Directory Record for this entry when it appears
-	// as a child in its parent's directory listing, or in PVD/SVD for the root.
-	actualISO9660DrSize int
-	actualJolietDrSize  int
-
-	pathTableDirNum uint16 // number for directories in path tables (1 for root)
-	isHidden        bool   // mark file as hidden in Directory Records
+	// files: actual data length in bytes, may exceed 4 GiB with Options.MultiExtent.
+	size int64
+
+	isRoot   bool // the source directory itself (fileEntries[0])
+	isHidden bool // mark file as hidden in Directory Records
+
+	// POSIX metadata of the source entry, recorded in Rock Ridge PX and TF entries.
+	mode       fs.FileMode
+	uid, gid   uint32
+	modTime    time.Time
+	accessTime time.Time
+	changeTime time.Time // attribute change time (st_ctime)
+
+	symlinkTarget string // link target, for symlinks recorded as Rock Ridge SL entries
+	rdev          uint64 // device number (st_rdev) of block/char device nodes, recorded in Rock Ridge PN
+}
+
+// isSymlink reports whether the entry is a symlink recorded as such (not followed).
+func (f *fileEntry) isSymlink() bool {
+	return f.mode&fs.ModeSymlink != 0
+}
+
+// isSpecial reports whether the entry is a device, FIFO or socket node.
+func (f *fileEntry) isSpecial() bool {
+	return f.mode&(fs.ModeDevice|fs.ModeCharDevice|fs.ModeNamedPipe|fs.ModeSocket) != 0
+}
+
+// hasDataExtent reports whether the entry owns file data in the image.
+// : symlinks, special nodes and relocation placeholders are zero-length entries only described by Rock Ridge.
+func (f *fileEntry) hasDataExtent() bool {
+	return !f.isDir && !f.isSymlink() && !f.isSpecial() && f.relocatedDirIndex == 0
+}
+
+// extents splits the file data into the Data Length of each of its Directory Records.
+// : a file is a single extent unless it is too large for the 32-bit Data Length field,
+// then every extent but the last is maxExtentSize bytes so the next one starts on a sector boundary.
+// -> ECMA-119 Section 6.5.1 (multi-extent files, Level 3)
+func (f *fileEntry) extents() []uint32 {
+	if f.size <= math.MaxUint32 {
+		return []uint32{uint32(f.size)}
+	}
+	var extents []uint32
+	for remaining := f.size; remaining > 0; remaining -= maxExtentSize {
+		extents = append(extents, uint32(min(remaining, maxExtentSize)))
+	}
+	return extents
+}
+
+// dataSectors returns the number of sectors occupied by the file data, at least 1.
+func (f *fileEntry) dataSectors() uint32 {
+	if f.size == 0 {
+		return 1 // see sectorsToContainFileBytes
+	}
+	return uint32((f.size + SectorSize - 1) / SectorSize)
+}
+
+// dataSector returns the LBA of the file data.
+func (f *fileEntry) dataSector() uint32 {
+	return f.nodes[treeISO9660].sector
+}
+
+// setDataSector places the file data at lba, every tree's DRs point to the same extent.
+func (f *fileEntry) setDataSector(lba uint32) {
+	for kind := range f.nodes {
+		f.nodes[kind].sector = lba
+	}
+}
+
+// isRelocated reports whether the directory was moved into RR_MOVED in the ISO9660 tree.
+func (f *fileEntry) isRelocated() bool {
+	return f.isDir && f.isoParentIndex != f.parentIndex
 }
@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 	"unicode/utf16"
+	"unicode/utf8"
 )
 
 // sectorsToContainBytes calculates the number of sectors needed to hold byteSize data.
@@ -32,114 +33,126 @@ func sectorsToContainFileBytes(fileDataSizeBytes uint32) uint32 {
 	return (fileDataSizeBytes + SectorSize - 1) / SectorSize
 }
 
-// sanitizeISO9660Name converts a name to be ISO9660 Level 1 compliant
-// (uppercase, specific chars, 8.3 for files without version, directories just 8 chars).
-// Version numbers (e.g., ";1") are typically appended *after* calling this for files.
-func sanitizeISO9660Name(originalName string, isDirectory bool) string {
-	var base, ext string
-	nameToProcess := originalName
+// iso9660NameRules are the identifier limits of the chosen interchange level and naming relaxations.
+// -> ECMA-119 Section 7.5 and 10 (levels of interchange)
+type iso9660NameRules struct {
+	maxBaseLen, maxExtLen int  // Level 1: 8.3, 0 maxExtLen -> base and extension share maxFileLen
+	maxFileLen            int  // file name and extension, excluding the separator (Level 2/3: 30)
+	maxDirLen             int  // directory identifier (Level 1: 8, Level 2/3: 31)
+	maxIdentifierLen      int  // whole file identifier including the separator (37-character relaxation), 0 for none
+	lowercase             bool // keep lowercase letters
+	multiDot              bool // keep dots inside names, not just the extension separator
+	leadingDots           bool // keep a leading dot ('.bashrc')
+}
 
-	if !isDirectory {
-		lastDot := strings.LastIndex(nameToProcess, ".")
-		// cases like ".bashrc" where the part before dot is empty
-		if lastDot != -1 && lastDot < len(nameToProcess)-1 {
-			base = nameToProcess[:lastDot]
-			ext = nameToProcess[lastDot+1:]
-		} else {
-			base = nameToProcess
-			ext = ""
-		}
-	} else {
-		base = nameToProcess
-		ext = "" // directories don't have extensions in ISO9660 Level 1 sense
+// iso9660NameRules derives the naming rules from Options.InterchangeLevel and Options.Relaxations.
+func (b *ISOBuilder) iso9660NameRules() iso9660NameRules {
+	relax := b.options.Relaxations
+	rules := iso9660NameRules{maxBaseLen: 8, maxExtLen: 3, maxDirLen: 8}
+	if b.options.InterchangeLevel >= 2 {
+		rules = iso9660NameRules{maxFileLen: 30, maxDirLen: 31}
 	}
+	if relax.MaxLength37 {
+		rules = iso9660NameRules{maxIdentifierLen: 37, maxDirLen: 37}
+	}
+	rules.lowercase = relax.AllowLowercase
+	rules.multiDot = relax.AllowMultiDot
+	rules.leadingDots = relax.AllowLeadingDots
+	return rules
+}
 
-	sanitizePartFunc := func(part string, maxLength int, allowDot bool) string {
-		part = strings.ToUpper(part)
+// iso9660FileVersion returns the version suffix appended to file identifiers, "" when omitted.
+// : 37-character names use up the room of the version number, mkisofs drops it as well.
+func (b *ISOBuilder) iso9660FileVersion() string {
+	if b.options.Relaxations.OmitVersion || b.options.Relaxations.MaxLength37 {
+		return ""
+	}
+	return ";1"
+}
+
+// sanitizeISO9660Name converts a name to an ISO9660 identifier following rules
+// (d-characters, uppercase unless relaxed, length limits of the interchange level).
+// Version numbers (e.g., ";1") are typically appended *after* calling this for files.
+func sanitizeISO9660Name(originalName string, isDirectory bool, rules iso9660NameRules) string {
+	sanitizePartFunc := func(part string, allowDot bool) string {
+		if !rules.lowercase {
+			part = strings.ToUpper(part)
+		}
 		var sb strings.Builder
 		for _, r := range part {
-			if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' || (rules.lowercase && r >= 'a' && r <= 'z') {
 				sb.WriteRune(r)
-			} else if allowDot && r == '.' { // only allow dot if explicitly permitted (in base part of filename)
-				sb.WriteRune('.') // preserve dots in base for now, will be handled by 8.3 split
+			} else if allowDot && r == '.' {
+				sb.WriteRune('.')
 			} else {
 				sb.WriteRune('_') // replace invalid characters with underscore
 			}
 		}
-		sanitized := sb.String()
-		if len(sanitized) > maxLength {
-			sanitized = sanitized[:maxLength]
-		}
-		return sanitized
+		return sb.String()
 	}
 
-	// max length for directory name or filename base part (before extension) is 8.
-	// max length for extension is 3.
-	maxBaseLen := 8
-	maxExtLen := 3
-	if isDirectory {
-		// ECMA-119 7.5.1: Directory Identifiers are d1-characters, max 31.
-		// Level 1 (6.8.2.1) is more restrictive: 8 chars, no dot, no version.
-		// : aim for Level 1 compatibility for widest support.
-		maxBaseLen = 8 // or 31 if not strictly Level 1, but 8 is safer
+	// a leading dot ('.bashrc') is never the extension separator
+	leading := ""
+	nameToProcess := originalName
+	if strings.HasPrefix(nameToProcess, ".") {
+		leading = "_"
+		if rules.leadingDots {
+			leading = "."
+		}
+		nameToProcess = nameToProcess[1:]
 	}
 
-	sanitizedBase := sanitizePartFunc(base, maxBaseLen, !isDirectory)
 	if isDirectory {
-		// for directories, remove any dots that might have slipped through
-		sanitizedBase = strings.ReplaceAll(sanitizedBase, ".", "_")
-		if len(sanitizedBase) > 8 { // re-truncate if replacing dots made it longer
-			sanitizedBase = sanitizedBase[:8]
-		}
-		if sanitizedBase == "" {
+		// ECMA-119 7.6.1: Directory Identifiers are d1-characters without a separator
+		name := truncateString(leading+sanitizePartFunc(nameToProcess, rules.multiDot), rules.maxDirLen)
+		if name == "" {
 			return "DIR" // default name for empty/invalid dir name
 		}
-		return sanitizedBase
+		return name
 	}
 
-	// files -> ensure 8.3 format
-	finalBase := sanitizedBase
-	finalExt := ""
-
-	if ext != "" {
-		finalExt = sanitizePartFunc(ext, maxExtLen, false)
+	// files -> name, separator, extension (split at the last dot)
+	base, ext := nameToProcess, ""
+	if lastDot := strings.LastIndex(nameToProcess, "."); lastDot != -1 {
+		base, ext = nameToProcess[:lastDot], nameToProcess[lastDot+1:]
 	}
+	base = leading + sanitizePartFunc(base, rules.multiDot)
+	ext = sanitizePartFunc(ext, false)
 
-	// base part contained dots, re-split for 8.3 if necessary,
-	// or if original had no extension but base was like "file.name"
-	if strings.Contains(finalBase, ".") && finalExt == "" {
-		parts := strings.SplitN(finalBase, ".", 2)
-		if len(parts) == 2 {
-			potentialBase := sanitizePartFunc(parts[0], 8, false)
-			potentialExt := sanitizePartFunc(parts[1], 3, false)
-			if potentialBase != "" {
-				finalBase = potentialBase
-				if potentialExt != "" {
-					finalExt = potentialExt
-				}
+	untruncated := base
+	switch {
+	case rules.maxExtLen > 0: // Level 1: 8.3
+		base, ext = truncateString(base, rules.maxBaseLen), truncateString(ext, rules.maxExtLen)
+	default: // name and extension share one limit, the extension is kept whole when possible
+		maxLen := rules.maxFileLen
+		if rules.maxIdentifierLen > 0 {
+			maxLen = rules.maxIdentifierLen
+			if ext != "" {
+				maxLen-- // the separator counts towards the identifier
 			}
 		}
+		ext = truncateString(ext, maxLen-1)
+		base = truncateString(base, maxLen-len(ext))
 	}
-	// base does not exceed 8 chars after any re-splitting
-	if len(finalBase) > 8 {
-		finalBase = finalBase[:8]
-	}
-
-	finalName := finalBase
-	if finalExt != "" {
-		finalName += "." + finalExt
+	if base != untruncated {
+		base = strings.TrimRight(base, ".") // a cut after a kept dot ("archive.tar.gz") would double the separator
 	}
 
-	if finalName == "" || finalName == "." {
-		finalName = "FILE" //default for invalid/empty file name
+	if base == "" && ext == "" {
+		return "FILE" // default for invalid/empty file names
 	}
-	// remove trailing dots if base is empty and ext exists, e.g. ".TXT" -> "TXT"
-	// or if finalName is just "."
-	if strings.HasPrefix(finalName, ".") && len(finalName) > 1 && finalExt != "" && finalBase == "" {
-		finalName = finalExt
+	if ext == "" {
+		return base
 	}
+	return base + "." + ext
+}
 
-	return finalName
+// truncateString cuts s to at most n bytes (identifiers are ASCII by now).
+func truncateString(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
 }
 
 // truncateJolietName truncates a name component if it exceeds JolietMaxFilenameChars (64 UCS-2 characters).
@@ -155,6 +168,20 @@ func truncateJolietName(originalName string) string {
 	return originalName
 }
 
+// truncateEnhancedName truncates a name component to the ISO 9660:1999 limit of EnhancedMaxNameBytes,
+// without splitting a UTF-8 sequence.
+func truncateEnhancedName(originalName string) string {
+	if len(originalName) <= EnhancedMaxNameBytes {
+		return originalName
+	}
+	cut := EnhancedMaxNameBytes
+	for cut > 0 && !utf8.RuneStart(originalName[cut]) {
+		cut--
+	}
+	log.Printf("Warning: ISO 9660:1999 name '%s' truncated to '%s' (%d byte limit)", originalName, originalName[:cut], EnhancedMaxNameBytes)
+	return originalName[:cut]
+}
+
 // formatTimestamp creates an ISO9660 17-byte timestamp string.
 // (ECMA-119 Section 8.4.26.1)
 // : if t is zero, returns a "not specified" timestamp (16 zeros + zero offset byte)
@@ -176,6 +203,21 @@ func formatTimestamp(t time.Time) []byte {
 	return tsBytes
 }
 
+// formatRecordingTime creates the 7-byte Directory Record date and time.
+// (ECMA-119 Section 9.1.5), also used by Rock Ridge TF entries.
+func formatRecordingTime(t time.Time) [7]byte {
+	t = t.UTC()
+	return [7]byte{
+		byte(t.Year() - 1900),
+		byte(t.Month()),
+		byte(t.Day()),
+		byte(t.Hour()),
+		byte(t.Minute()),
+		byte(t.Second()),
+		0, // GMT Offset (0 for UTC or local time unknown as per ECMA-119 9.1.5)
+	}
+}
+
 // encodeUTF16BE encodes a Go string to UCS-2 Big Endian bytes.
 func encodeUTF16BE(s string) []byte {
 	uint16s := utf16.Encode([]rune(s))
@@ -0,0 +1,214 @@
+package iso9660
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// VirtualImage is a laid out image that is never written out: an io.ReaderAt returning the bytes Build
+// would write. Metadata (system area, descriptors, path tables, directory listings, boot catalog) is
+// generated in memory, file data is read from the source files on demand, at their assigned sectors.
+// : safe for concurrent use. The source files must keep the size they were scanned with.
+// : source files stay open between reads (a bounded number of them), Close releases them.
+type VirtualImage struct {
+	segments []imageSegment // contiguous, covering the image from offset 0
+	size     int64
+
+	mu       sync.Mutex             // guards the fields below
+	files    map[string]*sourceFile // open source files, by disk path
+	useCount uint64                 // ticks of the least recently used order
+}
+
+// maxOpenSourceFiles is the number of source files a VirtualImage keeps open between reads.
+const maxOpenSourceFiles = 64
+
+// sourceFile is an open source file of a VirtualImage, shared by concurrent reads.
+type sourceFile struct {
+	file    *os.File
+	refs    int    // reads using the file
+	lastUse uint64 // useCount of the last read
+	dropped bool   // no longer cached, closed by the last read using it
+}
+
+// imageSegment is a byte range of a virtual image.
+type imageSegment struct {
+	offset, length int64
+	data           []byte // generated bytes
+	diskPath       string // file data, read from the source file (data is nil)
+	// neither: zeros
+}
+
+// VirtualImage scans the source directory (unless ScanSourceDirectory was called), lays out the image
+// and returns it as a VirtualImage instead of writing it.
+func (b *ISOBuilder) VirtualImage() (*VirtualImage, error) {
+	if err := b.prepareLayout(); err != nil {
+		return nil, err
+	}
+	v := &VirtualImage{size: int64(b.totalSectors) * SectorSize}
+	if err := b.writeImage(&imageWriter{virtual: v}); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Size returns the size of the image in bytes.
+func (v *VirtualImage) Size() int64 {
+	return v.size
+}
+
+// ReadAt implements io.ReaderAt.
+func (v *VirtualImage) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("reading virtual image: negative offset %d", off)
+	}
+	if off >= v.size {
+		return 0, io.EOF
+	}
+	// first segment ending after off
+	k := sort.Search(len(v.segments), func(i int) bool {
+		return v.segments[i].offset+v.segments[i].length > off
+	})
+	n := 0
+	for ; n < len(p) && k < len(v.segments); k++ {
+		seg := &v.segments[k]
+		pos := off + int64(n) - seg.offset // position in the segment
+		chunk := p[n : n+int(min(int64(len(p)-n), seg.length-pos))]
+		switch {
+		case seg.data != nil:
+			copy(chunk, seg.data[pos:])
+		case seg.diskPath != "":
+			if err := v.readSourceFileAt(seg.diskPath, chunk, pos); err != nil {
+				return n, err
+			}
+		default:
+			clear(chunk)
+		}
+		n += len(chunk)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Close closes the source files held open by ReadAt. The image stays usable, later reads reopen them.
+func (v *VirtualImage) Close() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var firstErr error
+	for diskPath, sf := range v.files {
+		if err := v.dropSourceFile(diskPath, sf); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// readSourceFileAt fills p from the source file at diskPath, which must still hold the scanned bytes.
+func (v *VirtualImage) readSourceFileAt(diskPath string, p []byte, off int64) error {
+	sf, err := v.acquireSourceFile(diskPath)
+	if err != nil {
+		return err
+	}
+	defer v.releaseSourceFile(sf)
+	if _, err := sf.file.ReadAt(p, off); err != nil {
+		if err == io.EOF {
+			return fmt.Errorf("size mismatch for '%s': file is shorter than scanned", diskPath)
+		}
+		return fmt.Errorf("reading '%s': %w", diskPath, err)
+	}
+	return nil
+}
+
+// acquireSourceFile returns the open file at diskPath, opening it (and closing the least recently used
+// file beyond maxOpenSourceFiles) if needed. The caller must release it.
+func (v *VirtualImage) acquireSourceFile(diskPath string) (*sourceFile, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.useCount++
+	if sf, ok := v.files[diskPath]; ok {
+		sf.refs++
+		sf.lastUse = v.useCount
+		return sf, nil
+	}
+	file, err := os.Open(diskPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening '%s': %w", diskPath, err)
+	}
+	if len(v.files) >= maxOpenSourceFiles {
+		var lruPath string
+		var lru *sourceFile
+		for path, sf := range v.files {
+			if lru == nil || sf.lastUse < lru.lastUse {
+				lruPath, lru = path, sf
+			}
+		}
+		v.dropSourceFile(lruPath, lru)
+	}
+	if v.files == nil {
+		v.files = make(map[string]*sourceFile)
+	}
+	sf := &sourceFile{file: file, refs: 1, lastUse: v.useCount}
+	v.files[diskPath] = sf
+	return sf, nil
+}
+
+// releaseSourceFile ends a read of sf, closing it if it was dropped from the cache meanwhile.
+func (v *VirtualImage) releaseSourceFile(sf *sourceFile) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	sf.refs--
+	if sf.dropped && sf.refs == 0 {
+		sf.file.Close()
+	}
+}
+
+// dropSourceFile removes a file from the cache, closing it now unless reads are using it (v.mu held).
+func (v *VirtualImage) dropSourceFile(diskPath string, sf *sourceFile) error {
+	delete(v.files, diskPath)
+	sf.dropped = true
+	if sf.refs == 0 {
+		return sf.file.Close()
+	}
+	return nil
+}
+
+// appendData records generated bytes at offset, the end of the image so far.
+func (v *VirtualImage) appendData(offset int64, p []byte) {
+	if last := v.lastSegment(); last != nil && last.data != nil {
+		last.data = append(last.data, p...)
+		last.length += int64(len(p))
+		return
+	}
+	v.segments = append(v.segments, imageSegment{offset: offset, length: int64(len(p)), data: append([]byte(nil), p...)})
+}
+
+// appendZeros records n zero bytes at offset.
+func (v *VirtualImage) appendZeros(offset, n int64) {
+	if n == 0 {
+		return
+	}
+	if last := v.lastSegment(); last != nil && last.data == nil && last.diskPath == "" {
+		last.length += n
+		return
+	}
+	v.segments = append(v.segments, imageSegment{offset: offset, length: n})
+}
+
+// appendFile records the size bytes of the file at diskPath at offset.
+func (v *VirtualImage) appendFile(offset int64, diskPath string, size int64) {
+	if size > 0 {
+		v.segments = append(v.segments, imageSegment{offset: offset, length: size, diskPath: diskPath})
+	}
+}
+
+// lastSegment returns the segment at the end of the image so far, nil for none.
+func (v *VirtualImage) lastSegment() *imageSegment {
+	if len(v.segments) == 0 {
+		return nil
+	}
+	return &v.segments[len(v.segments)-1]
+}
@@ -0,0 +1,60 @@
+package iso9660
+
+import (
+	"bytes"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// TestVirtualImageReadAt checks a VirtualImage reads as the image WriteTo writes, whole and in random
+// ranges read concurrently.
+func TestVirtualImageReadAt(t *testing.T) {
+	source := writeSourceTree(t, equivalenceTree())
+	var want bytes.Buffer
+	if _, err := newEquivalenceBuilder(source, 0).WriteTo(&want); err != nil {
+		t.Fatal(err)
+	}
+	v, err := newEquivalenceBuilder(source, 0).VirtualImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	if v.Size() != int64(want.Len()) {
+		t.Fatalf("virtual image is %d bytes, WriteTo wrote %d", v.Size(), want.Len())
+	}
+	whole := make([]byte, v.Size())
+	if n, err := v.ReadAt(whole, 0); n != len(whole) || err != nil {
+		t.Fatalf("ReadAt of the whole image: %d bytes, %v", n, err)
+	}
+	if !bytes.Equal(whole, want.Bytes()) {
+		t.Fatal("virtual image differs from the written image")
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for k := 0; k < 200; k++ {
+				off := rng.Int63n(v.Size())
+				p := make([]byte, rng.Intn(3*SectorSize))
+				n, err := v.ReadAt(p, off)
+				end := min(off+int64(len(p)), v.Size())
+				if int64(n) != end-off || (err != nil) != (end-off < int64(len(p))) {
+					t.Errorf("ReadAt(%d bytes, %d) = %d, %v", len(p), off, n, err)
+					return
+				}
+				if !bytes.Equal(p[:n], want.Bytes()[off:end]) {
+					t.Errorf("ReadAt(%d bytes, %d) differs from the written image", len(p), off)
+					return
+				}
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+	if len(v.files) > maxOpenSourceFiles {
+		t.Errorf("%d source files open, at most %d expected", len(v.files), maxOpenSourceFiles)
+	}
+}
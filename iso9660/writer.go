@@ -1,37 +1,43 @@
 package iso9660
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"sort"
 )
 
-// writeSystemArea writes the initial blank system area sectors.
-func (b *ISOBuilder) writeSystemArea(w io.WriteSeeker) error {
-	// System area is typically 16 sectors of zeros.
-	// writeAtSectorAndPad handles writing nil data as zeros for the allocated size.
-	if err := writeAtSectorAndPad(w, nil, 0, SystemAreaNumSectors*SectorSize); err != nil {
+// writeSystemArea writes the initial system area sectors.
+func (b *ISOBuilder) writeSystemArea(w *imageWriter) error {
+	// System area is typically 16 sectors of zeros, hybrid images carry MBR/GPT partition tables.
+	if err := writeAtSectorAndPad(w, b.createSystemArea(), 0, SystemAreaNumSectors*SectorSize); err != nil {
 		return fmt.Errorf("writing system area: %w", err)
 	}
 	return nil
 }
 
-// writeVolumeDescriptors writes the PVD, SVD, and Terminator to the ISO image.
-func (b *ISOBuilder) writeVolumeDescriptors(w io.WriteSeeker) error {
+// writeVolumeDescriptors writes the PVD, the El Torito Boot Record (if bootable), the SVDs of the other trees
+// (Joliet, Enhanced), and Terminator to the ISO image.
+func (b *ISOBuilder) writeVolumeDescriptors(w *imageWriter) error {
 	currentSector := uint32(SystemAreaNumSectors) // VDs start after the system area
 
-	pvd := b.createPrimaryVolumeDescriptor()
-	if err := writeAtSectorAndPad(w, pvd, int(currentSector), SectorSize); err != nil {
-		return fmt.Errorf("PVD write: %w", err)
-	}
-	currentSector++
+	for n, tree := range b.trees {
+		vd := b.createVolumeDescriptor(tree)
+		if err := writeAtSectorAndPad(w, vd, int(currentSector), SectorSize); err != nil {
+			return fmt.Errorf("%s VD write: %w", tree.kind, err)
+		}
+		currentSector++
 
-	svd := b.createJolietVolumeDescriptor()
-	if err := writeAtSectorAndPad(w, svd, int(currentSector), SectorSize); err != nil {
-		return fmt.Errorf("SVD write: %w", err)
+		if n == 0 && b.isBootable() { // Boot Record must precede the terminator, mkisofs puts it right after the PVD
+			br := b.createBootRecordVolumeDescriptor()
+			if err := writeAtSectorAndPad(w, br, int(currentSector), SectorSize); err != nil {
+				return fmt.Errorf("boot record write: %w", err)
+			}
+			currentSector++
+		}
 	}
-	currentSector++
 
 	term := b.createVolumeDescriptorTerminator()
 	if err := writeAtSectorAndPad(w, term, int(currentSector), SectorSize); err != nil {
@@ -40,144 +46,235 @@ func (b *ISOBuilder) writeVolumeDescriptors(w io.WriteSeeker) error {
 	return nil
 }
 
-// writeAllPathTables writes all four path tables (PVD L/M, SVD L/M) and their duplicates.
-func (b *ISOBuilder) writeAllPathTables(w io.WriteSeeker) error {
-	// PVD (ISO9660) Path Tables
-	pvdPtLAllocSize := int(sectorsToContainBytes(len(b.pvdPathTableLData)) * SectorSize) // Size on disk
-	if err := writeAtSectorAndPad(w, b.pvdPathTableLData, int(b.lbaPvdPathTableL), pvdPtLAllocSize); err != nil {
-		return fmt.Errorf("PVD L-PT (1st): %w", err)
-	}
-	if err := writeAtSectorAndPad(w, b.pvdPathTableLData, int(b.lbaPvdPathTableL2), pvdPtLAllocSize); err != nil {
-		return fmt.Errorf("PVD L-PT (2nd): %w", err)
-	}
-
-	pvdPtMAllocSize := int(sectorsToContainBytes(len(b.pvdPathTableMData)) * SectorSize) // Should be same as L-type size
-	if err := writeAtSectorAndPad(w, b.pvdPathTableMData, int(b.lbaPvdPathTableM), pvdPtMAllocSize); err != nil {
-		return fmt.Errorf("PVD M-PT (1st): %w", err)
-	}
-	if err := writeAtSectorAndPad(w, b.pvdPathTableMData, int(b.lbaPvdPathTableM2), pvdPtMAllocSize); err != nil {
-		return fmt.Errorf("PVD M-PT (2nd): %w", err)
-	}
-
-	// SVD (Joliet) Path Tables
-	svdPtLAllocSize := int(sectorsToContainBytes(len(b.svdPathTableLData)) * SectorSize)
-	if err := writeAtSectorAndPad(w, b.svdPathTableLData, int(b.lbaSvdPathTableL), svdPtLAllocSize); err != nil {
-		return fmt.Errorf("SVD L-PT (1st): %w", err)
-	}
-	if err := writeAtSectorAndPad(w, b.svdPathTableLData, int(b.lbaSvdPathTableL2), svdPtLAllocSize); err != nil {
-		return fmt.Errorf("SVD L-PT (2nd): %w", err)
-	}
-
-	svdPtMAllocSize := int(sectorsToContainBytes(len(b.svdPathTableMData)) * SectorSize)
-	if err := writeAtSectorAndPad(w, b.svdPathTableMData, int(b.lbaSvdPathTableM), svdPtMAllocSize); err != nil {
-		return fmt.Errorf("SVD M-PT (1st): %w", err)
+// writeAllPathTables writes the L-Type and M-Type path tables of every tree, then their duplicates
+// (the order of determinePathTableLBAs).
+func (b *ISOBuilder) writeAllPathTables(w *imageWriter) error {
+	for _, tree := range b.trees {
+		ptAllocSize := int(sectorsToContainBytes(len(tree.pathTableL)) * SectorSize) // Size on disk, the same for M-Type
+		if err := writeAtSectorAndPad(w, tree.pathTableL, int(tree.lbaPathTableL), ptAllocSize); err != nil {
+			return fmt.Errorf("%s L-PT (1st): %w", tree.kind, err)
+		}
+		if err := writeAtSectorAndPad(w, tree.pathTableM, int(tree.lbaPathTableM), ptAllocSize); err != nil {
+			return fmt.Errorf("%s M-PT (1st): %w", tree.kind, err)
+		}
 	}
-	if err := writeAtSectorAndPad(w, b.svdPathTableMData, int(b.lbaSvdPathTableM2), svdPtMAllocSize); err != nil {
-		return fmt.Errorf("SVD M-PT (2nd): %w", err)
+	for _, tree := range b.trees {
+		ptAllocSize := int(sectorsToContainBytes(len(tree.pathTableL)) * SectorSize)
+		if err := writeAtSectorAndPad(w, tree.pathTableL, int(tree.lbaPathTableL2), ptAllocSize); err != nil {
+			return fmt.Errorf("%s L-PT (2nd): %w", tree.kind, err)
+		}
+		if err := writeAtSectorAndPad(w, tree.pathTableM, int(tree.lbaPathTableM2), ptAllocSize); err != nil {
+			return fmt.Errorf("%s M-PT (2nd): %w", tree.kind, err)
+		}
 	}
 	return nil
 }
 
-// writeAllDirectoryContents writes the ISO9660 and Joliet directory listings for all directories.
-func (b *ISOBuilder) writeAllDirectoryContents(w io.WriteSeeker) error {
-	for i, f := range b.fileEntries {
-		if f.isDir {
-			// ISO9660 Directory Listing
-			isoListingBytes, err := b.createDirectoryListing(i, false)
-			if err != nil {
-				return fmt.Errorf("generating ISO9660 listing for '%s': %w", f.isoPath, err)
-			}
-			// f.iso9660Size is the pre-calc., sector-aligned allocated size for this directory's listing
-			if uint32(len(isoListingBytes)) > f.iso9660Size {
-				return fmt.Errorf("ISO9660 list for '%s'(%s) gen_len %d > alloc_size %d", f.isoPath, f.iso9660Name, len(isoListingBytes), f.iso9660Size)
-			}
-			if err := writeAtSectorAndPad(w, isoListingBytes, int(f.iso9660Sector), int(f.iso9660Size)); err != nil {
-				return fmt.Errorf("writing ISO9660 dir extent for '%s': %w", f.isoPath, err)
+// writeDirectoryContents writes the directory listings of the ISO9660 tree (first) or of the other trees
+// (after the file data), in the order of assignContentLBAs.
+func (b *ISOBuilder) writeDirectoryContents(w *imageWriter, iso9660Tree bool) error {
+	for _, tree := range b.trees {
+		if (tree.kind == treeISO9660) != iso9660Tree {
+			continue
+		}
+		var dirs []int
+		if tree.kind == treeISO9660 {
+			dirs = b.iso9660DirectoryExtentOrder()
+		} else {
+			for i := range b.fileEntries {
+				if b.fileEntries[i].isDir && b.fileEntries[i].inTree(tree.kind) { // RR_MOVED only exists in the ISO9660 tree
+					dirs = append(dirs, i)
+				}
 			}
-
-			// Joliet Directory Listing
-			jolietListingBytes, err := b.createDirectoryListing(i, true)
+		}
+		for _, i := range dirs {
+			f := &b.fileEntries[i]
+			listingBytes, err := b.createDirectoryListing(i, tree.kind)
 			if err != nil {
-				return fmt.Errorf("generating Joliet listing for '%s': %w", f.isoPath, err)
+				return fmt.Errorf("generating %s listing for '%s': %w", tree.kind, f.isoPath, err)
 			}
-			if uint32(len(jolietListingBytes)) > f.jolietSize {
-				return fmt.Errorf("joliet list for '%s'(%s) gen_len %d > alloc_size %d", f.isoPath, f.jolietName, len(jolietListingBytes), f.jolietSize)
+			// node.size is the pre-calc., sector-aligned allocated size for this directory's listing
+			node := f.nodes[tree.kind]
+			if uint32(len(listingBytes)) > node.size {
+				return fmt.Errorf("%s list for '%s'(%s) gen_len %d > alloc_size %d", tree.kind, f.isoPath, node.name, len(listingBytes), node.size)
 			}
-			if err := writeAtSectorAndPad(w, jolietListingBytes, int(f.jolietSector), int(f.jolietSize)); err != nil {
-				return fmt.Errorf("writing Joliet dir extent for '%s': %w", f.isoPath, err)
+			if err := writeAtSectorAndPad(w, listingBytes, int(node.sector), int(node.size)); err != nil {
+				return fmt.Errorf("writing %s dir extent for '%s': %w", tree.kind, f.isoPath, err)
 			}
 		}
 	}
 	return nil
 }
 
-// writeAllFileData writes the actual content of all files to the ISO image.
-func (b *ISOBuilder) writeAllFileData(w io.WriteSeeker) error {
-	for _, f := range b.fileEntries {
-		if !f.isDir {
-			fileDataBytes, err := os.ReadFile(f.diskPath)
-			if err != nil {
-				return fmt.Errorf("reading file '%s': %w", f.diskPath, err)
-			}
-			if uint32(len(fileDataBytes)) != f.iso9660Size { // iso9660Size and jolietSize are same for files
-				return fmt.Errorf("size mismatch for file '%s': scanned %d, actual %d", f.diskPath, f.iso9660Size, len(fileDataBytes))
-			}
-
-			// totalAllocatedBytesOnDisk is their data size rounded up to the nearest sector. : for files
-			numSectorsForFile := sectorsToContainFileBytes(f.iso9660Size)
-			allocatedBytesForFile := int(numSectorsForFile * SectorSize)
+// writeRockRidgeContinuationAreas writes the sectors holding Rock Ridge continuation areas (if any).
+func (b *ISOBuilder) writeRockRidgeContinuationAreas(w *imageWriter) error {
+	if b.rrContinuationSectors == 0 {
+		return nil
+	}
+	areaBytes := b.createRockRidgeContinuationAreas()
+	if err := writeAtSectorAndPad(w, areaBytes, int(b.lbaRockRidgeContinuation), len(areaBytes)); err != nil {
+		return fmt.Errorf("writing Rock Ridge continuation areas: %w", err)
+	}
+	return nil
+}
 
-			if err := writeAtSectorAndPad(w, fileDataBytes, int(f.iso9660Sector), allocatedBytesForFile); err != nil {
-				return fmt.Errorf("writing file data for '%s': %w", f.diskPath, err)
+// writeBootCatalog writes the El Torito boot catalog and the boot images pinned behind it (if bootable).
+// : boot images of the source tree are written here as well, writeAllFileData skips them.
+func (b *ISOBuilder) writeBootCatalog(w *imageWriter) error {
+	if !b.isBootable() {
+		return nil
+	}
+	catalog := b.createBootCatalog()
+	if err := writeAtSectorAndPad(w, catalog, int(b.lbaBootCatalog), int(sectorsToContainBytes(len(catalog))*SectorSize)); err != nil {
+		return fmt.Errorf("writing boot catalog: %w", err)
+	}
+	written := make(map[int]bool) // several entries may share an image of the tree
+	for _, img := range b.bootImages {
+		if img.fileIndex >= 0 {
+			if !written[img.fileIndex] {
+				if err := b.writeFileData(w, &b.fileEntries[img.fileIndex], nil); err != nil {
+					return err
+				}
+				written[img.fileIndex] = true
+			}
+			continue
+		}
+		if b.isAppendedESP(img.diskPath) {
+			continue
+		}
+		imageBytes := img.data
+		if imageBytes == nil {
+			var err error
+			if imageBytes, err = os.ReadFile(img.diskPath); err != nil {
+				return fmt.Errorf("reading boot image '%s': %w", img.diskPath, err)
 			}
 		}
+		if uint32(len(imageBytes)) != img.size {
+			return fmt.Errorf("size mismatch for boot image '%s': scanned %d, actual %d", img.diskPath, img.size, len(imageBytes))
+		}
+		b.patchBootImage(imageBytes, img.sector)
+		if err := writeAtSectorAndPad(w, imageBytes, int(img.sector), int(sectorsToContainFileBytes(img.size)*SectorSize)); err != nil {
+			return fmt.Errorf("writing boot image '%s': %w", img.diskPath, err)
+		}
 	}
 	return nil
 }
 
-// finalizeImageSize ensures the ISO file is padded or truncated to the exact total calculated size.
-func (b *ISOBuilder) finalizeImageSize(isoFile *os.File) error {
-	expectedImageSizeBytes := int64(b.totalSectors) * SectorSize
-	currentImageSizeBytes, err := isoFile.Seek(0, io.SeekEnd)
-	if err != nil {
-		return fmt.Errorf("seeking to end of ISO file: %w", err)
+// writeAllFileData writes the actual content of all files to the ISO image, in LBA order.
+// : with Options.ReadAheadWorkers, upcoming files are read concurrently (see writeFileDataReadAhead).
+func (b *ISOBuilder) writeAllFileData(w *imageWriter) error {
+	order := b.fileDataOrder()
+	if b.options.ReadAheadWorkers > 1 && w.virtual == nil { // virtual images read files on demand
+		return b.writeFileDataReadAhead(w, order)
 	}
+	for _, i := range order {
+		if err := b.writeFileData(w, &b.fileEntries[i], nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	if currentImageSizeBytes < expectedImageSizeBytes {
-		paddingBytesNeeded := expectedImageSizeBytes - currentImageSizeBytes
-		if paddingBytesNeeded > 0 {
-			chunkSizeBytes := int64(SectorSize * 128) // e.g., 256KB chunks
-			if chunkSizeBytes > paddingBytesNeeded {
-				chunkSizeBytes = paddingBytesNeeded
-			}
-			paddingChunk := make([]byte, chunkSizeBytes)
+// writeFileData writes the data extent of one file, from data when it was read ahead (nil otherwise).
+// : files are streamed from disk, memory use doesn't depend on their size. Only boot images that get
+// : boot info patched in are read whole (El Torito images are small).
+func (b *ISOBuilder) writeFileData(w *imageWriter, f *fileEntry, data []byte) error {
+	if err := w.canceled(); err != nil {
+		return err
+	}
+	b.reportFile(f.isoPath, w.offset)
+	// allocated size is the data size rounded up to the nearest sector
+	// (computed as int64, multi-extent files overflow uint32)
+	allocatedBytesForFile := int64(f.dataSectors()) * SectorSize
 
-			for paddingBytesNeeded > 0 {
-				bytesToWriteThisChunk := chunkSizeBytes
-				if paddingBytesNeeded < chunkSizeBytes {
-					bytesToWriteThisChunk = paddingBytesNeeded
-				}
-				n, errWrite := isoFile.Write(paddingChunk[:bytesToWriteThisChunk])
-				if errWrite != nil {
-					return fmt.Errorf("writing final image padding: %w", errWrite)
-				}
-				paddingBytesNeeded -= int64(n)
-			}
+	if data == nil && !b.isPatchedBootImage(f.dataSector()) {
+		if err := writeFileAtSectorAndPad(w, f.diskPath, f.size, int(f.dataSector()), allocatedBytesForFile); err != nil {
+			return fmt.Errorf("writing file data for '%s': %w", f.diskPath, err)
+		}
+		return nil
+	}
+	if data == nil {
+		var err error
+		if data, err = readFileExactly(f.diskPath, f.size); err != nil {
+			return err
 		}
-	} else if currentImageSizeBytes > expectedImageSizeBytes {
-		log.Printf("Warning: ISO image size %d bytes > expected %d bytes. Truncating.", currentImageSizeBytes, expectedImageSizeBytes)
-		if errTrunc := isoFile.Truncate(expectedImageSizeBytes); errTrunc != nil {
-			return fmt.Errorf("truncating final image: %w", errTrunc)
+	}
+	b.patchBootImage(data, f.dataSector()) // boot info tables of the entries using this file, if any
+	if err := writeAtSectorAndPad(w, data, int(f.dataSector()), int(allocatedBytesForFile)); err != nil {
+		return fmt.Errorf("writing file data for '%s': %w", f.diskPath, err)
+	}
+	return nil
+}
+
+// fileDataOrder returns the indices of the entries owning a data extent, in LBA order,
+// except the boot images pinned behind the boot catalog.
+func (b *ISOBuilder) fileDataOrder() []int {
+	pinned := make(map[int]bool) // boot images of the tree, written with the boot catalog
+	for _, img := range b.bootImages {
+		pinned[img.fileIndex] = true
+	}
+	var order []int
+	for i := range b.fileEntries {
+		if b.fileEntries[i].hasDataExtent() && !pinned[i] {
+			order = append(order, i)
 		}
 	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return b.fileEntries[order[i]].dataSector() < b.fileEntries[order[j]].dataSector()
+	})
+	return order
+}
+
+// readFileExactly reads the whole file at diskPath, which must still be the size it was scanned with.
+func readFileExactly(diskPath string, size int64) ([]byte, error) {
+	file, err := os.Open(diskPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading file '%s': %w", diskPath, err)
+	}
+	defer file.Close()
+	data := make([]byte, size)
+	if n, err := io.ReadFull(file, data); err != nil {
+		return nil, fmt.Errorf("size mismatch for file '%s': scanned %d, actual %d (%w)", diskPath, size, n, err)
+	}
+	var extra [1]byte
+	if n, _ := file.Read(extra[:]); n > 0 {
+		return nil, fmt.Errorf("size mismatch for file '%s': scanned %d, file has grown", diskPath, size)
+	}
+	return data, nil
+}
+
+// writeEFISystemPartition writes the appended EFI System Partition image (if any).
+func (b *ISOBuilder) writeEFISystemPartition(w *imageWriter) error {
+	if b.espSize == 0 {
+		return nil
+	}
+	allocatedBytes := int64(sectorsToContainFileBytes(b.espSize)) * SectorSize
+	if err := writeFileAtSectorAndPad(w, b.options.Hybrid.EFIImage, int64(b.espSize), int(b.lbaESP), allocatedBytes); err != nil {
+		return fmt.Errorf("writing EFI System Partition image: %w", err)
+	}
 	return nil
 }
 
-// writeAtSectorAndPad writes data to a specific sector in the WriteSeeker,
+// writeGPTBackup writes the backup GPT into the last sectors of the image (if any).
+func (b *ISOBuilder) writeGPTBackup(w *imageWriter) error {
+	numSectors := b.gptBackupSectors()
+	if numSectors == 0 {
+		return nil
+	}
+	return writeAtSectorAndPad(w, b.createGPTBackup(), int(b.totalSectors-numSectors), int(numSectors*SectorSize))
+}
+
+// writeTrailingPadding writes the zero sectors between the last content (ESP included) and the backup GPT,
+// or the end of the image.
+func (b *ISOBuilder) writeTrailingPadding(w *imageWriter) error {
+	return w.seekSector(int(b.totalSectors - b.gptBackupSectors()))
+}
+
+// writeAtSectorAndPad writes data to a specific sector of the image,
 // padding with zeros up to totalAllocatedBytesOnDisk.
 // sectorNum is 0-indexed.
 // totalAllocatedBytesOnDisk must be a multiple of SectorSize if > 0.
-func writeAtSectorAndPad(w io.WriteSeeker, data []byte, sectorNum int, totalAllocatedBytesOnDisk int) error {
+func writeAtSectorAndPad(w *imageWriter, data []byte, sectorNum int, totalAllocatedBytesOnDisk int) error {
 	if totalAllocatedBytesOnDisk > 0 && totalAllocatedBytesOnDisk%SectorSize != 0 {
 		// This indicates a logic error elsewhere in size calculation.
 		log.Panicf("writeAtSectorAndPad: totalAllocatedBytesOnDisk %d is not a multiple of SectorSize %d for sector %d", totalAllocatedBytesOnDisk, SectorSize, sectorNum)
@@ -189,9 +286,8 @@ func writeAtSectorAndPad(w io.WriteSeeker, data []byte, sectorNum int, totalAllo
 		return fmt.Errorf("negative totalAllocatedBytesOnDisk %d for sector %d", totalAllocatedBytesOnDisk, sectorNum)
 	}
 
-	targetOffset := int64(sectorNum) * int64(SectorSize)
-	if _, err := w.Seek(targetOffset, io.SeekStart); err != nil {
-		return fmt.Errorf("seeking to offset %d (sector %d): %w", targetOffset, sectorNum, err)
+	if err := w.seekSector(sectorNum); err != nil {
+		return err
 	}
 
 	bytesWritten := 0
@@ -211,22 +307,161 @@ func writeAtSectorAndPad(w io.WriteSeeker, data []byte, sectorNum int, totalAllo
 		return fmt.Errorf("internal error: negative padding %d (totalAlloc %d, written %d) for sector %d", paddingNeeded, totalAllocatedBytesOnDisk, bytesWritten, sectorNum)
 	}
 
-	if paddingNeeded > 0 {
-		padBuf := make([]byte, SectorSize)
-		for paddingNeeded > 0 {
-			chunkToWrite := len(padBuf)
-			if paddingNeeded < chunkToWrite {
-				chunkToWrite = paddingNeeded
-			}
-			n, err := w.Write(padBuf[:chunkToWrite])
-			if err != nil {
-				return fmt.Errorf("padding %d bytes at sector %d: %w", paddingNeeded, sectorNum, err)
-			}
-			if n != chunkToWrite {
-				return fmt.Errorf("short padding write sector %d: wrote %d/%d", sectorNum, n, chunkToWrite)
-			}
-			paddingNeeded -= n
+	if err := w.zeros(int64(paddingNeeded)); err != nil {
+		return fmt.Errorf("padding %d bytes at sector %d: %w", paddingNeeded, sectorNum, err)
+	}
+	return nil
+}
+
+// writeFileAtSectorAndPad streams the size bytes of the file at diskPath to a specific sector,
+// padding with zeros up to totalAllocatedBytesOnDisk.
+// : the file must still be the size it was scanned with, the layout depends on it.
+func writeFileAtSectorAndPad(w *imageWriter, diskPath string, size int64, sectorNum int, totalAllocatedBytesOnDisk int64) error {
+	if totalAllocatedBytesOnDisk%SectorSize != 0 || size > totalAllocatedBytesOnDisk {
+		log.Panicf("writeFileAtSectorAndPad: %d bytes of '%s' don't fit %d allocated bytes at sector %d", size, diskPath, totalAllocatedBytesOnDisk, sectorNum)
+	}
+	if w.virtual != nil { // served from the file on demand
+		if err := w.seekSector(sectorNum); err != nil {
+			return err
+		}
+		w.virtual.appendFile(w.offset, diskPath, size)
+		w.offset += size
+		return w.zeros(totalAllocatedBytesOnDisk - size)
+	}
+	file, err := os.Open(diskPath)
+	if err != nil {
+		return fmt.Errorf("opening '%s': %w", diskPath, err)
+	}
+	defer file.Close()
+
+	if err := w.seekSector(sectorNum); err != nil {
+		return err
+	}
+	// copied in chunks, so a large file doesn't hold off cancellation
+	var n int64
+	for n < size && err == nil {
+		if err = w.canceled(); err != nil {
+			return err
+		}
+		if n > 0 {
+			w.reportWritten()
 		}
+		var copied int64
+		copied, err = io.CopyN(w, file, min(size-n, cancelCheckBytes))
+		n += copied
+	}
+	if err == io.EOF {
+		return fmt.Errorf("size mismatch for '%s': scanned %d, actual %d", diskPath, size, n)
+	}
+	if err != nil {
+		return fmt.Errorf("copying '%s' to sector %d: %w", diskPath, sectorNum, err)
+	}
+	var extra [1]byte
+	if n, _ := file.Read(extra[:]); n > 0 {
+		return fmt.Errorf("size mismatch for '%s': scanned %d, file has grown", diskPath, size)
+	}
+
+	if err := w.zeros(totalAllocatedBytesOnDisk - size); err != nil {
+		return fmt.Errorf("padding '%s' at sector %d: %w", diskPath, sectorNum, err)
+	}
+	return nil
+}
+
+// cancelCheckBytes is the amount of file data copied between two checks of the build context
+// (and two progress reports).
+const cancelCheckBytes = 16 << 20
+
+// zeroSector is the source of all padding writes.
+var zeroSector [SectorSize]byte
+
+// writeZeros writes n zero bytes, a sector at a time.
+func writeZeros(w io.Writer, n int64) error {
+	for n > 0 {
+		chunk := zeroSector[:min(n, SectorSize)]
+		written, err := w.Write(chunk)
+		if err != nil {
+			return err
+		}
+		if written != len(chunk) {
+			return fmt.Errorf("short padding write: wrote %d/%d", written, len(chunk))
+		}
+		n -= int64(written)
+	}
+	return nil
+}
+
+// imageWriter emits the image strictly sequentially: every phase writes its sectors in LBA order,
+// the layout being fixed by calculateLayout, so the output needs no seeking (pipes, sockets, ...).
+type imageWriter struct {
+	w       io.Writer
+	ctx     context.Context // nil: never canceled
+	offset  int64           // bytes written so far
+	virtual *VirtualImage   // records the image instead of writing it to w, when set
+
+	onProgress func(bytesWritten int64) // called within large files, nil: no reporting
+}
+
+// reportWritten reports the bytes written so far, while a large file is copied.
+func (iw *imageWriter) reportWritten() {
+	if iw.onProgress != nil {
+		iw.onProgress(iw.offset)
+	}
+}
+
+// canceled returns the reason the build was canceled, nil while it goes on.
+func (iw *imageWriter) canceled() error {
+	if iw.ctx == nil {
+		return nil
+	}
+	if err := iw.ctx.Err(); err != nil {
+		return fmt.Errorf("build canceled: %w", err)
+	}
+	return nil
+}
+
+// Write implements io.Writer.
+func (iw *imageWriter) Write(p []byte) (int, error) {
+	if iw.virtual != nil {
+		iw.virtual.appendData(iw.offset, p)
+		iw.offset += int64(len(p))
+		return len(p), nil
+	}
+	n, err := iw.w.Write(p)
+	iw.offset += int64(n)
+	return n, err
+}
+
+// ReadFrom implements io.ReaderFrom, so streamed file data still reaches an *os.File through copy_file_range/sendfile.
+func (iw *imageWriter) ReadFrom(r io.Reader) (int64, error) {
+	if iw.virtual != nil { // recorded as generated data
+		data, err := io.ReadAll(r)
+		n, _ := iw.Write(data)
+		return int64(n), err
+	}
+	n, err := io.Copy(iw.w, r)
+	iw.offset += n
+	return n, err
+}
+
+// zeros writes n zero bytes (recorded as a zero range for virtual images).
+func (iw *imageWriter) zeros(n int64) error {
+	if iw.virtual != nil {
+		iw.virtual.appendZeros(iw.offset, n)
+		iw.offset += n
+		return nil
+	}
+	return writeZeros(iw, n)
+}
+
+// seekSector moves forward to sectorNum, zero-filling the sectors in between.
+// : a sector behind the current position is a layout/writer ordering bug.
+func (iw *imageWriter) seekSector(sectorNum int) error {
+	targetOffset := int64(sectorNum) * SectorSize
+	if targetOffset < iw.offset {
+		log.Panicf("InternalError: writing sector %d behind the current image offset %d", sectorNum, iw.offset)
+	}
+	if err := iw.zeros(targetOffset - iw.offset); err != nil {
+		return fmt.Errorf("zero-filling up to sector %d: %w", sectorNum, err)
 	}
 	return nil
 }
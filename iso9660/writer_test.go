@@ -0,0 +1,82 @@
+package iso9660
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestWriteFileAtSectorAndPad checks a file copied over several cancelCheckBytes chunks is written byte
+// for byte at its sector and padded, with a progress report between chunks.
+func TestWriteFileAtSectorAndPad(t *testing.T) {
+	const size = 2*cancelCheckBytes + 12345
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	diskPath := filepath.Join(t.TempDir(), "big.bin")
+	if err := os.WriteFile(diskPath, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	var reports []int64
+	w := &imageWriter{w: &buf, onProgress: func(written int64) { reports = append(reports, written) }}
+	const sector, allocated = 3, (size + SectorSize - 1) / SectorSize * SectorSize
+	if err := writeFileAtSectorAndPad(w, diskPath, size, sector, allocated); err != nil {
+		t.Fatal(err)
+	}
+	want := append(make([]byte, sector*SectorSize), data...)
+	want = append(want, make([]byte, allocated-size)...)
+	if w.offset != int64(len(want)) || !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("wrote %d bytes (offset %d), want the %d bytes of the file at sector %d, padded", buf.Len(), w.offset, len(want), sector)
+	}
+	start := int64(sector * SectorSize)
+	if wantReports := []int64{start + cancelCheckBytes, start + 2*cancelCheckBytes}; !slices.Equal(reports, wantReports) {
+		t.Errorf("progress reported at %v, want %v", reports, wantReports)
+	}
+}
+
+// TestSourceFileSizeChange checks a build fails when a file shrinks or grows between the scan and the
+// write of its data, whether it is streamed, read ahead or streamed past a chunk boundary.
+func TestSourceFileSizeChange(t *testing.T) {
+	tests := []struct {
+		name       string
+		size       int64
+		changeTo   int64
+		readAhead  int
+		wantSubstr string
+	}{
+		{"shrunk", 100, 50, 0, "actual 50"},
+		{"grown", 100, 150, 0, "has grown"},
+		{"shrunk read ahead", 100, 50, 4, "actual 50"},
+		{"grown read ahead", 100, 150, 4, "has grown"},
+		{"shrunk within the second chunk", cancelCheckBytes + 100, cancelCheckBytes + 1, 0, "actual"},
+		{"grown by one byte", cancelCheckBytes + 100, cancelCheckBytes + 101, 0, "has grown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := writeSourceTree(t, map[string]string{"keep.txt": "keep"})
+			diskPath := filepath.Join(source, "changing.bin")
+			if err := os.WriteFile(diskPath, make([]byte, tt.size), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			opts := DefaultOptions()
+			opts.ReadAheadWorkers = tt.readAhead
+			b := NewBuilder(source, "", opts)
+			if err := b.ScanSourceDirectory(); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Truncate(diskPath, tt.changeTo); err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			_, err := b.WriteTo(&buf)
+			if err == nil || !strings.Contains(err.Error(), "size mismatch") || !strings.Contains(err.Error(), tt.wantSubstr) {
+				t.Errorf("file of %d bytes changed to %d: %v, want a size mismatch (%s)", tt.size, tt.changeTo, err, tt.wantSubstr)
+			}
+		})
+	}
+}